@@ -0,0 +1,246 @@
+package cmd
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// defaultContextBudget is the number of tokens of history a chat keeps by default
+const defaultContextBudget = 3000
+
+const defaultChatSystemPrompt = "You are a personal assistant to help with generic user questions. You can provide information on a wide range of topics."
+
+// Conversation keeps the running message history of a chat session
+type Conversation struct {
+	System   string
+	Messages []Message
+	// Budget is the approximate number of tokens the history may use before
+	// the oldest turns are dropped. Zero disables trimming.
+	Budget int
+}
+
+// History returns the system prompt followed by every message in the conversation
+func (c *Conversation) History() []Message {
+	return append([]Message{{Role: RoleSystem, Content: c.System}}, c.Messages...)
+}
+
+// Add appends a message and trims the history so it fits the budget
+func (c *Conversation) Add(role Role, content string) {
+	c.Messages = append(c.Messages, Message{Role: role, Content: content})
+	c.trim()
+}
+
+// Reset forgets every message but keeps the system prompt
+func (c *Conversation) Reset() {
+	c.Messages = nil
+}
+
+// trim drops the oldest turns until the history fits the budget, always
+// keeping the most recent turn so there is something to answer. A turn is
+// a user message and everything answering it, so no answer is left
+// without its question.
+func (c *Conversation) trim() {
+	if c.Budget <= 0 {
+		return
+	}
+	for estimateTokens(c.History()) > c.Budget {
+		next := nextTurn(c.Messages)
+		if next < 0 {
+			return
+		}
+		c.Messages = c.Messages[next:]
+	}
+}
+
+// nextTurn returns where the turn after the first one starts, or -1 when
+// there is only one turn
+func nextTurn(messages []Message) int {
+	for i := 1; i < len(messages); i++ {
+		if messages[i].Role == RoleUser {
+			return i
+		}
+	}
+	return -1
+}
+
+// estimateTokens gives a rough token count using the ~4 characters per token rule of thumb
+func estimateTokens(messages []Message) int {
+	chars := 0
+	for _, message := range messages {
+		chars += len(message.Content)
+	}
+	return chars / 4
+}
+
+// chatCmd represents the chat command
+var chatCmd = &cobra.Command{
+	Use:   "chat",
+	Short: "have a conversation with the LLM",
+	Long: `use this command to start an interactive chat session that remembers the conversation so far
+
+Available commands inside the chat:
+  /reset, /clear  forget the conversation so far
+  /system <text>  replace the system prompt and start over
+  /save <file>    save the conversation as JSON
+  /exit           leave the chat`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+
+		provider, err := GetProvider(cmd)
+		if err != nil {
+			return err
+		}
+
+		var tools *ToolRegistry
+		if toolsFlag, _ := cmd.Flags().GetBool("tools"); toolsFlag {
+			tools, err = DefaultTools()
+			if err != nil {
+				return err
+			}
+		}
+
+		system, err := GetSystemPrompt(cmd, defaultChatSystemPrompt)
+		if err != nil {
+			return err
+		}
+
+		opts, err := GetSamplingOptions(cmd, CompletionOptions{MaxTokens: 400})
+		if err != nil {
+			return err
+		}
+		// Every turn carries on from a single answer
+		if opts.N > 1 {
+			if cmd.Flags().Changed("choices") {
+				return &Error{Kind: KindUsage, Err: errors.New("--choices cannot be used with chat"), Hint: "use question --choices to compare answers, and pick one to continue in a chat"}
+			}
+			log.Printf("Warning: chat keeps one answer per turn, ignoring CHOICES=%d", opts.N)
+			opts.N = 0
+		}
+
+		budget, _ := cmd.Flags().GetInt("context-budget")
+		conversation := &Conversation{System: system, Budget: budget}
+
+		return RunChat(cmd, provider, tools, conversation, opts)
+	},
+}
+
+// RunChat reads messages from the command's input and prints the answers
+// until the user leaves. The conversation may already hold earlier turns to
+// carry on from.
+func RunChat(cmd *cobra.Command, provider Provider, tools *ToolRegistry, conversation *Conversation, opts CompletionOptions) error {
+	stdout, stderr := cmd.OutOrStdout(), cmd.ErrOrStderr()
+	if tools != nil {
+		tools.Output = stderr
+	}
+
+	fmt.Fprintln(stdout, "Chat started, type /exit to leave")
+	reader := bufio.NewReader(cmd.InOrStdin())
+	for {
+		fmt.Fprint(stdout, "> ")
+		line, err := reader.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return err
+		}
+		input := strings.TrimSpace(line)
+
+		if strings.HasPrefix(input, "/") {
+			if !runChatCommand(cmd, conversation, input) {
+				return nil
+			}
+		} else if input != "" {
+			conversation.Add(RoleUser, input)
+
+			// A failed turn is reported and the chat carries on
+			completion, cerr := completeChat(provider, tools, conversation, opts)
+			if cerr == nil {
+				cerr = BlockedError(completion)
+			}
+			if cerr == nil && len(completion.Choices) == 0 {
+				cerr = errors.New("no answer received")
+			}
+			if cerr != nil {
+				// The question was not answered, so it is not part of the conversation
+				conversation.Messages = conversation.Messages[:len(conversation.Messages)-1]
+				PrintError(stderr, cerr)
+			} else {
+				reply := completion.Choices[0].Content
+				fmt.Fprintln(stdout, reply)
+				conversation.Add(RoleAssistant, reply)
+			}
+		}
+
+		if err == io.EOF {
+			return nil
+		}
+	}
+}
+
+// completeChat asks the provider to answer the conversation, running any
+// tool calls first when tools are enabled
+func completeChat(provider Provider, tools *ToolRegistry, conversation *Conversation, opts CompletionOptions) (*Completion, error) {
+	if tools == nil {
+		return provider.Complete(context.TODO(), conversation.History(), opts)
+	}
+	completion, _, err := CompleteWithTools(context.TODO(), provider, tools, conversation.History(), opts, DefaultMaxToolIterations)
+	return completion, err
+}
+
+// runChatCommand handles a slash command typed into the chat and reports
+// whether the session should continue
+func runChatCommand(cmd *cobra.Command, conversation *Conversation, input string) bool {
+	stdout, stderr := cmd.OutOrStdout(), cmd.ErrOrStderr()
+	name, arg, _ := strings.Cut(input, " ")
+	arg = strings.TrimSpace(arg)
+
+	switch name {
+	case "/exit", "/quit":
+		return false
+	case "/reset", "/clear":
+		conversation.Reset()
+		fmt.Fprintln(stdout, "Conversation cleared")
+	case "/system":
+		if arg == "" {
+			fmt.Fprintf(stdout, "Current system prompt: %s\n", conversation.System)
+			break
+		}
+		conversation.System = arg
+		conversation.Reset()
+		fmt.Fprintln(stdout, "System prompt updated, conversation cleared")
+	case "/save":
+		if arg == "" {
+			fmt.Fprintf(stderr, "Usage: /save <file>\n")
+			break
+		}
+		data, err := json.MarshalIndent(conversation.History(), "", "  ")
+		if err != nil {
+			PrintError(stderr, err)
+			break
+		}
+		if err := os.WriteFile(arg, data, 0o644); err != nil {
+			PrintError(stderr, err)
+			break
+		}
+		fmt.Fprintf(stdout, "Conversation saved to %s\n", arg)
+	default:
+		fmt.Fprintf(stderr, "Unknown command %s (try /reset, /system, /save or /exit)\n", name)
+	}
+	return true
+}
+
+func init() {
+	rootCmd.AddCommand(chatCmd)
+
+	chatCmd.Flags().BoolP("local", "l", false, "Use local model (same as --provider ollama)")
+	chatCmd.Flags().Bool("tools", false, "Let the model call the built-in tools (weather, time)")
+	chatCmd.Flags().Int("context-budget", defaultContextBudget, "approximate number of tokens of history to keep before dropping the oldest turns")
+	addSystemFlags(chatCmd)
+	addSamplingFlags(chatCmd)
+}
@@ -0,0 +1,235 @@
+package cmd
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestChat(t *testing.T) {
+	tests := []struct {
+		name      string
+		args      []string
+		stdin     string
+		responses []fakeResponse
+		// wantSent is the conversation the server receives in each request,
+		// one "role: content" line per message
+		wantSent   []string
+		env        map[string]string
+		wantStdout []string
+		wantStderr []string
+		wantCode   int
+	}{
+		{
+			name:      "history carries over between turns",
+			args:      []string{"chat", "--system", "Be brief."},
+			stdin:     "What is Go?\nWho made it?\n/exit\n",
+			responses: []fakeResponse{azureChat("A language."), azureChat("Google.")},
+			wantSent: []string{
+				"system: Be brief.\nuser: What is Go?",
+				"system: Be brief.\nuser: What is Go?\nassistant: A language.\nuser: Who made it?",
+			},
+			wantStdout: []string{"Chat started, type /exit to leave", "> A language.\n", "> Google.\n"},
+		},
+		{
+			name:       "exit stops reading",
+			args:       []string{"chat", "--system", "Be brief."},
+			stdin:      "/exit\nWhat is Go?\n",
+			wantStdout: []string{"Chat started"},
+		},
+		{
+			name:       "quit stops reading",
+			args:       []string{"chat", "--system", "Be brief."},
+			stdin:      "/quit\nWhat is Go?\n",
+			wantStdout: []string{"Chat started"},
+		},
+		{
+			name:      "reset forgets the conversation",
+			args:      []string{"chat", "--system", "Be brief."},
+			stdin:     "What is Go?\n/reset\nWho made it?\n",
+			responses: []fakeResponse{azureChat("A language."), azureChat("Which thing?")},
+			wantSent: []string{
+				"system: Be brief.\nuser: What is Go?",
+				"system: Be brief.\nuser: Who made it?",
+			},
+			wantStdout: []string{"Conversation cleared"},
+		},
+		{
+			name:      "clear is the same as reset",
+			args:      []string{"chat", "--system", "Be brief."},
+			stdin:     "What is Go?\n/clear\nWho made it?\n",
+			responses: []fakeResponse{azureChat("A language."), azureChat("Which thing?")},
+			wantSent: []string{
+				"system: Be brief.\nuser: What is Go?",
+				"system: Be brief.\nuser: Who made it?",
+			},
+			wantStdout: []string{"Conversation cleared"},
+		},
+		{
+			name:      "system replaces the prompt and starts over",
+			args:      []string{"chat", "--system", "Be brief."},
+			stdin:     "What is Go?\n/system\n/system Answer in French.\nWhat is Go?\n",
+			responses: []fakeResponse{azureChat("A language."), azureChat("Un langage.")},
+			wantSent: []string{
+				"system: Be brief.\nuser: What is Go?",
+				"system: Answer in French.\nuser: What is Go?",
+			},
+			wantStdout: []string{"Current system prompt: Be brief.", "System prompt updated, conversation cleared", "Un langage."},
+		},
+		{
+			name:       "unknown command",
+			args:       []string{"chat"},
+			stdin:      "/help\n",
+			wantStderr: []string{"Unknown command /help"},
+		},
+		{
+			name:      "failed turn is reported and the chat carries on",
+			args:      []string{"chat", "--system", "Be brief."},
+			stdin:     "What is Go?\nWhat is Go?\n",
+			responses: []fakeResponse{{Status: 400, Body: azureError("400", "Bad request")}, azureChat("A language.")},
+			wantSent: []string{
+				"system: Be brief.\nuser: What is Go?",
+				"system: Be brief.\nuser: What is Go?",
+			},
+			wantStdout: []string{"A language."},
+			wantStderr: []string{"Error: Azure OpenAI returned 400: Bad request"},
+		},
+		{
+			name:       "several choices",
+			args:       []string{"chat", "--choices", "2"},
+			stdin:      "What is Go?\n",
+			wantStderr: []string{"Error: --choices cannot be used with chat", "Hint: use question --choices"},
+			wantCode:   int(KindUsage),
+		},
+		{
+			name:      "choices setting ignored",
+			args:      []string{"chat", "--system", "Be brief."},
+			stdin:     "What is Go?\n",
+			env:       map[string]string{"CHOICES": "3"},
+			responses: []fakeResponse{azureChat("A language.")},
+			wantSent: []string{
+				"system: Be brief.\nuser: What is Go?",
+			},
+			wantStdout: []string{"A language."},
+			wantStderr: []string{"Warning: chat keeps one answer per turn, ignoring CHOICES=3"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := newFakeServer(t)
+			for key, value := range test.env {
+				server.Env[key] = value
+			}
+			server.Queue(routeAzureChat, test.responses...)
+
+			stdout, stderr, code := runCommandWithCode(t, server, test.stdin, test.args...)
+
+			if code != test.wantCode {
+				t.Errorf("exit code %d, want %d", code, test.wantCode)
+			}
+			for _, want := range test.wantStdout {
+				if !strings.Contains(stdout, want) {
+					t.Errorf("stdout %q does not contain %q", stdout, want)
+				}
+			}
+			for _, want := range test.wantStderr {
+				if !strings.Contains(stderr, want) {
+					t.Errorf("stderr %q does not contain %q", stderr, want)
+				}
+			}
+
+			requests := server.Requests(routeAzureChat)
+			for _, request := range requests {
+				if n, ok := request["n"]; ok {
+					t.Errorf("request asks for %v choices", n)
+				}
+			}
+			if len(requests) != len(test.wantSent) {
+				t.Fatalf("got %d requests, want %d", len(requests), len(test.wantSent))
+			}
+			for i, request := range requests {
+				if got := sentConversation(request); got != test.wantSent[i] {
+					t.Errorf("request %d sent\n%s\nwant\n%s", i+1, got, test.wantSent[i])
+				}
+			}
+		})
+	}
+}
+
+// sentConversation lists the messages of a request as "role: content" lines
+func sentConversation(request map[string]any) string {
+	var lines []string
+	messages, _ := request["messages"].([]any)
+	for _, message := range messages {
+		message, _ := message.(map[string]any)
+		lines = append(lines, fmt.Sprintf("%s: %s", message["role"], message["content"]))
+	}
+	return strings.Join(lines, "\n")
+}
+
+func TestConversationTrim(t *testing.T) {
+	// Each message is 40 characters, about 10 tokens
+	message := func(role Role, text string) Message {
+		return Message{Role: role, Content: text + strings.Repeat(".", 40-len(text))}
+	}
+
+	tests := []struct {
+		name     string
+		budget   int
+		messages []Message
+		add      Message
+		// want lists the first word of the messages kept
+		want []string
+	}{
+		{
+			name:     "fits the budget",
+			budget:   100,
+			messages: []Message{message(RoleUser, "one"), message(RoleAssistant, "two")},
+			add:      message(RoleUser, "three"),
+			want:     []string{"one", "two", "three"},
+		},
+		{
+			name:     "drops a whole turn",
+			budget:   25,
+			messages: []Message{message(RoleUser, "one"), message(RoleAssistant, "two")},
+			add:      message(RoleUser, "three"),
+			want:     []string{"three"},
+		},
+		{
+			name:     "drops an answer without its question",
+			budget:   35,
+			messages: []Message{message(RoleAssistant, "one"), message(RoleUser, "two"), message(RoleAssistant, "three")},
+			add:      message(RoleUser, "four"),
+			want:     []string{"two", "three", "four"},
+		},
+		{
+			name:     "keeps the answer with its question",
+			budget:   15,
+			messages: []Message{message(RoleUser, "one"), message(RoleAssistant, "two"), message(RoleUser, "three")},
+			add:      message(RoleAssistant, "four"),
+			want:     []string{"three", "four"},
+		},
+		{
+			name:     "no budget",
+			messages: []Message{message(RoleUser, "one"), message(RoleAssistant, "two")},
+			add:      message(RoleUser, "three"),
+			want:     []string{"one", "two", "three"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			conversation := &Conversation{Budget: test.budget, Messages: test.messages}
+			conversation.Add(test.add.Role, test.add.Content)
+
+			var got []string
+			for _, message := range conversation.Messages {
+				got = append(got, strings.TrimRight(message.Content, "."))
+			}
+			if strings.Join(got, " ") != strings.Join(test.want, " ") {
+				t.Errorf("kept %v, want %v", got, test.want)
+			}
+		})
+	}
+}
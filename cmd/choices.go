@@ -0,0 +1,243 @@
+package cmd
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"github.com/AlecAivazis/survey/v2"
+	"github.com/AlecAivazis/survey/v2/terminal"
+	"github.com/spf13/cobra"
+	"golang.org/x/term"
+)
+
+// Layouts accepted by the global --layout flag for several answers
+const (
+	LayoutAuto       = "auto"
+	LayoutNumbered   = "numbered"
+	LayoutSideBySide = "side-by-side"
+)
+
+// minColumnWidth is the narrowest column answers are shown side by side in
+const minColumnWidth = 30
+
+// columnSeparator goes between side by side answers
+const columnSeparator = " │ "
+
+// GetLayout returns the value of the --layout flag
+func GetLayout(cmd *cobra.Command) string {
+	layout, err := cmd.Flags().GetString("layout")
+	if err != nil || layout == "" {
+		return LayoutAuto
+	}
+	return layout
+}
+
+// validateLayout rejects unknown --layout values before a command runs
+func validateLayout(cmd *cobra.Command) error {
+	switch GetLayout(cmd) {
+	case LayoutAuto, LayoutNumbered, LayoutSideBySide:
+		return nil
+	}
+	return Errorf(KindUsage, "unknown layout %q (available: %s, %s, %s)", GetLayout(cmd), LayoutAuto, LayoutNumbered, LayoutSideBySide)
+}
+
+// WriteChoices shows several answers to w, one after the other under a
+// numbered heading or in columns. The auto layout uses columns when the
+// terminal is wide enough for all of them.
+func WriteChoices(w io.Writer, choices []Choice, layout string) {
+	width := terminalWidth(w)
+	fits := width >= len(choices)*minColumnWidth+(len(choices)-1)*len([]rune(columnSeparator))
+	if layout == LayoutSideBySide || (layout == LayoutAuto && fits) {
+		if width == 0 {
+			width = 160
+		}
+		writeSideBySide(w, choices, width)
+		return
+	}
+	writeNumbered(w, choices)
+}
+
+func writeNumbered(w io.Writer, choices []Choice) {
+	for i, choice := range choices {
+		if i > 0 {
+			fmt.Fprintln(w)
+		}
+		fmt.Fprintf(w, "── Answer %d ──\n%s\n", choice.Index+1, strings.TrimSpace(choice.Content))
+	}
+}
+
+func writeSideBySide(w io.Writer, choices []Choice, width int) {
+	separator := len([]rune(columnSeparator))
+	columnWidth := max((width-(len(choices)-1)*separator)/len(choices), 1)
+
+	columns := make([][]string, len(choices))
+	rows := 0
+	for i, choice := range choices {
+		columns[i] = append([]string{fmt.Sprintf("Answer %d", choice.Index+1), strings.Repeat("─", columnWidth)}, wrapText(strings.TrimSpace(choice.Content), columnWidth)...)
+		rows = max(rows, len(columns[i]))
+	}
+
+	for row := 0; row < rows; row++ {
+		cells := make([]string, len(columns))
+		for i, column := range columns {
+			cell := ""
+			if row < len(column) {
+				cell = column[row]
+			}
+			cells[i] = cell + strings.Repeat(" ", columnWidth-len([]rune(cell)))
+		}
+		fmt.Fprintln(w, strings.TrimRight(strings.Join(cells, columnSeparator), " "))
+	}
+}
+
+// wrapText breaks text into lines of at most width characters, at spaces
+// where possible, keeping the line breaks already in the text
+func wrapText(text string, width int) []string {
+	var lines []string
+	for _, paragraph := range strings.Split(text, "\n") {
+		line := []rune{}
+		for _, word := range strings.Fields(paragraph) {
+			runes := []rune(word)
+			if len(line) > 0 && len(line)+1+len(runes) > width {
+				lines = append(lines, string(line))
+				line = line[:0]
+			}
+			// Words longer than a line are split
+			for len(runes) > width {
+				if len(line) > 0 {
+					lines = append(lines, string(line))
+					line = line[:0]
+				}
+				lines = append(lines, string(runes[:width]))
+				runes = runes[width:]
+			}
+			if len(line) > 0 {
+				line = append(line, ' ')
+			}
+			line = append(line, runes...)
+		}
+		lines = append(lines, string(line))
+	}
+	return lines
+}
+
+// terminalWidth returns the width of the terminal w writes to, COLUMNS when
+// it is not a terminal, or 0 when neither is known
+func terminalWidth(w io.Writer) int {
+	if file, ok := w.(*os.File); ok && term.IsTerminal(int(file.Fd())) {
+		if width, _, err := term.GetSize(int(file.Fd())); err == nil {
+			return width
+		}
+	}
+	width, _ := strconv.Atoi(os.Getenv("COLUMNS"))
+	return width
+}
+
+// canPick reports whether the user can be asked to pick an answer, which
+// needs a terminal on both ends and the text output
+func canPick(cmd *cobra.Command) bool {
+	return StdinIsTerminal(cmd) && isTerminal(cmd.OutOrStdout()) && GetOutputFormat(cmd) == OutputText
+}
+
+// Actions offered for the picked answer
+const (
+	actionCopy     = "Copy to the clipboard"
+	actionSave     = "Save to a file"
+	actionContinue = "Continue the conversation"
+	actionPick     = "Pick another answer"
+	actionDone     = "Done"
+)
+
+// PickChoice lets the user choose one of several answers and copy it, save
+// it or carry on the conversation from it. It returns the index of the
+// choice in completion.Choices and whether to continue the conversation.
+func PickChoice(completion *Completion) (int, bool, error) {
+	options := make([]string, len(completion.Choices))
+	for i, choice := range completion.Choices {
+		options[i] = fmt.Sprintf("Answer %d: %s", choice.Index+1, summarize(choice.Content, 60))
+	}
+
+	picked := 0
+	for {
+		if err := survey.AskOne(&survey.Select{Message: "Pick an answer:", Options: options, Default: options[picked]}, &picked); err != nil {
+			return picked, false, ignoreInterrupt(err)
+		}
+		choice := completion.Choices[picked]
+
+		for action := ""; action != actionPick; {
+			prompt := &survey.Select{
+				Message: fmt.Sprintf("What do you want to do with answer %d?", choice.Index+1),
+				Options: []string{actionCopy, actionSave, actionContinue, actionPick, actionDone},
+			}
+			if err := survey.AskOne(prompt, &action); err != nil {
+				return picked, false, ignoreInterrupt(err)
+			}
+
+			switch action {
+			case actionCopy:
+				if err := CopyToClipboard(choice.Content); err != nil {
+					PrintError(os.Stderr, err)
+				} else {
+					fmt.Fprintln(os.Stderr, "Copied to the clipboard")
+				}
+			case actionSave:
+				path := ""
+				input := &survey.Input{Message: "Save to:", Default: fmt.Sprintf("answer-%d.md", choice.Index+1)}
+				if err := survey.AskOne(input, &path); err != nil {
+					return picked, false, ignoreInterrupt(err)
+				}
+				if err := os.WriteFile(path, []byte(choice.Content+"\n"), 0o644); err != nil {
+					PrintError(os.Stderr, err)
+				} else {
+					fmt.Fprintf(os.Stderr, "Saved to %s\n", path)
+				}
+			case actionContinue:
+				return picked, true, nil
+			case actionDone:
+				return picked, false, nil
+			}
+		}
+	}
+}
+
+// ignoreInterrupt treats Ctrl-C in the picker as being done with it
+func ignoreInterrupt(err error) error {
+	if errors.Is(err, terminal.InterruptErr) {
+		return nil
+	}
+	return err
+}
+
+// clipboardCommands are tried in order to copy text, before falling back to
+// the OSC 52 escape sequence that most terminals understand
+var clipboardCommands = [][]string{
+	{"pbcopy"},
+	{"wl-copy"},
+	{"xclip", "-selection", "clipboard"},
+	{"xsel", "--clipboard", "--input"},
+	{"clip.exe"},
+}
+
+// CopyToClipboard puts text on the system clipboard
+func CopyToClipboard(text string) error {
+	for _, command := range clipboardCommands {
+		if _, err := exec.LookPath(command[0]); err != nil {
+			continue
+		}
+		clip := exec.Command(command[0], command[1:]...)
+		clip.Stdin = strings.NewReader(text)
+		return clip.Run()
+	}
+
+	if !term.IsTerminal(int(os.Stdout.Fd())) {
+		return errors.New("no clipboard available")
+	}
+	_, err := fmt.Fprintf(os.Stdout, "\x1b]52;c;%s\a", base64.StdEncoding.EncodeToString([]byte(text)))
+	return err
+}
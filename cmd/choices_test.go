@@ -0,0 +1,150 @@
+package cmd
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestWrapText(t *testing.T) {
+	tests := []struct {
+		name  string
+		text  string
+		width int
+		want  []string
+	}{
+		{name: "short", text: "Hello world", width: 20, want: []string{"Hello world"}},
+		{name: "wrapped at spaces", text: "the quick brown fox jumps", width: 10, want: []string{"the quick", "brown fox", "jumps"}},
+		{name: "line breaks kept", text: "one\n\ntwo", width: 10, want: []string{"one", "", "two"}},
+		{name: "long words split", text: "a abcdefghijkl b", width: 5, want: []string{"a", "abcde", "fghij", "kl b"}},
+		{name: "multibyte", text: "héllo wörld", width: 5, want: []string{"héllo", "wörld"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := wrapText(test.text, test.width)
+			if strings.Join(got, "|") != strings.Join(test.want, "|") {
+				t.Errorf("got %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestWriteChoices(t *testing.T) {
+	choices := []Choice{{Index: 0, Content: "Red is warm."}, {Index: 1, Content: "Blue is calm and cool."}}
+
+	tests := []struct {
+		name    string
+		layout  string
+		columns string
+		want    string
+	}{
+		{
+			name:   "numbered when the width is unknown",
+			layout: LayoutAuto,
+			want:   "── Answer 1 ──\nRed is warm.\n\n── Answer 2 ──\nBlue is calm and cool.\n",
+		},
+		{
+			name:    "numbered in a narrow terminal",
+			layout:  LayoutAuto,
+			columns: "50",
+			want:    "── Answer 1 ──\nRed is warm.\n\n── Answer 2 ──\nBlue is calm and cool.\n",
+		},
+		{
+			name:    "side by side in a wide terminal",
+			layout:  LayoutAuto,
+			columns: "63",
+			want: "Answer 1                       │ Answer 2\n" +
+				"────────────────────────────── │ ──────────────────────────────\n" +
+				"Red is warm.                   │ Blue is calm and cool.\n",
+		},
+		{
+			name:    "forced side by side wraps",
+			layout:  LayoutSideBySide,
+			columns: "23",
+			want: "Answer 1   │ Answer 2\n" +
+				"────────── │ ──────────\n" +
+				"Red is     │ Blue is\n" +
+				"warm.      │ calm and\n" +
+				"           │ cool.\n",
+		},
+		{
+			name:    "forced numbered",
+			layout:  LayoutNumbered,
+			columns: "200",
+			want:    "── Answer 1 ──\nRed is warm.\n\n── Answer 2 ──\nBlue is calm and cool.\n",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Setenv("COLUMNS", test.columns)
+			var out bytes.Buffer
+			WriteChoices(&out, choices, test.layout)
+			if out.String() != test.want {
+				t.Errorf("got\n%s\nwant\n%s", out.String(), test.want)
+			}
+		})
+	}
+}
+
+func TestQuestionChoices(t *testing.T) {
+	tests := []struct {
+		name       string
+		args       []string
+		wantStdout []string
+		wantStderr []string
+		wantCode   int
+	}{
+		{
+			name:       "numbered",
+			args:       []string{"question", "--choices", "2", "Name a colour"},
+			wantStdout: []string{"── Answer 1 ──\nRed\n\n── Answer 2 ──\nBlue\n"},
+			wantStderr: []string{"Finish reason[0]: stop", "Finish reason[1]: stop"},
+		},
+		{
+			name:       "side by side",
+			args:       []string{"question", "--choices", "2", "--layout", "side-by-side", "Name a colour"},
+			wantStdout: []string{"Answer 1", "│ Answer 2", "Red"},
+		},
+		{
+			name:       "markdown",
+			args:       []string{"question", "--choices", "2", "-o", "markdown", "Name a colour"},
+			wantStdout: []string{"## Answer 1\n\nRed", "## Answer 2\n\nBlue"},
+		},
+		{
+			name:       "unknown layout",
+			args:       []string{"question", "--layout", "grid", "Name a colour"},
+			wantStderr: []string{`Error: unknown layout "grid"`},
+			wantCode:   int(KindUsage),
+		},
+		{
+			name:       "stream",
+			args:       []string{"question", "--choices", "2", "--stream", "Name a colour"},
+			wantStderr: []string{"Error: --stream cannot be used with --choices"},
+			wantCode:   int(KindUsage),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := newFakeServer(t)
+			server.Queue(routeAzureChat, azureChat("Red", "Blue"))
+
+			stdout, stderr, code := runCommandWithCode(t, server, "", test.args...)
+			if code != test.wantCode {
+				t.Errorf("exit code %d, want %d", code, test.wantCode)
+			}
+			for _, want := range test.wantStdout {
+				if !strings.Contains(stdout, want) {
+					t.Errorf("stdout %q does not contain %q", stdout, want)
+				}
+			}
+			for _, want := range test.wantStderr {
+				if !strings.Contains(stderr, want) {
+					t.Errorf("stderr %q does not contain %q", stderr, want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,248 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/joho/godotenv"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+)
+
+// Config is the merged result of every configuration layer. Settings are
+// keyed by their environment variable name, e.g. AZURE_OPENAI_ENDPOINT.
+type Config struct {
+	Profile string
+	Path    string
+	Values  map[string]string
+	// Sources records which layer each value came from
+	Sources map[string]string
+	// Models holds the context windows and prices set in the config file
+	Models map[string]ModelInfo
+	// Personas maps persona names to their system prompt
+	Personas map[string]string
+}
+
+// configFile is the layout of ~/.config/go-cli-gpt/config.yaml. Keys are
+// case insensitive environment variable names.
+//
+//	llm_provider: azure
+//	profile: dev
+//	profiles:
+//	  dev:
+//	    azure_openai_endpoint: https://my-dev.openai.azure.com
+//	  prod:
+//	    azure_openai_endpoint: https://my-prod.openai.azure.com
+//	models:
+//	  my-gpt4o-deployment:
+//	    context_window: 128000
+//	    input_price: 5
+//	    output_price: 15
+//	personas:
+//	  reviewer: You are a strict code reviewer.
+type configFile struct {
+	Profile  string                       `yaml:"profile"`
+	Profiles map[string]map[string]string `yaml:"profiles"`
+	Models   map[string]ModelInfo         `yaml:"models"`
+	Personas map[string]string            `yaml:"personas"`
+	Settings map[string]string            `yaml:",inline"`
+}
+
+// defaultConfig holds the built-in defaults, the lowest configuration layer
+var defaultConfig = map[string]string{
+	"LLM_PROVIDER":   "azure",
+	"MAX_ATTEMPTS":   "4",
+	"WEATHER_SOURCE": "open-meteo",
+}
+
+// activeConfig is the configuration loaded before any command runs
+var activeConfig = &Config{Values: map[string]string{}, Sources: map[string]string{}}
+
+// DefaultConfigPath returns ~/.config/go-cli-gpt/config.yaml, honouring XDG_CONFIG_HOME
+func DefaultConfigPath() string {
+	dir := os.Getenv("XDG_CONFIG_HOME")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return ""
+		}
+		dir = filepath.Join(home, ".config")
+	}
+	return filepath.Join(dir, "go-cli-gpt", "config.yaml")
+}
+
+// LoadConfig merges built-in defaults, the config file (with the selected
+// profile applied on top), the project .env file and the environment, in
+// increasing order of precedence. The merged values are exported to the
+// environment so every command can keep reading settings with os.Getenv.
+// Flags take precedence over all of these and are read by each command.
+func LoadConfig(path string, profile string, explicitPath bool) (*Config, error) {
+	config := &Config{Path: path, Values: map[string]string{}, Sources: map[string]string{}}
+	set := func(values map[string]string, source string) {
+		for key, value := range values {
+			key = strings.ToUpper(key)
+			config.Values[key] = value
+			config.Sources[key] = source
+		}
+	}
+
+	set(defaultConfig, "default")
+
+	var file configFile
+	data, err := os.ReadFile(path)
+	switch {
+	case err == nil:
+		if err := yaml.Unmarshal(data, &file); err != nil {
+			return nil, fmt.Errorf("parsing config file %s: %w", path, err)
+		}
+	case errors.Is(err, fs.ErrNotExist) && !explicitPath:
+		// The config file is optional unless it was asked for with --config
+	case errors.Is(err, fs.ErrNotExist):
+		return nil, &Error{Kind: KindConfigMissing, Err: fmt.Errorf("reading config file: %w", err), Hint: "check the path given to --config"}
+	default:
+		return nil, fmt.Errorf("reading config file: %w", err)
+	}
+	set(file.Settings, path)
+	config.Models = file.Models
+	config.Personas = file.Personas
+
+	if profile == "" {
+		profile = os.Getenv("GO_CLI_GPT_PROFILE")
+	}
+	if profile == "" {
+		profile = file.Profile
+	}
+	if profile != "" {
+		settings, ok := file.Profiles[profile]
+		if !ok {
+			names := make([]string, 0, len(file.Profiles))
+			for name := range file.Profiles {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			hint := "define it under profiles: in the config file"
+			if len(names) > 0 {
+				hint = "use one of the profiles in the config file: " + strings.Join(names, ", ")
+			}
+			return nil, &Error{Kind: KindUsage, Err: fmt.Errorf("profile %q not found in %s", profile, path), Hint: hint}
+		}
+		set(settings, path+" (profile "+profile+")")
+	}
+	config.Profile = profile
+
+	// A missing .env is fine, the variables may already be exported
+	if dotenv, err := godotenv.Read(); err == nil {
+		set(dotenv, ".env")
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("reading .env file: %w", err)
+	}
+
+	for key := range config.Values {
+		if value, ok := os.LookupEnv(key); ok {
+			config.Values[key] = value
+			config.Sources[key] = "environment"
+			continue
+		}
+		if err := os.Setenv(key, config.Values[key]); err != nil {
+			return nil, err
+		}
+	}
+
+	return config, nil
+}
+
+// loadConfigForCommand is run before every command to load the configuration
+// selected by the --config and --profile flags
+func loadConfigForCommand(cmd *cobra.Command, args []string) error {
+	configFlag := cmd.Flags().Lookup("config")
+	path := DefaultConfigPath()
+	explicitPath := configFlag != nil && configFlag.Changed
+	if explicitPath {
+		path = configFlag.Value.String()
+	}
+	profile, _ := cmd.Flags().GetString("profile")
+
+	config, err := LoadConfig(path, profile, explicitPath)
+	if err != nil {
+		return err
+	}
+	activeConfig = config
+	return nil
+}
+
+// configCmd represents the config command
+var configCmd = &cobra.Command{
+	Use:   "config",
+	Short: "show the effective configuration",
+	Long: `use this command to see every setting after merging the configuration layers and where each value came from
+
+Layers, from lowest to highest precedence:
+  built-in defaults
+  ~/.config/go-cli-gpt/config.yaml (or --config), with the selected --profile on top
+  .env in the current directory
+  environment variables
+  command line flags`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		w := cmd.OutOrStdout()
+		fmt.Fprintf(w, "Config file: %s\n", activeConfig.Path)
+		if activeConfig.Profile != "" {
+			fmt.Fprintf(w, "Profile: %s\n", activeConfig.Profile)
+		}
+
+		keys := make([]string, 0, len(activeConfig.Values))
+		for key := range activeConfig.Values {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			value := activeConfig.Values[key]
+			if strings.Contains(key, "KEY") || strings.Contains(key, "SECRET") || strings.Contains(key, "TOKEN") {
+				value = maskSecret(value)
+			}
+			fmt.Fprintf(w, "%s=%s\t(%s)\n", key, value, activeConfig.Sources[key])
+		}
+
+		for _, name := range modelNames(activeConfig.Models) {
+			model := activeConfig.Models[name]
+			fmt.Fprintf(w, "Model %s: context window %d, $%g/$%g per million input/output tokens\t(%s)\n", name, model.ContextWindow, model.InputPrice, model.OutputPrice, activeConfig.Path)
+		}
+		for _, name := range personaNames() {
+			fmt.Fprintf(w, "Persona %s: %s\t(%s)\n", name, summarize(activeConfig.Personas[name], 60), activeConfig.Path)
+		}
+		return nil
+	},
+}
+
+// RequireSettings returns a config error naming every one of the settings
+// that is not set
+func RequireSettings(names ...string) error {
+	var missing []string
+	for _, name := range names {
+		if os.Getenv(name) == "" {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) > 0 {
+		return Errorf(KindConfigMissing, "unable to continue, missing %s", strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// maskSecret hides all but the last four characters of a secret
+func maskSecret(value string) string {
+	if len(value) <= 4 {
+		return strings.Repeat("*", len(value))
+	}
+	return strings.Repeat("*", len(value)-4) + value[len(value)-4:]
+}
+
+func init() {
+	rootCmd.AddCommand(configCmd)
+}
@@ -0,0 +1,301 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// configKeys are the settings TestLoadConfig sets in its layers
+var configKeys = []string{"GO_CLI_GPT_PROFILE", "LLM_PROVIDER", "MAX_ATTEMPTS", "WEATHER_SOURCE", "AZURE_OPENAI_ENDPOINT", "OLLAMA_MODEL"}
+
+func TestLoadConfig(t *testing.T) {
+	const file = `max_attempts: 2
+azure_openai_endpoint: https://file.openai.azure.com
+ollama_model: llama3
+profiles:
+  dev:
+    azure_openai_endpoint: https://dev.openai.azure.com
+    max_attempts: 3
+  prod:
+    azure_openai_endpoint: https://prod.openai.azure.com
+`
+
+	tests := []struct {
+		name     string
+		file     string
+		noFile   bool
+		explicit bool
+		profile  string
+		dotenv   string
+		env      map[string]string
+		// want maps settings to their value and the source they came from,
+		// with "file" standing for the config file path
+		want        map[string][2]string
+		wantProfile string
+		wantErr     string
+		wantCode    ErrorKind
+	}{
+		{
+			name:   "defaults without a config file or .env",
+			noFile: true,
+			want: map[string][2]string{
+				"LLM_PROVIDER":   {"azure", "default"},
+				"MAX_ATTEMPTS":   {"4", "default"},
+				"WEATHER_SOURCE": {"open-meteo", "default"},
+			},
+		},
+		{
+			name: "config file over defaults",
+			file: file,
+			want: map[string][2]string{
+				"LLM_PROVIDER":          {"azure", "default"},
+				"MAX_ATTEMPTS":          {"2", "file"},
+				"AZURE_OPENAI_ENDPOINT": {"https://file.openai.azure.com", "file"},
+			},
+		},
+		{
+			name:    "profile over the config file",
+			file:    file,
+			profile: "dev",
+			want: map[string][2]string{
+				"MAX_ATTEMPTS":          {"3", "file (profile dev)"},
+				"AZURE_OPENAI_ENDPOINT": {"https://dev.openai.azure.com", "file (profile dev)"},
+				"OLLAMA_MODEL":          {"llama3", "file"},
+			},
+			wantProfile: "dev",
+		},
+		{
+			name: "profile selected in the config file",
+			file: "profile: prod\n" + file,
+			want: map[string][2]string{
+				"AZURE_OPENAI_ENDPOINT": {"https://prod.openai.azure.com", "file (profile prod)"},
+			},
+			wantProfile: "prod",
+		},
+		{
+			name: "profile from the environment over the config file",
+			file: "profile: prod\n" + file,
+			env:  map[string]string{"GO_CLI_GPT_PROFILE": "dev"},
+			want: map[string][2]string{
+				"AZURE_OPENAI_ENDPOINT": {"https://dev.openai.azure.com", "file (profile dev)"},
+			},
+			wantProfile: "dev",
+		},
+		{
+			name:    "profile flag over the environment",
+			file:    file,
+			profile: "prod",
+			env:     map[string]string{"GO_CLI_GPT_PROFILE": "dev"},
+			want: map[string][2]string{
+				"AZURE_OPENAI_ENDPOINT": {"https://prod.openai.azure.com", "file (profile prod)"},
+			},
+			wantProfile: "prod",
+		},
+		{
+			name:    ".env over the profile",
+			file:    file,
+			profile: "dev",
+			dotenv:  "MAX_ATTEMPTS=5\nLLM_PROVIDER=ollama\n",
+			want: map[string][2]string{
+				"MAX_ATTEMPTS":          {"5", ".env"},
+				"LLM_PROVIDER":          {"ollama", ".env"},
+				"AZURE_OPENAI_ENDPOINT": {"https://dev.openai.azure.com", "file (profile dev)"},
+			},
+			wantProfile: "dev",
+		},
+		{
+			name:    "environment over .env",
+			file:    file,
+			profile: "dev",
+			dotenv:  "MAX_ATTEMPTS=5\n",
+			env:     map[string]string{"MAX_ATTEMPTS": "6", "AZURE_OPENAI_ENDPOINT": "https://env.openai.azure.com"},
+			want: map[string][2]string{
+				"MAX_ATTEMPTS":          {"6", "environment"},
+				"AZURE_OPENAI_ENDPOINT": {"https://env.openai.azure.com", "environment"},
+			},
+			wantProfile: "dev",
+		},
+		{
+			name:     "unknown profile",
+			file:     file,
+			profile:  "staging",
+			wantErr:  `profile "staging" not found`,
+			wantCode: KindUsage,
+		},
+		{
+			name:     "missing config file asked for with --config",
+			noFile:   true,
+			explicit: true,
+			wantErr:  "reading config file",
+			wantCode: KindConfigMissing,
+		},
+		{
+			name:     "invalid config file",
+			file:     "profiles: [dev\n",
+			wantErr:  "parsing config file",
+			wantCode: KindUnknown,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// Every key starts unset, and is put back as it was afterwards
+			for _, key := range configKeys {
+				t.Setenv(key, "")
+				os.Unsetenv(key)
+			}
+			for key, value := range test.env {
+				t.Setenv(key, value)
+			}
+
+			dir := t.TempDir()
+			chdir(t, dir)
+			if test.dotenv != "" {
+				if err := os.WriteFile(".env", []byte(test.dotenv), 0o600); err != nil {
+					t.Fatal(err)
+				}
+			}
+			path := filepath.Join(dir, "config.yaml")
+			if !test.noFile {
+				if err := os.WriteFile(path, []byte(test.file), 0o600); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			config, err := LoadConfig(path, test.profile, test.explicit)
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) || ExitCode(err) != int(test.wantCode) {
+					t.Fatalf("got error %v (exit code %d), want %q (exit code %d)", err, ExitCode(err), test.wantErr, test.wantCode)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if config.Profile != test.wantProfile {
+				t.Errorf("profile %q, want %q", config.Profile, test.wantProfile)
+			}
+			for key, want := range test.want {
+				source := strings.Replace(want[1], "file", path, 1)
+				if config.Values[key] != want[0] || config.Sources[key] != source {
+					t.Errorf("%s is %q from %q, want %q from %q", key, config.Values[key], config.Sources[key], want[0], source)
+				}
+				if got := os.Getenv(key); got != want[0] {
+					t.Errorf("%s is exported as %q, want %q", key, got, want[0])
+				}
+			}
+		})
+	}
+}
+
+func TestConfigCommand(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "go-cli-gpt", "config.yaml")
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	file := `log_format: plain
+service_token: abcd1234
+llm_provider: ollama
+profiles:
+  dev:
+    log_format: json
+models:
+  my-gpt:
+    context_window: 128000
+    input_price: 5
+    output_price: 15
+personas:
+  reviewer: You are a strict code reviewer.
+`
+	if err := os.WriteFile(path, []byte(file), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		args       []string
+		wantStdout []string
+		wantStderr []string
+		wantCode   int
+	}{
+		{
+			name: "effective configuration",
+			args: []string{"config", "--profile", "dev"},
+			wantStdout: []string{
+				"Config file: " + path,
+				"Profile: dev",
+				"LOG_FORMAT=json\t(" + path + " (profile dev))",
+				"SERVICE_TOKEN=****1234\t(" + path + ")",
+				"WEATHER_SOURCE=fixture\t(environment)",
+				"Model my-gpt: context window 128000, $5/$15 per million input/output tokens",
+				"Persona reviewer: You are a strict code reviewer.",
+			},
+		},
+		{
+			name:       "unknown profile",
+			args:       []string{"config", "--profile", "staging"},
+			wantStderr: []string{`Error: profile "staging" not found`, "Hint: use one of the profiles in the config file: dev"},
+			wantCode:   int(KindUsage),
+		},
+		{
+			name:       "missing config file",
+			args:       []string{"config", "--config", filepath.Join(dir, "missing.yaml")},
+			wantStderr: []string{"Error: reading config file", "Hint: check the path given to --config"},
+			wantCode:   int(KindConfigMissing),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := newFakeServer(t)
+			server.Env["XDG_CONFIG_HOME"] = dir
+
+			stdout, stderr, code := runCommandWithCode(t, server, "", test.args...)
+			if code != test.wantCode {
+				t.Errorf("exit code %d, want %d", code, test.wantCode)
+			}
+			for _, want := range test.wantStdout {
+				if !strings.Contains(stdout, want) {
+					t.Errorf("stdout %q does not contain %q", stdout, want)
+				}
+			}
+			for _, want := range test.wantStderr {
+				if !strings.Contains(stderr, want) {
+					t.Errorf("stderr %q does not contain %q", stderr, want)
+				}
+			}
+		})
+	}
+}
+
+func TestProviderFlagOverridesConfig(t *testing.T) {
+	server := newFakeServer(t)
+	server.Env["LLM_PROVIDER"] = "azure"
+	server.Queue(routeOllamaChat, ollamaChat("A language from Google."))
+
+	_, stderr, code := runCommandWithCode(t, server, "", "question", "--provider", "ollama", "What is Go?")
+
+	if code != 0 {
+		t.Fatalf("exit code %d, stderr %q", code, stderr)
+	}
+	if requests := server.Requests(routeOllamaChat); len(requests) != 1 {
+		t.Errorf("got %d Ollama requests, want the flag to select Ollama over LLM_PROVIDER", len(requests))
+	}
+}
+
+// chdir changes the working directory for the rest of the test
+func chdir(t *testing.T, dir string) {
+	t.Helper()
+	previous, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(previous) })
+}
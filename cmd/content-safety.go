@@ -0,0 +1,125 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// FinishReasonContentFilter is the finish reason of an answer cut short by the content filter
+const FinishReasonContentFilter = "content_filter"
+
+// String describes a verdict, e.g. "Hate: severity high, filtered" or
+// "Jailbreak: not detected"
+func (r ContentFilterResult) String() string {
+	parts := r.verdict()
+	if r.Filtered {
+		parts = append(parts, "filtered")
+	}
+	if len(parts) == 0 {
+		parts = append(parts, "no verdict")
+	}
+	return r.Category + ": " + strings.Join(parts, ", ")
+}
+
+// verdict lists what the filter found, apart from whether it filtered
+func (r ContentFilterResult) verdict() []string {
+	var parts []string
+	if r.Severity != "" {
+		parts = append(parts, "severity "+r.Severity)
+	}
+	if r.Detected != nil {
+		if *r.Detected {
+			parts = append(parts, "detected")
+		} else {
+			parts = append(parts, "not detected")
+		}
+	}
+	if r.Details != "" {
+		parts = append(parts, r.Details)
+	}
+	return parts
+}
+
+// Blocked reports whether the content filter stopped this answer
+func (c Choice) Blocked() bool {
+	return c.FinishReason == FinishReasonContentFilter || anyFiltered(c.ContentFilter)
+}
+
+// PromptBlocked reports whether the content filter flagged the prompt
+func (c *Completion) PromptBlocked() bool {
+	return anyFiltered(c.PromptFilter)
+}
+
+func anyFiltered(results []ContentFilterResult) bool {
+	for _, result := range results {
+		if result.Filtered {
+			return true
+		}
+	}
+	return false
+}
+
+// filteredSummary lists the categories that were filtered, e.g.
+// "Hate (severity high), Jailbreak (detected)"
+func filteredSummary(results []ContentFilterResult) string {
+	var filtered []string
+	for _, result := range results {
+		if !result.Filtered {
+			continue
+		}
+		if verdict := result.verdict(); len(verdict) > 0 {
+			filtered = append(filtered, result.Category+" ("+strings.Join(verdict, ", ")+")")
+		} else {
+			filtered = append(filtered, result.Category)
+		}
+	}
+	if len(filtered) == 0 {
+		return "no category given"
+	}
+	return strings.Join(filtered, ", ")
+}
+
+// WriteSafetyReport writes the content filter verdicts for the prompt and
+// every answer. Nothing is written when the provider reported none.
+func WriteSafetyReport(w io.Writer, completion *Completion) {
+	if len(completion.PromptFilter) > 0 || completion.PromptFilterError != "" {
+		fmt.Fprintf(w, "Content filter results for the prompt\n")
+		writeFilterResults(w, completion.PromptFilter, completion.PromptFilterError)
+	}
+	for _, choice := range completion.Choices {
+		if len(choice.ContentFilter) > 0 || choice.FilterError != "" {
+			fmt.Fprintf(w, "Content filter results for answer %d\n", choice.Index)
+			writeFilterResults(w, choice.ContentFilter, choice.FilterError)
+		}
+	}
+}
+
+func writeFilterResults(w io.Writer, results []ContentFilterResult, filterError string) {
+	if filterError != "" {
+		fmt.Fprintf(w, "  Error: %s\n", filterError)
+	}
+	for _, result := range results {
+		fmt.Fprintf(w, "  %s\n", result)
+	}
+}
+
+// BlockedError returns a content filtered error when the prompt was flagged
+// or every answer was blocked, so an empty answer is never silently accepted
+func BlockedError(completion *Completion) error {
+	if completion.PromptBlocked() {
+		return Errorf(KindContentFiltered, "the prompt was blocked by the content filter: %s", filteredSummary(completion.PromptFilter))
+	}
+	if len(completion.Choices) == 0 {
+		return nil
+	}
+
+	var filtered []ContentFilterResult
+	for _, choice := range completion.Choices {
+		if !choice.Blocked() {
+			return nil
+		}
+		filtered = append(filtered, choice.ContentFilter...)
+	}
+	return Errorf(KindContentFiltered, "the answer was blocked by the content filter: %s", filteredSummary(filtered))
+}
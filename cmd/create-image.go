@@ -1,104 +1,283 @@
 package cmd
 
 import (
-	"bufio"
 	"context"
+	"encoding/base64"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
 	"os"
+	"strings"
+	"time"
 
 	"github.com/Azure/azure-sdk-for-go/sdk/ai/azopenai"
 	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
 	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
-	"github.com/joho/godotenv"
 	"github.com/spf13/cobra"
 )
 
+// Image response formats accepted by the --format flag
+const (
+	ImageFormatURL = "url"
+	ImageFormatB64 = "b64"
+)
+
 var imageCmd = &cobra.Command{
-	Use:   "image",
+	Use:   "image [prompt]",
 	Short: "Create an image from a prompt",
 	Long:  `Create an image from a prompt using the OpenAI API and DALLE<X> model`,
-	Run: func(cmd *cobra.Command, args []string) {
+	RunE: func(cmd *cobra.Command, args []string) error {
 
-		if err := godotenv.Load(); err != nil {
-			fmt.Fprintf(os.Stderr, "Error loading .env file\n")
-			return
+		if err := RequireSettings("DALLE_MODEL_NAME"); err != nil {
+			return err
 		}
-
 		deploymentName := os.Getenv("DALLE_MODEL_NAME")
-		azureOpenAIEndpoint := os.Getenv("AZURE_OPENAI_ENDPOINT")
-		azureOpenAIKey := os.Getenv("AZURE_OPENAI_API_KEY")
-
-		keyCredential := azcore.NewKeyCredential(azureOpenAIKey)
-
-		// Get question from user input
-		reader := bufio.NewReader(os.Stdin)
-		fmt.Print("What image do you want to create? ")
-		prompt, _ := reader.ReadString('\n')
-		fmt.Println("Creating image based on your prompt... ", prompt)
-
-		client, err := azopenai.NewClientWithKeyCredential(azureOpenAIEndpoint, keyCredential, nil)
 
+		format, err := GetImageFormat(cmd)
 		if err != nil {
-			// TODO: Update with application specific error handling logic
-			log.Printf("ERROR: %s", err)
-			return
+			return err
 		}
 
-		resp, err := client.GetImageGenerations(context.TODO(), azopenai.ImageGenerationOptions{
-			Prompt:         to.Ptr(prompt),
+		model, err := LookupImageModel(deploymentName)
+		if err != nil {
+			return err
+		}
+		options := azopenai.ImageGenerationOptions{
 			ResponseFormat: to.Ptr(azopenai.ImageGenerationResponseFormatURL),
 			DeploymentName: &deploymentName,
-		}, nil)
+		}
+		if err := GetImageOptions(cmd, model, &options); err != nil {
+			return err
+		}
 
+		preview, err := GetPreviewProtocol(cmd)
 		if err != nil {
-			//  TODO: Update the following line with application-specific error handling logic
-			log.Fatalf("ERROR: %s", err)
+			return err
 		}
 
-		for _, generatedImage := range resp.Data {
-			// use 'azopenai.ImageGenerationResponseFormatURL'
-			resp, err := http.Head(*generatedImage.URL)
+		if batch, _ := cmd.Flags().GetString("batch"); batch != "" {
+			if len(args) > 0 {
+				return Errorf(KindUsage, "--batch takes the prompts from the file, not the arguments")
+			}
+			if preview != "" {
+				return Errorf(KindUsage, "--preview cannot be used with --batch")
+			}
+			return RunImageBatch(cmd, batch, model, options, format)
+		}
 
-			if err != nil {
-				log.Fatalf("ERROR: %s", err)
+		// Get the prompt from the arguments, stdin or user input
+		prompt, err := GetInput(cmd, args, "What image do you want to create? ")
+		if err != nil {
+			return err
+		}
+		if prompt == "" {
+			return &Error{Kind: KindUsage, Err: errors.New("no prompt given"), Hint: "pass the prompt as arguments or pipe it on stdin"}
+		}
+
+		// Base64 images only exist in the response, so they are always saved
+		var saver *ImageSaver
+		if wantsDownload(cmd) || format == ImageFormatB64 {
+			if saver, err = GetImageSaver(cmd); err != nil {
+				return err
 			}
+		}
+		fmt.Fprintln(cmd.ErrOrStderr(), "Creating image based on your prompt... ", prompt)
 
-			fmt.Fprintf(os.Stderr, "Image generated, HEAD request on URL returned %d \n", resp.StatusCode)
-			fmt.Fprintf(os.Stdout, "Image URL: %s\n", *generatedImage.URL)
+		client, err := NewAzureClient()
+		if err != nil {
+			return err
+		}
 
-			downloadFlag := cmd.Flags().Lookup("download")
-			if downloadFlag != nil && downloadFlag.Changed {
-				fmt.Println("Downloading image...")
-				url := *generatedImage.URL
+		options.Prompt = to.Ptr(prompt)
+		start := time.Now()
+		images, created, err := GenerateImages(context.TODO(), client, options, format, saver != nil || preview != "", cmd.ErrOrStderr())
+		if err != nil {
+			return err
+		}
+		latency := time.Since(start)
 
-				response, err := http.Get(url)
-				if err != nil {
-					log.Fatal(err)
-				}
-				defer response.Body.Close()
+		// The history keeps where each image can be found again
+		var locations []string
+		defer func() {
+			RecordHistory(cmd, HistoryEntry{Provider: "azure", Model: deploymentName, Prompt: prompt, Response: strings.Join(locations, "\n")})
+		}()
+
+		for i, generated := range images {
+			result := Result{Command: cmd.Name(), Model: deploymentName, Index: i, Prompt: prompt, ImageURL: generated.URL, RevisedPrompt: generated.RevisedPrompt, LatencyMS: latency.Milliseconds()}
 
-				file, err := os.CreateTemp("/tmp", "*.jpg")
+			if saver != nil {
+				path, err := saver.Write(generated.Data, generated.ContentType, NewImageMetadata(options, generated, created), i+1)
 				if err != nil {
-					log.Fatal("Error creating file:", err)
-					return
+					return err
 				}
-				defer file.Close()
+				fmt.Fprintf(cmd.ErrOrStderr(), "Image saved to %s\n", path)
+				result.ImagePath = path
+			}
 
-				_, err = io.Copy(file, response.Body)
-				if err != nil {
-					log.Fatal(err)
+			if result.ImageURL != "" {
+				locations = append(locations, result.ImageURL)
+			} else if result.ImagePath != "" {
+				locations = append(locations, result.ImagePath)
+			}
+
+			if err := WriteImageResult(cmd, result); err != nil {
+				return err
+			}
+
+			// A preview that fails is not worth losing the image over
+			if preview != "" {
+				if err := PreviewImage(cmd.OutOrStdout(), generated.Data, preview); err != nil {
+					log.Printf("Warning: cannot preview image %d: %s", i+1, err)
 				}
-				log.Println("Success!\nYour image has been downloaded and stored in your /tmp folder with the filename: ", file.Name())
 			}
 		}
+		return nil
 	},
 }
 
+// GeneratedImage is one image created for a prompt, with its data when it
+// was asked for
+type GeneratedImage struct {
+	URL           string
+	RevisedPrompt string
+	Data          []byte
+	ContentType   string
+}
+
+// GenerateImages asks the deployment for the images described by options,
+// falling back to URLs when base64 images are not supported. With fetch the
+// image data is decoded from the response or downloaded from the URL.
+func GenerateImages(ctx context.Context, client *azopenai.Client, options azopenai.ImageGenerationOptions, format string, fetch bool, progress io.Writer) ([]GeneratedImage, time.Time, error) {
+	options.ResponseFormat = to.Ptr(azopenai.ImageGenerationResponseFormatURL)
+	if format == ImageFormatB64 {
+		options.ResponseFormat = to.Ptr(azopenai.ImageGenerationResponseFormatBase64)
+	}
+
+	resp, err := client.GetImageGenerations(ctx, options, nil)
+	if err != nil && format == ImageFormatB64 && unsupportedResponseFormat(err) {
+		log.Printf("Warning: the %s deployment does not support base64 images, asking for URLs instead", *options.DeploymentName)
+		options.ResponseFormat = to.Ptr(azopenai.ImageGenerationResponseFormatURL)
+		resp, err = client.GetImageGenerations(ctx, options, nil)
+	}
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+
+	created := time.Now()
+	if resp.Created != nil {
+		created = *resp.Created
+	}
+
+	images := make([]GeneratedImage, len(resp.Data))
+	for i, data := range resp.Data {
+		if data.URL != nil {
+			images[i].URL = *data.URL
+		}
+		if data.RevisedPrompt != nil {
+			images[i].RevisedPrompt = *data.RevisedPrompt
+		}
+		if !fetch {
+			continue
+		}
+
+		switch {
+		case data.Base64Data != nil:
+			images[i].Data, err = base64.StdEncoding.DecodeString(*data.Base64Data)
+			if err != nil {
+				return nil, created, fmt.Errorf("decoding image %d: %w", i+1, err)
+			}
+		case data.URL != nil:
+			fmt.Fprintln(progress, "Downloading image...")
+			images[i].Data, images[i].ContentType, err = fetchImage(ctx, images[i].URL)
+			if err != nil {
+				return nil, created, err
+			}
+		default:
+			return nil, created, fmt.Errorf("image %d came back without a URL or data", i+1)
+		}
+	}
+	return images, created, nil
+}
+
+// NewImageMetadata describes a generated image for its sidecar
+func NewImageMetadata(options azopenai.ImageGenerationOptions, generated GeneratedImage, created time.Time) ImageMetadata {
+	meta := ImageMetadata{Prompt: *options.Prompt, RevisedPrompt: generated.RevisedPrompt, Model: *options.DeploymentName, URL: generated.URL, Created: created}
+	if options.Size != nil {
+		meta.Size = string(*options.Size)
+	}
+	if options.Quality != nil {
+		meta.Quality = string(*options.Quality)
+	}
+	if options.Style != nil {
+		meta.Style = string(*options.Style)
+	}
+	return meta
+}
+
+// WriteImageResult prints a generated image in the format selected with --output
+func WriteImageResult(cmd *cobra.Command, result Result) error {
+	w := cmd.OutOrStdout()
+	switch GetOutputFormat(cmd) {
+	case OutputJSON:
+		return WriteResult(w, result)
+	case OutputMarkdown:
+		source := result.ImageURL
+		if source == "" {
+			source = result.ImagePath
+		}
+		fmt.Fprintf(w, "![%s](%s)\n", strings.TrimSpace(result.Prompt), source)
+		if result.RevisedPrompt != "" {
+			fmt.Fprintf(w, "\n_Revised prompt: %s_\n", result.RevisedPrompt)
+		}
+		if result.ImagePath != "" {
+			fmt.Fprintf(w, "\nSaved to `%s`\n", result.ImagePath)
+		}
+	default:
+		if result.ImageURL != "" {
+			fmt.Fprintf(w, "Image URL: %s\n", result.ImageURL)
+		} else if result.ImagePath != "" {
+			fmt.Fprintf(w, "Image file: %s\n", result.ImagePath)
+		}
+		if result.RevisedPrompt != "" {
+			fmt.Fprintf(w, "Revised prompt: %s\n", result.RevisedPrompt)
+		}
+	}
+	return nil
+}
+
+// GetImageFormat returns the --format flag, falling back to the IMAGE_FORMAT setting
+func GetImageFormat(cmd *cobra.Command) (string, error) {
+	format, _ := cmd.Flags().GetString("format")
+	if !cmd.Flags().Changed("format") && os.Getenv("IMAGE_FORMAT") != "" {
+		format = os.Getenv("IMAGE_FORMAT")
+	}
+	switch format {
+	case ImageFormatURL, ImageFormatB64:
+		return format, nil
+	}
+	return "", Errorf(KindUsage, "unknown image format %q (available: %s, %s)", format, ImageFormatURL, ImageFormatB64)
+}
+
+// unsupportedResponseFormat reports whether the service rejected the request
+// because of the response format it asked for
+func unsupportedResponseFormat(err error) bool {
+	var respErr *azcore.ResponseError
+	if !errors.As(err, &respErr) || respErr.StatusCode != http.StatusBadRequest {
+		return false
+	}
+	return strings.Contains(ClassifyError(err).Error(), "response_format")
+}
+
 func init() {
 	rootCmd.AddCommand(imageCmd)
 
 	imageCmd.Flags().BoolP("download", "d", false, "download image to local device")
+	imageCmd.Flags().String("out-dir", "", "directory to save images in, implies --download (default $IMAGE_DIR or the temp directory)")
+	imageCmd.Flags().String("filename", "", "template for image file names, implies --download (default \""+defaultImageFilename+"\")")
+	addImageFlags(imageCmd)
+	addPreviewFlag(imageCmd)
+	addBatchFlags(imageCmd)
+	imageCmd.Flags().String("format", ImageFormatURL, "how the service returns images: url, or b64 to get the image data in the response and save it without a second download")
 }
@@ -0,0 +1,487 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestImage(t *testing.T) {
+	tests := []struct {
+		name       string
+		args       []string
+		stdin      string
+		responses  []fakeResponse
+		wantPrompt string
+		wantStdout []string
+		wantStderr []string
+		wantCode   int
+	}{
+		{
+			name:       "image url",
+			args:       []string{"image", "a cat wearing a hat"},
+			responses:  []fakeResponse{{}},
+			wantPrompt: "a cat wearing a hat",
+			wantStdout: []string{"Image URL: ", "/images/cat.png", "Revised prompt: a tabby cat wearing a top hat"},
+		},
+		{
+			name:       "prompt from stdin",
+			stdin:      "a lighthouse at dusk\n",
+			args:       []string{"image"},
+			responses:  []fakeResponse{{}},
+			wantPrompt: "a lighthouse at dusk",
+			wantStdout: []string{"/images/cat.png"},
+		},
+		{
+			name:       "markdown output",
+			args:       []string{"image", "-o", "markdown", "a cat"},
+			responses:  []fakeResponse{{}},
+			wantPrompt: "a cat",
+			wantStdout: []string{"![a cat](", "/images/cat.png)"},
+		},
+		{
+			name:       "prompt rejected",
+			args:       []string{"image", "something violent"},
+			responses:  []fakeResponse{{Status: http.StatusBadRequest, Body: azureError("contentFilter", "Your request was rejected as a result of our safety system.")}},
+			wantPrompt: "something violent",
+			wantStderr: []string{"Error: Azure OpenAI returned 400: Your request was rejected as a result of our safety system.", "Hint: the prompt or answer was blocked"},
+			wantCode:   int(KindContentFiltered),
+		},
+		{
+			name:       "no prompt",
+			args:       []string{"image"},
+			wantStderr: []string{"Error: no prompt given"},
+			wantCode:   int(KindUsage),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := newFakeServer(t)
+			for _, response := range test.responses {
+				if response.Status == 0 {
+					response = server.azureImages("/images/cat.png")
+				}
+				server.Queue(routeAzureImages, response)
+			}
+
+			stdout, stderr, code := runCommandWithCode(t, server, test.stdin, test.args...)
+
+			if code != test.wantCode {
+				t.Errorf("exit code %d, want %d", code, test.wantCode)
+			}
+
+			for _, want := range test.wantStdout {
+				if !strings.Contains(stdout, want) {
+					t.Errorf("stdout %q does not contain %q", stdout, want)
+				}
+			}
+			for _, want := range test.wantStderr {
+				if !strings.Contains(stderr, want) {
+					t.Errorf("stderr %q does not contain %q", stderr, want)
+				}
+			}
+
+			requests := server.Requests(routeAzureImages)
+			if test.wantPrompt == "" {
+				if len(requests) != 0 {
+					t.Errorf("got %d requests, want none", len(requests))
+				}
+				return
+			}
+			if len(requests) != 1 {
+				t.Fatalf("got %d requests, want 1", len(requests))
+			}
+			if got := requests[0]["prompt"]; got != test.wantPrompt {
+				t.Errorf("sent prompt %q, want %q", got, test.wantPrompt)
+			}
+		})
+	}
+}
+
+func TestImageDownload(t *testing.T) {
+	// The fake server reports the images as created at this time
+	created := time.Unix(1700000000, 0)
+
+	tests := []struct {
+		name     string
+		args     []string
+		wantFile string
+	}{
+		{name: "default name", args: []string{"--download"}, wantFile: created.Format("20060102-150405") + "-a-cat-in-a-hat-1.png"},
+		{name: "filename template", args: []string{"--filename", "{{.Date}}_{{.Slug}}"}, wantFile: created.Format(time.DateOnly) + "_a-cat-in-a-hat.png"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := t.TempDir()
+			server := newFakeServer(t)
+			server.Queue(routeAzureImages, server.azureImages("/images/cat"))
+
+			args := append([]string{"image", "--out-dir", dir, "-o", "json"}, test.args...)
+			stdout, stderr := runCommand(t, server, "", append(args, "A cat, in a hat!")...)
+
+			var result Result
+			if err := json.Unmarshal([]byte(stdout), &result); err != nil {
+				t.Fatalf("stdout is not a JSON object: %s\n%s%s", err, stdout, stderr)
+			}
+			if want := filepath.Join(dir, test.wantFile); result.ImagePath != want {
+				t.Fatalf("image saved to %q, want %q", result.ImagePath, want)
+			}
+
+			data, err := os.ReadFile(result.ImagePath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(data) != string(fakePNG) {
+				t.Errorf("downloaded %d bytes, want the %d byte fake image", len(data), len(fakePNG))
+			}
+			if result.Model != "dalle-test" || !strings.HasSuffix(result.ImageURL, "/images/cat") {
+				t.Errorf("unexpected result %+v", result)
+			}
+
+			sidecar, err := os.ReadFile(strings.TrimSuffix(result.ImagePath, ".png") + ".json")
+			if err != nil {
+				t.Fatal(err)
+			}
+			var meta ImageMetadata
+			if err := json.Unmarshal(sidecar, &meta); err != nil {
+				t.Fatal(err)
+			}
+			if meta.Prompt != "A cat, in a hat!" || meta.Model != "dalle-test" || meta.Size != "1x1" || meta.File != test.wantFile || meta.Created.Unix() != 1700000000 {
+				t.Errorf("unexpected metadata %+v", meta)
+			}
+		})
+	}
+}
+
+func TestImageDownloadKeepsExistingFiles(t *testing.T) {
+	dir := t.TempDir()
+	for i := 1; i <= 2; i++ {
+		server := newFakeServer(t)
+		server.Queue(routeAzureImages, server.azureImages("/images/cat"))
+		runCommand(t, server, "", "image", "--out-dir", dir, "--filename", "cat", "a cat")
+	}
+
+	for _, name := range []string{"cat.png", "cat.json", "cat-2.png", "cat-2.json"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+func TestImageDownloadKeepsExistingSidecar(t *testing.T) {
+	dir := t.TempDir()
+	existing := filepath.Join(dir, "cat.json")
+	if err := os.WriteFile(existing, []byte("hand made\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	server := newFakeServer(t)
+	server.Queue(routeAzureImages, server.azureImages("/images/cat"))
+	stdout, stderr := runCommand(t, server, "", "image", "--out-dir", dir, "--filename", "cat", "-o", "json", "a cat")
+
+	var result Result
+	if err := json.Unmarshal([]byte(stdout), &result); err != nil {
+		t.Fatalf("stdout is not a JSON object: %s\n%s%s", err, stdout, stderr)
+	}
+	if want := filepath.Join(dir, "cat-2.png"); result.ImagePath != want {
+		t.Errorf("image saved to %q, want %q", result.ImagePath, want)
+	}
+	if data, err := os.ReadFile(existing); err != nil || string(data) != "hand made\n" {
+		t.Errorf("existing sidecar changed to %q (%v)", data, err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "cat.png")); !os.IsNotExist(err) {
+		t.Errorf("cat.png was left behind: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "cat-2.json")); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestImageWriteFailureRemovesFiles(t *testing.T) {
+	tests := []struct {
+		name string
+		// readOnly is the file, "image" or "sidecar", opened so writing it fails
+		readOnly string
+		wantErr  string
+	}{
+		{name: "image write fails", readOnly: "image", wantErr: "writing image:"},
+		{name: "sidecar write fails", readOnly: "sidecar", wantErr: "writing image metadata:"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := t.TempDir()
+			file, sidecar, path, err := createUnique(filepath.Join(dir, "cat"), ".png")
+			if err != nil {
+				t.Fatal(err)
+			}
+			reopen := func(f *os.File) *os.File {
+				f.Close()
+				readOnly, err := os.Open(f.Name())
+				if err != nil {
+					t.Fatal(err)
+				}
+				return readOnly
+			}
+			if test.readOnly == "image" {
+				file = reopen(file)
+			} else {
+				sidecar = reopen(sidecar)
+			}
+
+			err = writeImageFiles(file, sidecar, path, []byte("png data"), ImageMetadata{Prompt: "a cat"})
+			if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+				t.Fatalf("got error %v, want %q", err, test.wantErr)
+			}
+			entries, _ := os.ReadDir(dir)
+			for _, entry := range entries {
+				t.Errorf("%s was left behind", entry.Name())
+			}
+		})
+	}
+}
+
+func TestImageFilenameInvalid(t *testing.T) {
+	server := newFakeServer(t)
+	_, stderr, code := runCommandWithCode(t, server, "", "image", "--filename", "../{{.Slug}}", "a cat")
+
+	if code != int(KindUsage) || !strings.Contains(stderr, "--filename must give a file name without directories") {
+		t.Errorf("exit code %d, stderr %q", code, stderr)
+	}
+	if requests := server.Requests(routeAzureImages); len(requests) != 0 {
+		t.Errorf("got %d requests, want none", len(requests))
+	}
+}
+
+func TestSlugify(t *testing.T) {
+	tests := []struct {
+		text string
+		want string
+	}{
+		{"A cat wearing a hat", "a-cat-wearing-a-hat"},
+		{"  Sunset, over the sea!  ", "sunset-over-the-sea"},
+		{"Café à Paris", "caf-paris"},
+		{"???", "image"},
+		{strings.Repeat("lighthouse ", 10), "lighthouse-lighthouse-lighthouse-lighthouse"},
+	}
+
+	for _, test := range tests {
+		if got := slugify(test.text); got != test.want {
+			t.Errorf("slugify(%q) = %q, want %q", test.text, got, test.want)
+		}
+	}
+}
+
+func TestImageExtension(t *testing.T) {
+	tests := []struct {
+		contentType string
+		data        []byte
+		want        string
+	}{
+		{"image/png", nil, ".png"},
+		{"image/jpeg; charset=binary", nil, ".jpg"},
+		{"application/octet-stream", fakePNG, ".png"},
+		{"", []byte("\xff\xd8\xff\xe0\x00\x10JFIF\x00"), ".jpg"},
+		{"", []byte("RIFF\x00\x00\x00\x00WEBPVP8 "), ".webp"},
+		{"", []byte("not an image"), ".bin"},
+	}
+
+	for _, test := range tests {
+		if got := imageExtension(test.contentType, test.data); got != test.want {
+			t.Errorf("imageExtension(%q, %q) = %q, want %q", test.contentType, test.data, got, test.want)
+		}
+	}
+}
+
+func TestImageBase64(t *testing.T) {
+	tests := []struct {
+		name        string
+		responses   func(server *fakeServer) []fakeResponse
+		wantFormats []string
+		wantStdout  string
+		wantStderr  string
+		wantRevised string
+	}{
+		{
+			name:        "decoded to disk",
+			responses:   func(*fakeServer) []fakeResponse { return []fakeResponse{azureImagesB64(1)} },
+			wantFormats: []string{"b64_json"},
+			wantStdout:  "Image file: ",
+			wantRevised: "a tabby cat wearing a top hat",
+		},
+		{
+			name: "falls back to urls",
+			responses: func(server *fakeServer) []fakeResponse {
+				return []fakeResponse{
+					{Status: http.StatusBadRequest, Body: azureError("invalid_request_error", "The response_format 'b64_json' is not supported by this model.")},
+					server.azureImages("/images/cat"),
+				}
+			},
+			wantFormats: []string{"b64_json", "url"},
+			wantStdout:  "Image URL: ",
+			wantStderr:  "does not support base64 images, asking for URLs instead",
+			wantRevised: "a tabby cat wearing a top hat",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := t.TempDir()
+			server := newFakeServer(t)
+			for _, response := range test.responses(server) {
+				server.Queue(routeAzureImages, response)
+			}
+
+			stdout, stderr := runCommand(t, server, "", "image", "--format", "b64", "--out-dir", dir, "--filename", "cat", "a cat")
+
+			requests := server.Requests(routeAzureImages)
+			if len(requests) != len(test.wantFormats) {
+				t.Fatalf("got %d requests, want %d", len(requests), len(test.wantFormats))
+			}
+			for i, want := range test.wantFormats {
+				if got := requests[i]["response_format"]; got != want {
+					t.Errorf("request %d asked for %v, want %s", i+1, got, want)
+				}
+			}
+			if !strings.Contains(stdout, test.wantStdout) {
+				t.Errorf("stdout %q does not contain %q", stdout, test.wantStdout)
+			}
+			if !strings.Contains(stderr, test.wantStderr) {
+				t.Errorf("stderr %q does not contain %q", stderr, test.wantStderr)
+			}
+
+			data, err := os.ReadFile(filepath.Join(dir, "cat.png"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(data) != string(fakePNG) {
+				t.Errorf("saved %d bytes, want the %d byte fake image", len(data), len(fakePNG))
+			}
+			sidecar, err := os.ReadFile(filepath.Join(dir, "cat.json"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var meta ImageMetadata
+			if err := json.Unmarshal(sidecar, &meta); err != nil {
+				t.Fatal(err)
+			}
+			if meta.RevisedPrompt != test.wantRevised {
+				t.Errorf("revised prompt %q, want %q", meta.RevisedPrompt, test.wantRevised)
+			}
+		})
+	}
+}
+
+func TestImageFormatInvalid(t *testing.T) {
+	server := newFakeServer(t)
+	_, stderr, code := runCommandWithCode(t, server, "", "image", "--format", "png", "a cat")
+
+	if code != int(KindUsage) || !strings.Contains(stderr, `unknown image format "png"`) {
+		t.Errorf("exit code %d, stderr %q", code, stderr)
+	}
+}
+
+func TestImageOptions(t *testing.T) {
+	tests := []struct {
+		name       string
+		args       []string
+		env        map[string]string
+		want       map[string]any
+		wantStderr string
+	}{
+		{
+			name: "dall-e-3 options",
+			args: []string{"--size", "1792x1024", "--quality", "HD", "--style", "natural"},
+			want: map[string]any{"size": "1792x1024", "quality": "hd", "style": "natural"},
+		},
+		{
+			name: "settings",
+			env:  map[string]string{"IMAGE_SIZE": "1024x1792", "IMAGE_STYLE": "vivid"},
+			want: map[string]any{"size": "1024x1792", "style": "vivid"},
+		},
+		{
+			name: "dall-e-2 count",
+			args: []string{"--size", "256x256", "--count", "3"},
+			env:  map[string]string{"DALLE_MODEL": "dall-e-2"},
+			want: map[string]any{"size": "256x256", "n": float64(3)},
+		},
+		{
+			name:       "size not supported",
+			args:       []string{"--size", "512x512"},
+			wantStderr: "--size 512x512 is not supported by dall-e-3 (available: 1024x1024, 1792x1024, 1024x1792)",
+		},
+		{
+			name:       "too many images",
+			args:       []string{"--count", "2"},
+			wantStderr: "--count must be between 1 and 1 for dall-e-3, got 2",
+		},
+		{
+			name:       "quality not supported",
+			args:       []string{"--quality", "hd"},
+			env:        map[string]string{"DALLE_MODEL": "dall-e-2"},
+			wantStderr: "dall-e-2 does not support --quality",
+		},
+		{
+			name:       "unknown model",
+			env:        map[string]string{"DALLE_MODEL": "midjourney"},
+			wantStderr: `unknown DALL·E model "midjourney"`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := newFakeServer(t)
+			for key, value := range test.env {
+				server.Env[key] = value
+			}
+			if test.want != nil {
+				server.Queue(routeAzureImages, server.azureImages("/images/cat.png"))
+			}
+
+			_, stderr, code := runCommandWithCode(t, server, "", append(append([]string{"image"}, test.args...), "a cat")...)
+
+			requests := server.Requests(routeAzureImages)
+			if test.want == nil {
+				if code != int(KindUsage) || !strings.Contains(stderr, test.wantStderr) {
+					t.Errorf("exit code %d, stderr %q does not contain %q", code, stderr, test.wantStderr)
+				}
+				if len(requests) != 0 {
+					t.Errorf("got %d requests, want none", len(requests))
+				}
+				return
+			}
+
+			if code != 0 || len(requests) != 1 {
+				t.Fatalf("exit code %d with %d requests: %s", code, len(requests), stderr)
+			}
+			for key, want := range test.want {
+				if got := requests[0][key]; got != want {
+					t.Errorf("sent %s %v, want %v", key, got, want)
+				}
+			}
+			for _, key := range []string{"size", "quality", "style", "n"} {
+				if _, ok := test.want[key]; !ok && requests[0][key] != nil {
+					t.Errorf("sent %s %v, want it left out", key, requests[0][key])
+				}
+			}
+		})
+	}
+}
+
+func TestFetchImageCancelled(t *testing.T) {
+	server := newFakeServer(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, _, err := fetchImage(ctx, server.URL+"/images/cat.png"); !errors.Is(err, context.Canceled) {
+		t.Errorf("got error %v, want context.Canceled", err)
+	}
+}
@@ -0,0 +1,185 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/ai/azopenai"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/runtime"
+)
+
+// ErrorKind classifies the failures a command can end with. Each kind has
+// its own process exit code so scripts can tell them apart.
+type ErrorKind int
+
+// Error kinds, their value is the exit code returned by Execute
+const (
+	KindUnknown         ErrorKind = 1
+	KindUsage           ErrorKind = 2
+	KindConfigMissing   ErrorKind = 3
+	KindAuthFailed      ErrorKind = 4
+	KindThrottled       ErrorKind = 5
+	KindContentFiltered ErrorKind = 6
+	KindModelNotFound   ErrorKind = 7
+	KindNetwork         ErrorKind = 8
+	// KindInterrupted follows the shell convention for a command stopped by Ctrl-C
+	KindInterrupted ErrorKind = 130
+)
+
+// hints tells the user what to do about each kind of error
+var hints = map[ErrorKind]string{
+	KindUsage:           "run the command with --help to see how it is used",
+	KindConfigMissing:   "set the missing values in .env, ~/.config/go-cli-gpt/config.yaml or the environment, and run `go-cli-gpt config` to check them",
+	KindAuthFailed:      "check that AZURE_OPENAI_API_KEY is a valid key for the resource at AZURE_OPENAI_ENDPOINT",
+	KindThrottled:       "the service is rate limiting you, wait a moment and try again or raise --max-attempts",
+	KindContentFiltered: "the prompt or answer was blocked by the content filter, rephrase the request and try again",
+	KindModelNotFound:   "check the deployment name (YOUR_MODEL_DEPLOYMENT_NAME, DALLE_MODEL_NAME or --model), or pull the model with `ollama pull <model>`",
+	KindNetwork:         "check your network connection and that AZURE_OPENAI_ENDPOINT or OLLAMA_HOST point at a reachable server",
+}
+
+// Error is an error of a known kind, with a remediation hint for the user
+type Error struct {
+	Kind ErrorKind
+	Err  error
+	// Hint overrides the default hint for the kind when set
+	Hint string
+}
+
+// NewError wraps err as an error of the given kind
+func NewError(kind ErrorKind, err error) *Error {
+	return &Error{Kind: kind, Err: err}
+}
+
+// Errorf formats an error of the given kind
+func Errorf(kind ErrorKind, format string, args ...any) *Error {
+	return &Error{Kind: kind, Err: fmt.Errorf(format, args...)}
+}
+
+func (e *Error) Error() string {
+	return e.Err.Error()
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Remediation returns what the user can do about the error
+func (e *Error) Remediation() string {
+	if e.Hint != "" {
+		return e.Hint
+	}
+	return hints[e.Kind]
+}
+
+// ClassifyError turns any error into an *Error, working out the kind from
+// Azure and Ollama responses and network failures
+func ClassifyError(err error) *Error {
+	var cliErr *Error
+	if errors.As(err, &cliErr) {
+		return cliErr
+	}
+
+	var respErr *azcore.ResponseError
+	if errors.As(err, &respErr) {
+		return azureResponseError(respErr)
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) && !errors.Is(err, context.Canceled) {
+		return NewError(KindNetwork, err)
+	}
+
+	return NewError(KindUnknown, err)
+}
+
+// azureResponseError replaces the SDK's multi line error dump with the
+// message sent by the service, classified by status and error code
+func azureResponseError(respErr *azcore.ResponseError) *Error {
+	message := http.StatusText(respErr.StatusCode)
+	// A prompt rejected by the content filter comes with the verdicts, under
+	// innererror for chat and inner_error for images
+	var promptFilter []ContentFilterResult
+	if respErr.RawResponse != nil {
+		type innerError struct {
+			ContentFilterResult  *azopenai.ContentFilterResultDetailsForPrompt `json:"content_filter_result"`
+			ContentFilterResults *azopenai.ContentFilterResultDetailsForPrompt `json:"content_filter_results"`
+		}
+		var body struct {
+			Error struct {
+				Message         string     `json:"message"`
+				InnerError      innerError `json:"innererror"`
+				ImageInnerError innerError `json:"inner_error"`
+			} `json:"error"`
+		}
+		if payload, err := runtime.Payload(respErr.RawResponse); err == nil && json.Unmarshal(payload, &body) == nil {
+			if body.Error.Message != "" {
+				message = body.Error.Message
+			}
+			for _, details := range []*azopenai.ContentFilterResultDetailsForPrompt{
+				body.Error.InnerError.ContentFilterResult, body.Error.InnerError.ContentFilterResults,
+				body.Error.ImageInnerError.ContentFilterResult, body.Error.ImageInnerError.ContentFilterResults,
+			} {
+				if details != nil {
+					results, _ := azurePromptFilterDetails(details)
+					promptFilter = append(promptFilter, results...)
+				}
+			}
+		}
+	}
+	err := fmt.Errorf("Azure OpenAI returned %d: %s", respErr.StatusCode, message)
+
+	code := strings.ToLower(respErr.ErrorCode)
+	switch {
+	case code == "content_filter" || code == "contentfilter":
+		if anyFiltered(promptFilter) {
+			return Errorf(KindContentFiltered, "the prompt was blocked by the content filter: %s", filteredSummary(promptFilter))
+		}
+		return NewError(KindContentFiltered, err)
+	case respErr.StatusCode == http.StatusUnauthorized || respErr.StatusCode == http.StatusForbidden:
+		return NewError(KindAuthFailed, err)
+	case respErr.StatusCode == http.StatusTooManyRequests:
+		return NewError(KindThrottled, err)
+	case respErr.StatusCode == http.StatusNotFound || code == "deploymentnotfound":
+		return NewError(KindModelNotFound, err)
+	}
+	return NewError(KindUnknown, err)
+}
+
+// ollamaError classifies an error from the Ollama client, which only exposes
+// the server's message
+func ollamaError(err error) error {
+	var netErr net.Error
+	switch {
+	case errors.Is(err, context.Canceled):
+		return err
+	case errors.As(err, &netErr):
+		return &Error{Kind: KindNetwork, Err: err, Hint: fmt.Sprintf("check that Ollama is running at %s, or set OLLAMA_HOST", OllamaBaseURL())}
+	case strings.Contains(err.Error(), "not found"):
+		return NewError(KindModelNotFound, err)
+	}
+	return err
+}
+
+// ExitCode returns the process exit code for an error, 0 when err is nil
+func ExitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+	return int(ClassifyError(err).Kind)
+}
+
+// PrintError writes an error and its remediation hint for the user
+func PrintError(w io.Writer, err error) {
+	cliErr := ClassifyError(err)
+	fmt.Fprintf(w, "Error: %s\n", cliErr)
+	if hint := cliErr.Remediation(); hint != "" {
+		fmt.Fprintf(w, "Hint: %s\n", hint)
+	}
+}
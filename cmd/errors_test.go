@@ -0,0 +1,134 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestExitCodes(t *testing.T) {
+	tests := []struct {
+		name       string
+		args       []string
+		env        map[string]string
+		responses  map[string][]fakeResponse
+		closed     bool
+		wantCode   ErrorKind
+		wantStderr []string
+	}{
+		{
+			name:       "config missing",
+			args:       []string{"question", "What is Go?"},
+			env:        map[string]string{"AZURE_OPENAI_API_KEY": "", "AZURE_OPENAI_ENDPOINT": ""},
+			wantCode:   KindConfigMissing,
+			wantStderr: []string{"Error: unable to continue, missing AZURE_OPENAI_ENDPOINT, AZURE_OPENAI_API_KEY", "Hint: set the missing values"},
+		},
+		{
+			name:       "image deployment missing",
+			args:       []string{"image", "a cat"},
+			env:        map[string]string{"DALLE_MODEL_NAME": ""},
+			wantCode:   KindConfigMissing,
+			wantStderr: []string{"missing DALLE_MODEL_NAME"},
+		},
+		{
+			name: "deployment not found",
+			args: []string{"question", "--model", "gpt-missing", "What is Go?"},
+			responses: map[string][]fakeResponse{routeAzureChat: {{
+				Status: http.StatusNotFound,
+				Body:   azureError("DeploymentNotFound", "The API deployment for this resource does not exist."),
+			}}},
+			wantCode:   KindModelNotFound,
+			wantStderr: []string{"Azure OpenAI returned 404: The API deployment for this resource does not exist."},
+		},
+		{
+			name:       "ollama model not installed",
+			args:       []string{"question", "--local", "--model", "mistral", "What is Go?"},
+			wantCode:   KindModelNotFound,
+			wantStderr: []string{"Error: model mistral is not installed", "Hint: run `ollama pull mistral`"},
+		},
+		{
+			name: "ollama model missing on the server",
+			args: []string{"translate", "--local", "--to", "French", "Hello"},
+			responses: map[string][]fakeResponse{routeOllamaChat: {{
+				Status: http.StatusNotFound,
+				Body:   map[string]any{"error": `model "llama3" not found, try pulling it first`},
+			}}},
+			wantCode: KindModelNotFound,
+		},
+		{
+			name:       "server unreachable",
+			args:       []string{"question", "--max-attempts", "1", "What is Go?"},
+			closed:     true,
+			wantCode:   KindNetwork,
+			wantStderr: []string{"Hint: check your network connection"},
+		},
+		{
+			name:       "ollama unreachable",
+			args:       []string{"question", "--local", "--max-attempts", "1", "What is Go?"},
+			closed:     true,
+			wantCode:   KindNetwork,
+			wantStderr: []string{"could not reach Ollama", "Hint: check that Ollama is running"},
+		},
+		{
+			name:     "unknown flag",
+			args:     []string{"question", "--no-such-flag"},
+			wantCode: KindUsage,
+		},
+		{
+			name:       "unknown output format",
+			args:       []string{"question", "-o", "yaml", "What is Go?"},
+			wantCode:   KindUsage,
+			wantStderr: []string{`unknown output format "yaml"`},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := newFakeServer(t)
+			for key, value := range test.env {
+				server.Env[key] = value
+			}
+			for route, responses := range test.responses {
+				server.Queue(route, responses...)
+			}
+			if test.closed {
+				server.Close()
+			}
+
+			_, stderr, code := runCommandWithCode(t, server, "", test.args...)
+
+			if code != int(test.wantCode) {
+				t.Errorf("exit code %d, want %d\n%s", code, test.wantCode, stderr)
+			}
+			for _, want := range test.wantStderr {
+				if !strings.Contains(stderr, want) {
+					t.Errorf("stderr %q does not contain %q", stderr, want)
+				}
+			}
+		})
+	}
+}
+
+func TestClassifyError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want ErrorKind
+	}{
+		{name: "nil", err: nil, want: 0},
+		{name: "plain", err: errors.New("boom"), want: KindUnknown},
+		{name: "wrapped typed error", err: fmt.Errorf("running: %w", Errorf(KindThrottled, "slow down")), want: KindThrottled},
+		{name: "cancelled", err: context.Canceled, want: KindUnknown},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := ExitCode(test.err); got != int(test.want) {
+				t.Errorf("ExitCode() = %d, want %d", got, test.want)
+			}
+		})
+	}
+}
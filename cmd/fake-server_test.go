@@ -0,0 +1,378 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+// Routes served by fakeServer, used to queue responses and inspect requests
+const (
+	routeAzureChat   = "azure-chat"
+	routeAzureImages = "azure-images"
+	routeOllamaChat  = "ollama-chat"
+	routeOllamaPull  = "ollama-pull"
+)
+
+// fakePNG is a 1x1 PNG served for every generated image URL
+var fakePNG = []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR\x00\x00\x00\x01\x00\x00\x00\x01\b\x02\x00\x00\x00\x90wS\xde\x00\x00\x00\rIDATx\xdab\xfa\xdf\xc0\x00\x18\x00\x04\t\x01\x82\xdf\xde9\x8b\x00\x00\x00\x00IEND\xaeB`\x82")
+
+// fakeResponse is a canned reply. Events are sent as server sent events
+// instead of Body when the client asked for a stream.
+type fakeResponse struct {
+	Status int
+	Header http.Header
+	Body   any
+	Events []any
+	// Interrupt sends the CLI a Ctrl-C after the streamed events, or instead
+	// of the reply when nothing is streamed, and keeps the request open until
+	// it is abandoned
+	Interrupt bool
+}
+
+// fakeServer stands in for both Azure OpenAI and Ollama. Responses are
+// queued per route and every request body is recorded.
+type fakeServer struct {
+	*httptest.Server
+	t *testing.T
+
+	// Env overrides the environment runCommand sets up for the CLI
+	Env map[string]string
+
+	mu        sync.Mutex
+	responses map[string][]fakeResponse
+	requests  map[string][]map[string]any
+}
+
+// newFakeServer starts a TLS server, as the Azure SDK refuses to send keys
+// over plain http, and points the CLI's HTTP client at it
+func newFakeServer(t *testing.T) *fakeServer {
+	t.Helper()
+	// The history lives as long as the server, so runs within a test share it
+	env := map[string]string{"HISTORY_FILE": filepath.Join(t.TempDir(), "history.jsonl")}
+	server := &fakeServer{t: t, Env: env, responses: map[string][]fakeResponse{}, requests: map[string][]map[string]any{}}
+	server.Server = httptest.NewTLSServer(http.HandlerFunc(server.handle))
+	t.Cleanup(server.Close)
+
+	previous := httpClient
+	httpClient = server.Client()
+	t.Cleanup(func() { httpClient = previous })
+
+	return server
+}
+
+// Queue adds responses to be returned, in order, by route
+func (s *fakeServer) Queue(route string, responses ...fakeResponse) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.responses[route] = append(s.responses[route], responses...)
+}
+
+// Requests returns the decoded bodies of the requests received on route
+func (s *fakeServer) Requests(route string) []map[string]any {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.requests[route]
+}
+
+func (s *fakeServer) handle(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case strings.HasPrefix(r.URL.Path, "/images/"):
+		w.Header().Set("Content-Type", "image/png")
+		w.Write(fakePNG)
+	case r.URL.Path == "/api/tags":
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"models":[{"name":"llama3:latest","size":4661224676,"modified_at":"2024-05-01T10:00:00Z"}]}`)
+	case strings.HasSuffix(r.URL.Path, "/chat/completions"):
+		s.reply(w, r, routeAzureChat)
+	case strings.HasSuffix(r.URL.Path, "/images/generations"):
+		s.reply(w, r, routeAzureImages)
+	case r.URL.Path == "/api/chat":
+		s.reply(w, r, routeOllamaChat)
+	case r.URL.Path == "/api/pull":
+		s.reply(w, r, routeOllamaPull)
+	default:
+		s.t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+		http.NotFound(w, r)
+	}
+}
+
+func (s *fakeServer) reply(w http.ResponseWriter, r *http.Request, route string) {
+	var body map[string]any
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		s.t.Errorf("decoding %s request: %s", route, err)
+	}
+
+	s.mu.Lock()
+	s.requests[route] = append(s.requests[route], body)
+	queue := s.responses[route]
+	var response fakeResponse
+	if len(queue) > 0 {
+		response, s.responses[route] = queue[0], queue[1:]
+	}
+	s.mu.Unlock()
+
+	if response.Interrupt && response.Events == nil {
+		interruptCLI(r)
+		return
+	}
+	if response.Status == 0 && response.Body == nil && response.Events == nil {
+		s.t.Errorf("no response queued for %s", route)
+		// 418 is not retried by the Azure SDK, so the test fails fast
+		response = fakeResponse{Status: http.StatusTeapot, Body: azureError("teapot", "no response queued")}
+	}
+	if response.Status == 0 {
+		response.Status = http.StatusOK
+	}
+	for key, values := range response.Header {
+		w.Header()[key] = values
+	}
+
+	if stream, _ := body["stream"].(bool); stream && response.Events != nil {
+		if route == routeOllamaChat || route == routeOllamaPull {
+			w.Header().Set("Content-Type", "application/x-ndjson")
+			w.WriteHeader(response.Status)
+			for _, event := range response.Events {
+				json.NewEncoder(w).Encode(event)
+			}
+			return
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.WriteHeader(response.Status)
+		for _, event := range response.Events {
+			data, _ := json.Marshal(event)
+			fmt.Fprintf(w, "data: %s\n\n", data)
+		}
+		if response.Interrupt {
+			w.(http.Flusher).Flush()
+			interruptCLI(r)
+			return
+		}
+		fmt.Fprint(w, "data: [DONE]\n\n")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(response.Status)
+	json.NewEncoder(w).Encode(response.Body)
+}
+
+// azureChat is an Azure chat completions reply with one answer per content
+func azureChat(contents ...string) fakeResponse {
+	choices := []any{}
+	for i, content := range contents {
+		choices = append(choices, map[string]any{
+			"index":         i,
+			"finish_reason": "stop",
+			"message":       map[string]any{"role": "assistant", "content": content},
+		})
+	}
+	return fakeResponse{Body: map[string]any{
+		"model":   "gpt-test",
+		"choices": choices,
+		"usage":   map[string]any{"prompt_tokens": 12, "completion_tokens": 5, "total_tokens": 17},
+	}}
+}
+
+// azureChatStream is an Azure chat completions reply streamed in tokens
+func azureChatStream(tokens ...string) fakeResponse {
+	events := []any{}
+	for i, token := range tokens {
+		choice := map[string]any{"index": 0, "delta": map[string]any{"content": token}}
+		if i == len(tokens)-1 {
+			choice["finish_reason"] = "stop"
+		}
+		events = append(events, map[string]any{"choices": []any{choice}})
+	}
+	return fakeResponse{Events: events}
+}
+
+// interruptCLI presses Ctrl-C and waits for the CLI to give up on the request
+func interruptCLI(r *http.Request) {
+	process, _ := os.FindProcess(os.Getpid())
+	process.Signal(os.Interrupt)
+	<-r.Context().Done()
+}
+
+// interrupted makes a reply end with the user pressing Ctrl-C, after the
+// streamed events if there are any
+func interrupted(response fakeResponse) fakeResponse {
+	response.Interrupt = true
+	return response
+}
+
+// azureToolCalls is an Azure reply asking for tools to be called, with
+// arguments given as name, JSON arguments pairs
+func azureToolCalls(calls ...string) fakeResponse {
+	toolCalls := []any{}
+	for i := 0; i+1 < len(calls); i += 2 {
+		toolCalls = append(toolCalls, map[string]any{
+			"id":       fmt.Sprintf("call_%d", i/2+1),
+			"type":     "function",
+			"function": map[string]any{"name": calls[i], "arguments": calls[i+1]},
+		})
+	}
+	return fakeResponse{Body: map[string]any{
+		"choices": []any{map[string]any{
+			"index":         0,
+			"finish_reason": "tool_calls",
+			"message":       map[string]any{"role": "assistant", "content": nil, "tool_calls": toolCalls},
+		}},
+	}}
+}
+
+// azureFiltered is an Azure reply whose answer was cut by the content filter
+func azureFiltered() fakeResponse {
+	return fakeResponse{Body: map[string]any{
+		"choices": []any{map[string]any{
+			"index":         0,
+			"finish_reason": "content_filter",
+			"message":       map[string]any{"role": "assistant", "content": ""},
+			"content_filter_results": map[string]any{
+				"hate":      map[string]any{"filtered": true, "severity": "high"},
+				"self_harm": map[string]any{"filtered": false, "severity": "safe"},
+				"sexual":    map[string]any{"filtered": false, "severity": "safe"},
+				"violence":  map[string]any{"filtered": false, "severity": "low"},
+			},
+		}},
+	}}
+}
+
+// azureImages is an Azure image generations reply with one URL per path,
+// served by the fake server itself
+func (s *fakeServer) azureImages(paths ...string) fakeResponse {
+	data := []any{}
+	for _, path := range paths {
+		data = append(data, map[string]any{"url": s.URL + path, "revised_prompt": "a tabby cat wearing a top hat"})
+	}
+	return fakeResponse{Body: map[string]any{"created": 1700000000, "data": data}}
+}
+
+// azureImagesB64 is an Azure image generations reply carrying count copies
+// of the fake image as base64 data
+func azureImagesB64(count int) fakeResponse {
+	data := []any{}
+	for i := 0; i < count; i++ {
+		data = append(data, map[string]any{"b64_json": base64.StdEncoding.EncodeToString(fakePNG), "revised_prompt": "a tabby cat wearing a top hat"})
+	}
+	return fakeResponse{Body: map[string]any{"created": 1700000000, "data": data}}
+}
+
+// azureError is the body Azure OpenAI sends with a failed request
+func azureError(code string, message string) map[string]any {
+	return map[string]any{"error": map[string]any{"code": code, "message": message}}
+}
+
+// ollamaChat is an Ollama chat reply with a single answer
+func ollamaChat(content string) fakeResponse {
+	return fakeResponse{Body: map[string]any{
+		"model":             "llama3",
+		"message":           map[string]any{"role": "assistant", "content": content},
+		"done":              true,
+		"prompt_eval_count": 9,
+		"eval_count":        4,
+	}}
+}
+
+// lastMessage returns the content of the last message sent in a chat request
+func lastMessage(t *testing.T, request map[string]any) string {
+	t.Helper()
+	messages, _ := request["messages"].([]any)
+	if len(messages) == 0 {
+		t.Fatalf("request has no messages: %v", request)
+	}
+	message, _ := messages[len(messages)-1].(map[string]any)
+	content, _ := message["content"].(string)
+	return content
+}
+
+// runCommand runs the CLI against server with args and stdin, returning what
+// was written to stdout and stderr. Errors are printed to stderr as Execute does.
+func runCommand(t *testing.T, server *fakeServer, stdin string, args ...string) (string, string) {
+	t.Helper()
+	stdout, stderr, _ := runCommandWithCode(t, server, stdin, args...)
+	return stdout, stderr
+}
+
+// runCommandWithCode is runCommand that also returns the exit code Execute would use
+func runCommandWithCode(t *testing.T, server *fakeServer, stdin string, args ...string) (string, string, int) {
+	t.Helper()
+
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	t.Setenv("GO_CLI_GPT_PROFILE", "")
+	t.Setenv("LLM_PROVIDER", "azure")
+	t.Setenv("MAX_ATTEMPTS", "")
+	t.Setenv("XDG_DATA_HOME", t.TempDir())
+	t.Setenv("TEMPLATES_DIR", "")
+	t.Setenv("PERSONA", "")
+	t.Setenv("COLUMNS", "")
+	for _, setting := range []string{"TEMPERATURE", "TOP_P", "PRESENCE_PENALTY", "FREQUENCY_PENALTY", "STOP", "SEED", "CHOICES"} {
+		t.Setenv(setting, "")
+	}
+	for _, setting := range []string{"IMAGE_DIR", "IMAGE_FILENAME", "IMAGE_FORMAT", "IMAGE_SIZE", "IMAGE_QUALITY", "IMAGE_STYLE", "IMAGE_COUNT", "DALLE_MODEL", "IMAGE_PREVIEW", "IMAGE_RATE_LIMIT"} {
+		t.Setenv(setting, "")
+	}
+	t.Setenv("WEATHER_SOURCE", "fixture")
+	t.Setenv("WEATHER_FIXTURE_FILE", "")
+	t.Setenv("AZURE_OPENAI_ENDPOINT", server.URL)
+	t.Setenv("AZURE_OPENAI_API_KEY", "test-key")
+	t.Setenv("YOUR_MODEL_DEPLOYMENT_NAME", "gpt-test")
+	t.Setenv("DALLE_MODEL_NAME", "dalle-test")
+	t.Setenv("OLLAMA_HOST", server.URL)
+	t.Setenv("OLLAMA_MODEL", "llama3")
+	for key, value := range server.Env {
+		t.Setenv(key, value)
+	}
+
+	resetFlags(rootCmd)
+
+	var stdout, stderr bytes.Buffer
+	rootCmd.SetArgs(args)
+	rootCmd.SetIn(strings.NewReader(stdin))
+	rootCmd.SetOut(&stdout)
+	rootCmd.SetErr(&stderr)
+	log.SetOutput(&stderr)
+	t.Cleanup(func() {
+		rootCmd.SetIn(nil)
+		rootCmd.SetOut(nil)
+		rootCmd.SetErr(nil)
+		log.SetOutput(os.Stderr)
+	})
+
+	err := rootCmd.Execute()
+	if err != nil {
+		PrintError(&stderr, err)
+	}
+	return stdout.String(), stderr.String(), ExitCode(err)
+}
+
+// resetFlags puts every flag of cmd and its subcommands back to its default,
+// as the commands are package level and keep their state between runs
+func resetFlags(cmd *cobra.Command) {
+	reset := func(flag *pflag.Flag) {
+		// Setting a slice flag appends, "[]" would become an element
+		if slice, ok := flag.Value.(pflag.SliceValue); ok {
+			slice.Replace(nil)
+		} else {
+			flag.Value.Set(flag.DefValue)
+		}
+		flag.Changed = false
+	}
+	cmd.Flags().VisitAll(reset)
+	cmd.PersistentFlags().VisitAll(reset)
+	for _, child := range cmd.Commands() {
+		resetFlags(child)
+	}
+}
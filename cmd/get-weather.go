@@ -2,113 +2,120 @@ package cmd
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
 	"os"
+	"time"
 
-	"github.com/Azure/azure-sdk-for-go/sdk/ai/azopenai"
-	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
 	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
-	"github.com/joho/godotenv"
 	"github.com/spf13/cobra"
 )
 
-// exampleCmd represents the example command
+// weatherCmd represents the get-weather command
 var weatherCmd = &cobra.Command{
-	Use:   "get-weather",
+	Use:   "get-weather [location]",
 	Short: "Get current weather information",
-	Long:  `Get current weather information for a location using function calling`,
-	Run: func(cmd *cobra.Command, args []string) {
-		// Load the .env file
-		if err := godotenv.Load(); err != nil {
-			fmt.Fprintf(os.Stderr, "Error loading .env file\n")
-			return
+	Long: `Get current weather information for a location using function calling.
+
+The model asks for the get_current_weather tool, the CLI runs it against the
+selected weather source and sends the result back so the model can answer.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		unit, _ := cmd.Flags().GetString("unit")
+		if unit != "" && unit != "celsius" && unit != "fahrenheit" {
+			return Errorf(KindUsage, "unit must be celsius or fahrenheit, got %q", unit)
 		}
 
-		azureOpenAIKey := os.Getenv("AZURE_OPENAI_API_KEY")
-		modelDeploymentID := os.Getenv("YOUR_MODEL_DEPLOYMENT_NAME")
-
-		// Ex: "https://<your-azure-openai-host>.openai.azure.com"
-		azureOpenAIEndpoint := os.Getenv("AZURE_OPENAI_ENDPOINT")
-
-		if azureOpenAIKey == "" || modelDeploymentID == "" || azureOpenAIEndpoint == "" {
-			fmt.Fprintf(os.Stderr, "Skipping example, environment variables missing\n")
-			return
+		provider, err := GetProvider(cmd)
+		if err != nil {
+			return err
 		}
 
-		keyCredential := azcore.NewKeyCredential(azureOpenAIKey)
-
-		client, err := azopenai.NewClientWithKeyCredential(azureOpenAIEndpoint, keyCredential, nil)
-
+		// Take the location from the arguments or stdin, or ask for it
+		location, err := GetInput(cmd, args, "Which location do you want the weather for? ")
 		if err != nil {
-			log.Printf("ERROR: %s", err)
-			return
+			return err
+		}
+		if location == "" {
+			return &Error{Kind: KindUsage, Err: errors.New("no location given"), Hint: "pass the location as arguments or pipe it on stdin"}
 		}
 
-		resp, err := client.GetChatCompletions(context.TODO(), azopenai.ChatCompletionsOptions{
-			DeploymentName: &modelDeploymentID,
-			Messages: []azopenai.ChatRequestMessageClassification{
-				&azopenai.ChatRequestUserMessage{
-					Content: azopenai.NewChatRequestUserMessageContent("What's the weather like in London in the UK? Give this to me in celsius"),
-				},
-			},
-			Tools: []azopenai.ChatCompletionsToolDefinitionClassification{
-				&azopenai.ChatCompletionsFunctionToolDefinition{
-					Function: &azopenai.FunctionDefinition{
-						Name:        to.Ptr("get_current_weather"),
-						Description: to.Ptr("Get the current weather in a given location"),
-						Parameters: map[string]any{
-							"required": []string{"location"},
-							"type":     "object",
-							"properties": map[string]any{
-								"location": map[string]any{
-									"type":        "string",
-									"description": "The city and country, e.g. London, UK",
-								},
-								"unit": map[string]any{
-									"type": "string",
-									"enum": []string{"celsius", "fahrenheit"},
-								},
-							},
-						},
-					},
-				},
-			},
-			Temperature: to.Ptr[float32](0.0),
-		}, nil)
+		question := "What's the weather like in " + location + "?"
+		if unit != "" {
+			question += " Give this to me in " + unit
+		}
 
+		weatherSourceName, _ := cmd.Flags().GetString("weather-source")
+		if weatherSourceName == "" {
+			weatherSourceName = os.Getenv("WEATHER_SOURCE")
+		}
+		if weatherSourceName == "" {
+			weatherSourceName = "open-meteo"
+		}
+		weatherSource, err := NewWeatherSource(weatherSourceName)
 		if err != nil {
-			log.Printf("ERROR: %s", err)
-			return
+			return err
 		}
 
-		funcCall := resp.Choices[0].Message.ToolCalls[0].(*azopenai.ChatCompletionsFunctionToolCall).Function
+		registry := NewToolRegistry()
+		registry.Output = cmd.ErrOrStderr()
+		RegisterWeatherTool(registry, weatherSource)
 
-		// This is the function name we gave in the call to GetCompletions
-		// Prints: Function name: "get_current_weather"
-		fmt.Fprintf(os.Stderr, "Function name: %q\n", *funcCall.Name)
+		messages, err := ApplySystemPrompt(cmd, []Message{{Role: RoleUser, Content: question}})
+		if err != nil {
+			return err
+		}
 
-		// The arguments for the function come back as a JSON string
-		// The arguments are pulled from the natural language query
-		var funcParams *struct {
-			Location string `json:"location"`
-			Unit     string `json:"unit"`
+		opts, err := GetSamplingOptions(cmd, CompletionOptions{Temperature: to.Ptr[float32](0.0)})
+		if err != nil {
+			return err
 		}
-		err = json.Unmarshal([]byte(*funcCall.Arguments), &funcParams)
 
+		start := time.Now()
+		completion, history, err := CompleteWithTools(context.TODO(), provider, registry, messages, opts, DefaultMaxToolIterations)
 		if err != nil {
-			log.Printf("ERROR: %s", err)
-			return
+			return err
 		}
 
-		// Prints:
-		// Parameters: azopenai_test.location{Location:"London, UK", Unit:"celsius"}
-		fmt.Fprintf(os.Stderr, "Parameters: %#v\n", *funcParams)
+		if len(completion.Choices) == 0 {
+			return &Error{Kind: KindUnknown, Err: errors.New("no reply received"), Hint: "the model sent back an empty answer, try again"}
+		}
 
+		if GetOutputFormat(cmd) == OutputText {
+			if showFilters, _ := cmd.Flags().GetBool("show-filters"); showFilters {
+				WriteSafetyReport(cmd.ErrOrStderr(), completion)
+			}
+			if err := BlockedError(completion); err != nil {
+				return err
+			}
+			fmt.Fprintln(cmd.OutOrStdout(), completion.Choices[0].Content)
+			return nil
+		}
+		if err := WriteCompletion(cmd, completion, toolCallsIn(history), time.Since(start), false); err != nil {
+			return err
+		}
+		return BlockedError(completion)
 	},
 }
 
+// weatherToolParams are the arguments the model passes to get_current_weather
+type weatherToolParams struct {
+	Location string `json:"location" description:"The city and country, e.g. London, UK"`
+	Unit     string `json:"unit,omitempty" enum:"celsius,fahrenheit"`
+}
+
+// RegisterWeatherTool exposes get_current_weather, backed by source, to the model
+func RegisterWeatherTool(registry *ToolRegistry, source WeatherSource) {
+	RegisterFunc(registry, "get_current_weather", "Get the current weather in a given location",
+		func(ctx context.Context, args weatherToolParams) (any, error) {
+			return source.CurrentWeather(ctx, args.Location, args.Unit)
+		})
+}
+
 func init() {
 	rootCmd.AddCommand(weatherCmd)
+
+	weatherCmd.Flags().StringP("unit", "u", "", "temperature unit to answer in (celsius, fahrenheit)")
+	weatherCmd.Flags().String("weather-source", "", "where weather data comes from, open-meteo or fixture (default $WEATHER_SOURCE or open-meteo)")
+	addSystemFlags(weatherCmd)
+	addSamplingFlags(weatherCmd)
 }
@@ -0,0 +1,179 @@
+package cmd
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestGetWeather(t *testing.T) {
+	tests := []struct {
+		name      string
+		args      []string
+		env       map[string]string
+		responses []fakeResponse
+		// wantToolResults are parts of the tool results sent back to the model
+		wantToolResults []string
+		wantStdout      []string
+		wantStderr      []string
+		wantCode        int
+	}{
+		{
+			name: "tool call answered from fixture",
+			args: []string{"get-weather", "--weather-source", "fixture", "London"},
+			responses: []fakeResponse{
+				azureToolCalls("get_current_weather", `{"location":"London, UK"}`),
+				azureChat("It is 14°C with light rain in London."),
+			},
+			wantToolResults: []string{`"location":"London, UK"`, `"temperature":14`, `"description":"Light rain"`},
+			wantStdout:      []string{"It is 14°C with light rain in London."},
+			wantStderr:      []string{`Calling tool get_current_weather({"location":"London, UK"})`},
+		},
+		{
+			name: "unit passed through",
+			args: []string{"get-weather", "--weather-source", "fixture", "--unit", "fahrenheit", "Paris"},
+			responses: []fakeResponse{
+				azureToolCalls("get_current_weather", `{"location":"Paris, France","unit":"fahrenheit"}`),
+				azureChat("It is 62.6°F in Paris."),
+			},
+			wantToolResults: []string{`"temperature":62.6`, `"unit":"fahrenheit"`},
+			wantStdout:      []string{"It is 62.6°F in Paris."},
+		},
+		{
+			name: "invalid arguments reported to the model",
+			args: []string{"get-weather", "--weather-source", "fixture", "Tokyo"},
+			responses: []fakeResponse{
+				azureToolCalls("get_current_weather", `{"location":"Tokyo","unit":"kelvin"}`),
+				azureChat("Sorry, I can only use celsius or fahrenheit."),
+			},
+			wantToolResults: []string{`"error"`, "must be one of celsius, fahrenheit"},
+			wantStdout:      []string{"Sorry, I can only use celsius or fahrenheit."},
+		},
+		{
+			name: "parallel tool calls",
+			args: []string{"get-weather", "--weather-source", "fixture", "London and Paris"},
+			responses: []fakeResponse{
+				azureToolCalls(
+					"get_current_weather", `{"location":"London"}`,
+					"get_current_weather", `{"location":"Paris"}`,
+				),
+				azureChat("London is rainy, Paris is partly cloudy."),
+			},
+			wantToolResults: []string{"Light rain", "Partly cloudy"},
+			wantStdout:      []string{"London is rainy, Paris is partly cloudy."},
+		},
+		{
+			name: "json output lists tool calls",
+			args: []string{"get-weather", "--weather-source", "fixture", "-o", "json", "New York"},
+			responses: []fakeResponse{
+				azureToolCalls("get_current_weather", `{"location":"New York"}`),
+				azureChat("Clear skies in New York."),
+			},
+			wantToolResults: []string{"Clear sky"},
+			wantStdout:      []string{`"command":"get-weather"`, `"name":"get_current_weather"`, `"content":"Clear skies in New York."`},
+		},
+		{
+			name: "source from the WEATHER_SOURCE setting",
+			args: []string{"get-weather", "London"},
+			env:  map[string]string{"WEATHER_SOURCE": "fixture"},
+			responses: []fakeResponse{
+				azureToolCalls("get_current_weather", `{"location":"London, UK"}`),
+				azureChat("It is 14°C with light rain in London."),
+			},
+			wantToolResults: []string{`"temperature":14`, `"description":"Light rain"`},
+			wantStdout:      []string{"It is 14°C with light rain in London."},
+		},
+		{
+			name:       "unknown WEATHER_SOURCE setting",
+			args:       []string{"get-weather", "London"},
+			env:        map[string]string{"WEATHER_SOURCE": "almanac"},
+			wantStderr: []string{`Error: unknown weather source "almanac"`, "Hint: set WEATHER_SOURCE or --weather-source"},
+			wantCode:   int(KindUsage),
+		},
+		{
+			name:       "ollama cannot call the weather tool",
+			args:       []string{"get-weather", "--provider", "ollama", "London"},
+			wantStderr: []string{"Error: tools are not supported by the ollama provider"},
+			wantCode:   int(KindUsage),
+		},
+		{
+			name:       "empty reply",
+			args:       []string{"get-weather", "London"},
+			responses:  []fakeResponse{azureChat()},
+			wantStderr: []string{"Error: no reply received", "Hint: the model sent back an empty answer"},
+			wantCode:   int(KindUnknown),
+		},
+		{
+			name: "flag overrides the WEATHER_SOURCE setting",
+			args: []string{"get-weather", "--weather-source", "fixture", "London"},
+			env:  map[string]string{"WEATHER_SOURCE": "almanac"},
+			responses: []fakeResponse{
+				azureToolCalls("get_current_weather", `{"location":"London, UK"}`),
+				azureChat("It is 14°C with light rain in London."),
+			},
+			wantToolResults: []string{`"description":"Light rain"`},
+			wantStdout:      []string{"It is 14°C with light rain in London."},
+		},
+		{
+			name:       "invalid unit",
+			args:       []string{"get-weather", "--unit", "kelvin", "London"},
+			wantStderr: []string{`Error: unit must be celsius or fahrenheit, got "kelvin"`},
+			wantCode:   int(KindUsage),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := newFakeServer(t)
+			server.Queue(routeAzureChat, test.responses...)
+			for key, value := range test.env {
+				server.Env[key] = value
+			}
+
+			stdout, stderr, code := runCommandWithCode(t, server, "", test.args...)
+
+			if code != test.wantCode {
+				t.Errorf("exit code %d, want %d", code, test.wantCode)
+			}
+
+			for _, want := range test.wantStdout {
+				if !strings.Contains(stdout, want) {
+					t.Errorf("stdout %q does not contain %q", stdout, want)
+				}
+			}
+			for _, want := range test.wantStderr {
+				if !strings.Contains(stderr, want) {
+					t.Errorf("stderr %q does not contain %q", stderr, want)
+				}
+			}
+
+			if requests := server.Requests(routeOllamaChat); len(requests) != 0 {
+				t.Errorf("got %d Ollama requests, want none", len(requests))
+			}
+			requests := server.Requests(routeAzureChat)
+			if len(requests) != len(test.responses) {
+				t.Fatalf("got %d requests, want %d", len(requests), len(test.responses))
+			}
+			if len(requests) == 0 {
+				return
+			}
+			if _, ok := requests[0]["tools"]; !ok {
+				t.Errorf("first request does not offer any tools")
+			}
+
+			var toolResults strings.Builder
+			messages, _ := requests[len(requests)-1]["messages"].([]any)
+			for _, message := range messages {
+				message, _ := message.(map[string]any)
+				if message["role"] == string(RoleTool) {
+					content, _ := message["content"].(string)
+					toolResults.WriteString(content)
+				}
+			}
+			for _, want := range test.wantToolResults {
+				if !strings.Contains(toolResults.String(), want) {
+					t.Errorf("tool results %q do not contain %q", toolResults.String(), want)
+				}
+			}
+		})
+	}
+}
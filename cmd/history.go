@@ -0,0 +1,421 @@
+package cmd
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// HistoryEntry is one recorded exchange with a model
+type HistoryEntry struct {
+	ID       int       `json:"id"`
+	Time     time.Time `json:"time"`
+	Command  string    `json:"command"`
+	Provider string    `json:"provider"`
+	Model    string    `json:"model,omitempty"`
+	Prompt   string    `json:"prompt"`
+	Response string    `json:"response"`
+	Usage    *Usage    `json:"usage,omitempty"`
+	Cost     *float64  `json:"cost_usd,omitempty"`
+	// Messages is the conversation that was sent, so it can be continued
+	Messages []Message `json:"messages,omitempty"`
+	// ParentID is the entry this one continued, if any
+	ParentID int `json:"parent_id,omitempty"`
+}
+
+// HistoryStore keeps entries in a JSON lines file, one entry per line
+type HistoryStore struct {
+	Path string
+}
+
+// DefaultHistoryPath returns ~/.local/share/go-cli-gpt/history.jsonl, honouring
+// HISTORY_FILE and XDG_DATA_HOME
+func DefaultHistoryPath() string {
+	if path := os.Getenv("HISTORY_FILE"); path != "" {
+		return path
+	}
+	dir := os.Getenv("XDG_DATA_HOME")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return ""
+		}
+		dir = filepath.Join(home, ".local", "share")
+	}
+	return filepath.Join(dir, "go-cli-gpt", "history.jsonl")
+}
+
+// OpenHistory returns the store at the default location
+func OpenHistory() *HistoryStore {
+	return &HistoryStore{Path: DefaultHistoryPath()}
+}
+
+// List returns every entry, oldest first. A missing file is an empty history.
+func (s *HistoryStore) List() ([]HistoryEntry, error) {
+	file, err := os.Open(s.Path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var entries []HistoryEntry
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(strings.TrimSpace(scanner.Text())) == 0 {
+			continue
+		}
+		var entry HistoryEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return nil, fmt.Errorf("%s line %d: %w", s.Path, line, err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, scanner.Err()
+}
+
+// lastIDPath is the file remembering the highest ID ever given out, so the
+// IDs of deleted entries are not handed out again
+func (s *HistoryStore) lastIDPath() string {
+	return s.Path + ".last-id"
+}
+
+// lastID returns the highest ID given out so far, looking at the entries
+// and at what was remembered when entries were deleted
+func (s *HistoryStore) lastID(entries []HistoryEntry) int {
+	last := 0
+	if data, err := os.ReadFile(s.lastIDPath()); err == nil {
+		last, _ = strconv.Atoi(strings.TrimSpace(string(data)))
+	}
+	for _, entry := range entries {
+		last = max(last, entry.ID)
+	}
+	return last
+}
+
+// Add appends an entry, giving it the next ID. IDs are never reused, not
+// even after the entries holding them were deleted.
+func (s *HistoryStore) Add(entry *HistoryEntry) error {
+	entries, err := s.List()
+	if err != nil {
+		return err
+	}
+	entry.ID = s.lastID(entries) + 1
+
+	if err := os.MkdirAll(filepath.Dir(s.Path), 0o700); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(s.Path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	return json.NewEncoder(file).Encode(entry)
+}
+
+// Get returns the entry with the given ID
+func (s *HistoryStore) Get(id int) (*HistoryEntry, error) {
+	entries, err := s.List()
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		if entry.ID == id {
+			return &entry, nil
+		}
+	}
+	return nil, &Error{Kind: KindUsage, Err: fmt.Errorf("history entry %d not found", id), Hint: "run `go-cli-gpt history list` to see the recorded entries"}
+}
+
+// Search returns the entries whose prompt or response contains query, ignoring case
+func (s *HistoryStore) Search(query string) ([]HistoryEntry, error) {
+	entries, err := s.List()
+	if err != nil {
+		return nil, err
+	}
+	query = strings.ToLower(query)
+	var matches []HistoryEntry
+	for _, entry := range entries {
+		if strings.Contains(strings.ToLower(entry.Prompt), query) || strings.Contains(strings.ToLower(entry.Response), query) {
+			matches = append(matches, entry)
+		}
+	}
+	return matches, nil
+}
+
+// Delete removes the entries with the given IDs, or every entry when ids is
+// empty, and returns how many were removed. Nothing is removed when one of
+// the IDs is not in the history.
+func (s *HistoryStore) Delete(ids ...int) (int, error) {
+	entries, err := s.List()
+	if err != nil {
+		return 0, err
+	}
+
+	known := map[int]bool{}
+	for _, entry := range entries {
+		known[entry.ID] = true
+	}
+	remove := map[int]bool{}
+	for _, id := range ids {
+		if !known[id] {
+			return 0, &Error{Kind: KindUsage, Err: fmt.Errorf("no history entry %d", id), Hint: "run `go-cli-gpt history list` to see the recorded entries"}
+		}
+		remove[id] = true
+	}
+	var kept []HistoryEntry
+	for _, entry := range entries {
+		if len(ids) > 0 && !remove[entry.ID] {
+			kept = append(kept, entry)
+		}
+	}
+	if len(kept) == len(entries) {
+		return 0, nil
+	}
+
+	// Remember the last ID before the entry holding it may go
+	if err := os.WriteFile(s.lastIDPath(), []byte(strconv.Itoa(s.lastID(entries))+"\n"), 0o600); err != nil {
+		return 0, err
+	}
+
+	// Rewrite through a temporary file so a failure cannot lose the history
+	tmp, err := os.CreateTemp(filepath.Dir(s.Path), ".history-*.jsonl")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(tmp.Name())
+	encoder := json.NewEncoder(tmp)
+	for _, entry := range kept {
+		if err := encoder.Encode(entry); err != nil {
+			tmp.Close()
+			return 0, err
+		}
+	}
+	if err := tmp.Close(); err != nil {
+		return 0, err
+	}
+	if err := os.Chmod(tmp.Name(), 0o600); err != nil {
+		return 0, err
+	}
+	return len(entries) - len(kept), os.Rename(tmp.Name(), s.Path)
+}
+
+// RecordHistory saves an exchange made by cmd unless --no-history is set.
+// Failing to record is only a warning, the answer has already been given.
+func RecordHistory(cmd *cobra.Command, entry HistoryEntry) {
+	if noHistory, _ := cmd.Flags().GetBool("no-history"); noHistory {
+		return
+	}
+	entry.Time = time.Now()
+	entry.Command = cmd.Name()
+	if entry.Provider == "" {
+		entry.Provider = ProviderName(cmd)
+	}
+	if err := OpenHistory().Add(&entry); err != nil {
+		fmt.Fprintf(cmd.ErrOrStderr(), "Warning: could not save history: %s\n", err)
+	}
+}
+
+// RecordCompletion saves a chat exchange, taking the model and usage from
+// the completion and the response from the given choice
+func RecordCompletion(cmd *cobra.Command, prompt string, messages []Message, completion *Completion, choice int, parentID int) {
+	if completion == nil {
+		return
+	}
+	entry := HistoryEntry{Prompt: prompt, Model: completion.Model, Usage: completion.Usage, Cost: completionCost(completion), Messages: messages, ParentID: parentID}
+	if choice < len(completion.Choices) {
+		entry.Response = completion.Choices[choice].Content
+	}
+	RecordHistory(cmd, entry)
+}
+
+// ContinueConversation returns the messages of a recorded exchange followed
+// by its answer, ready for a follow up question
+func ContinueConversation(id int) ([]Message, error) {
+	entry, err := OpenHistory().Get(id)
+	if err != nil {
+		return nil, err
+	}
+	messages := append([]Message{}, entry.Messages...)
+	if len(messages) == 0 {
+		messages = append(messages, Message{Role: RoleUser, Content: entry.Prompt})
+	}
+	return append(messages, Message{Role: RoleAssistant, Content: entry.Response}), nil
+}
+
+// historyCmd represents the history command
+var historyCmd = &cobra.Command{
+	Use:   "history",
+	Short: "list, search, show and delete recorded questions and answers",
+	Long: `every question, translation and image prompt is recorded to ~/.local/share/go-cli-gpt/history.jsonl
+(or HISTORY_FILE), unless --no-history is given
+
+Continue a recorded exchange with:
+  go-cli-gpt question --continue <id> "and what about..."`,
+}
+
+var historyListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "list the most recent entries",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		entries, err := OpenHistory().List()
+		if err != nil {
+			return err
+		}
+		limit, _ := cmd.Flags().GetInt("limit")
+		if limit > 0 && len(entries) > limit {
+			entries = entries[len(entries)-limit:]
+		}
+		return writeHistoryEntries(cmd, entries)
+	},
+}
+
+var historySearchCmd = &cobra.Command{
+	Use:   "search <text>",
+	Short: "find entries whose prompt or response contains text",
+	Args:  cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		entries, err := OpenHistory().Search(strings.Join(args, " "))
+		if err != nil {
+			return err
+		}
+		return writeHistoryEntries(cmd, entries)
+	},
+}
+
+var historyShowCmd = &cobra.Command{
+	Use:   "show <id>",
+	Short: "show an entry in full",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		id, err := parseHistoryID(args[0])
+		if err != nil {
+			return err
+		}
+		entry, err := OpenHistory().Get(id)
+		if err != nil {
+			return err
+		}
+
+		w := cmd.OutOrStdout()
+		if GetOutputFormat(cmd) == OutputJSON {
+			return json.NewEncoder(w).Encode(entry)
+		}
+		fmt.Fprintf(w, "ID:       %d\n", entry.ID)
+		fmt.Fprintf(w, "Time:     %s\n", entry.Time.Local().Format(time.RFC1123))
+		fmt.Fprintf(w, "Command:  %s\n", entry.Command)
+		fmt.Fprintf(w, "Provider: %s\n", entry.Provider)
+		if entry.Model != "" {
+			fmt.Fprintf(w, "Model:    %s\n", entry.Model)
+		}
+		if entry.Usage != nil {
+			fmt.Fprintf(w, "Tokens:   %d prompt, %d completion, %d total\n", entry.Usage.PromptTokens, entry.Usage.CompletionTokens, entry.Usage.TotalTokens)
+		}
+		if entry.Cost != nil {
+			fmt.Fprintf(w, "Cost:     %s (estimated)\n", FormatCost(*entry.Cost))
+		}
+		if entry.ParentID != 0 {
+			fmt.Fprintf(w, "Continues: %d\n", entry.ParentID)
+		}
+		fmt.Fprintf(w, "\nPrompt:\n%s\n\nResponse:\n%s\n", entry.Prompt, entry.Response)
+		return nil
+	},
+}
+
+var historyDeleteCmd = &cobra.Command{
+	Use:   "delete <id>...",
+	Short: "delete entries, or every entry with --all",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		all, _ := cmd.Flags().GetBool("all")
+		if all == (len(args) > 0) {
+			return Errorf(KindUsage, "give the IDs of the entries to delete, or --all")
+		}
+
+		var ids []int
+		for _, arg := range args {
+			id, err := parseHistoryID(arg)
+			if err != nil {
+				return err
+			}
+			ids = append(ids, id)
+		}
+
+		deleted, err := OpenHistory().Delete(ids...)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(cmd.ErrOrStderr(), "Deleted %d entries\n", deleted)
+		return nil
+	},
+}
+
+func parseHistoryID(arg string) (int, error) {
+	id, err := strconv.Atoi(arg)
+	if err != nil || id < 1 {
+		return 0, Errorf(KindUsage, "invalid history ID %q", arg)
+	}
+	return id, nil
+}
+
+// writeHistoryEntries prints one line per entry, or one JSON object per line
+func writeHistoryEntries(cmd *cobra.Command, entries []HistoryEntry) error {
+	w := cmd.OutOrStdout()
+	if GetOutputFormat(cmd) == OutputJSON {
+		encoder := json.NewEncoder(w)
+		for _, entry := range entries {
+			if err := encoder.Encode(entry); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if len(entries) == 0 {
+		fmt.Fprintln(cmd.ErrOrStderr(), "No history entries")
+		return nil
+	}
+	tokens, cost := 0, 0.0
+	for _, entry := range entries {
+		fmt.Fprintf(w, "%4d  %s  %-10s %-20s %s\n", entry.ID, entry.Time.Local().Format("2006-01-02 15:04"), entry.Command, entry.Model, summarize(entry.Prompt, 60))
+		if entry.Usage != nil {
+			tokens += entry.Usage.TotalTokens
+		}
+		if entry.Cost != nil {
+			cost += *entry.Cost
+		}
+	}
+	fmt.Fprintf(cmd.ErrOrStderr(), "%d entries, %d tokens, estimated cost %s\n", len(entries), tokens, FormatCost(cost))
+	return nil
+}
+
+// summarize shortens text to a single line of at most width characters
+func summarize(text string, width int) string {
+	text = strings.Join(strings.Fields(text), " ")
+	runes := []rune(text)
+	if len(runes) <= width {
+		return text
+	}
+	return string(runes[:width-1]) + "…"
+}
+
+func init() {
+	rootCmd.AddCommand(historyCmd)
+	historyCmd.AddCommand(historyListCmd, historySearchCmd, historyShowCmd, historyDeleteCmd)
+
+	historyListCmd.Flags().IntP("limit", "n", 20, "number of entries to show, 0 for all")
+	historyDeleteCmd.Flags().Bool("all", false, "delete the whole history")
+}
@@ -0,0 +1,159 @@
+package cmd
+
+import (
+	"encoding/json"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestHistoryStore(t *testing.T) {
+	store := &HistoryStore{Path: t.TempDir() + "/history.jsonl"}
+
+	entries, err := store.List()
+	if err != nil || len(entries) != 0 {
+		t.Fatalf("empty store: got %v, %v", entries, err)
+	}
+
+	for _, prompt := range []string{"What is Go?", "Translate hello", "What is Rust?"} {
+		entry := HistoryEntry{Prompt: prompt, Response: "answer to " + prompt}
+		if err := store.Add(&entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name    string
+		query   string
+		wantIDs []int
+	}{
+		{name: "prompt", query: "what is", wantIDs: []int{1, 3}},
+		{name: "response", query: "ANSWER TO TRANSLATE", wantIDs: []int{2}},
+		{name: "no match", query: "python", wantIDs: nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			matches, err := store.Search(test.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var ids []int
+			for _, match := range matches {
+				ids = append(ids, match.ID)
+			}
+			if len(ids) != len(test.wantIDs) {
+				t.Fatalf("got IDs %v, want %v", ids, test.wantIDs)
+			}
+			for i := range ids {
+				if ids[i] != test.wantIDs[i] {
+					t.Fatalf("got IDs %v, want %v", ids, test.wantIDs)
+				}
+			}
+		})
+	}
+
+	if deleted, err := store.Delete(3); err != nil || deleted != 1 {
+		t.Fatalf("delete: got %d, %v", deleted, err)
+	}
+	if _, err := store.Get(3); ExitCode(err) != int(KindUsage) {
+		t.Errorf("get deleted entry: got %v", err)
+	}
+	// IDs are never reused, not even the one of the latest entry
+	entry := HistoryEntry{Prompt: "again"}
+	if err := store.Add(&entry); err != nil || entry.ID != 4 {
+		t.Errorf("add after delete: got ID %d, %v", entry.ID, err)
+	}
+	if _, err := store.Delete(1, 9); ExitCode(err) != int(KindUsage) || !strings.Contains(err.Error(), "no history entry 9") {
+		t.Errorf("delete unknown entry: got %v", err)
+	}
+	if _, err := store.Get(1); err != nil {
+		t.Errorf("delete with an unknown ID removed entry 1: %v", err)
+	}
+	if deleted, err := store.Delete(); err != nil || deleted != 3 {
+		t.Fatalf("delete all: got %d, %v", deleted, err)
+	}
+	entry = HistoryEntry{Prompt: "after delete all"}
+	if err := store.Add(&entry); err != nil || entry.ID != 5 {
+		t.Errorf("add after delete all: got ID %d, %v", entry.ID, err)
+	}
+}
+
+func TestHistoryCommands(t *testing.T) {
+	server := newFakeServer(t)
+	server.Queue(routeAzureChat, azureChat("Go is a programming language."), azureChat("Robert Griesemer, Rob Pike and Ken Thompson."))
+
+	runCommand(t, server, "", "question", "What is Go?")
+	runCommand(t, server, "", "question", "--no-history", "Not recorded")
+
+	tests := []struct {
+		name       string
+		args       []string
+		wantStdout []string
+		wantStderr []string
+		wantCode   int
+	}{
+		{name: "list", args: []string{"history", "list"}, wantStdout: []string{"   1  ", "question", "gpt-test", "What is Go?"}},
+		{name: "search", args: []string{"history", "search", "programming"}, wantStdout: []string{"What is Go?"}},
+		{name: "search without match", args: []string{"history", "search", "Not recorded"}, wantStderr: []string{"No history entries"}},
+		{name: "show", args: []string{"history", "show", "1"}, wantStdout: []string{"Provider: azure", "Tokens:   12 prompt, 5 completion, 17 total", "Response:\nGo is a programming language."}},
+		{name: "show json", args: []string{"history", "show", "-o", "json", "1"}, wantStdout: []string{`"id":1`, `"response":"Go is a programming language."`}},
+		{name: "show missing", args: []string{"history", "show", "9"}, wantStderr: []string{"Error: history entry 9 not found"}, wantCode: int(KindUsage)},
+		{name: "invalid ID", args: []string{"history", "show", "first"}, wantStderr: []string{`Error: invalid history ID "first"`}, wantCode: int(KindUsage)},
+		{name: "delete without IDs", args: []string{"history", "delete"}, wantStderr: []string{"Error: give the IDs"}, wantCode: int(KindUsage)},
+		{name: "delete missing", args: []string{"history", "delete", "9"}, wantStderr: []string{"Error: no history entry 9", "Hint: run `go-cli-gpt history list`"}, wantCode: int(KindUsage)},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			stdout, stderr, code := runCommandWithCode(t, server, "", test.args...)
+			if code != test.wantCode {
+				t.Errorf("exit code %d, want %d", code, test.wantCode)
+			}
+			for _, want := range test.wantStdout {
+				if !strings.Contains(stdout, want) {
+					t.Errorf("stdout %q does not contain %q", stdout, want)
+				}
+			}
+			for _, want := range test.wantStderr {
+				if !strings.Contains(stderr, want) {
+					t.Errorf("stderr %q does not contain %q", stderr, want)
+				}
+			}
+		})
+	}
+}
+
+func TestQuestionContinue(t *testing.T) {
+	server := newFakeServer(t)
+	server.Queue(routeAzureChat, azureChat("Go is a programming language."), azureChat("In 2009."))
+
+	runCommand(t, server, "", "question", "What is Go?")
+	stdout, _ := runCommand(t, server, "", "question", "--continue", "1", "When was it released?")
+	if stdout != "In 2009.\n" {
+		t.Fatalf("stdout %q is not the answer", stdout)
+	}
+
+	requests := server.Requests(routeAzureChat)
+	if len(requests) != 2 {
+		t.Fatalf("got %d requests, want 2", len(requests))
+	}
+	var sent []string
+	for _, message := range requests[1]["messages"].([]any) {
+		sent = append(sent, message.(map[string]any)["content"].(string))
+	}
+	if len(sent) != 4 || sent[1] != "What is Go?" || sent[2] != "Go is a programming language." || sent[3] != "When was it released?" {
+		t.Errorf("continued conversation sent %q", sent)
+	}
+
+	data, err := os.ReadFile(server.Env["HISTORY_FILE"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	var entry HistoryEntry
+	if err := json.Unmarshal([]byte(lines[len(lines)-1]), &entry); err != nil {
+		t.Fatal(err)
+	}
+	if entry.ID != 2 || entry.ParentID != 1 || len(entry.Messages) != 4 {
+		t.Errorf("unexpected entry %+v", entry)
+	}
+}
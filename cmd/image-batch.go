@@ -0,0 +1,476 @@
+package cmd
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/ai/azopenai"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/spf13/cobra"
+)
+
+// Status of a prompt in a batch manifest
+const (
+	BatchPending = "pending"
+	BatchDone    = "done"
+	BatchFailed  = "failed"
+)
+
+// maxBatchConcurrency caps --concurrency, well above what image quotas allow
+const maxBatchConcurrency = 16
+
+// maxThrottledAttempts is how often a prompt is tried when the service keeps
+// throttling it, on top of the retries every request gets
+const maxThrottledAttempts = 3
+
+// BatchEntry is one prompt read from a --batch file. Lines of a text file
+// only have a prompt, JSON lines can also set the id and image options.
+type BatchEntry struct {
+	ID      string `json:"id"`
+	Prompt  string `json:"prompt"`
+	Size    string `json:"size"`
+	Quality string `json:"quality"`
+	Style   string `json:"style"`
+	Line    int    `json:"-"`
+}
+
+// ManifestEntry records what became of one prompt of a batch
+type ManifestEntry struct {
+	ID             string     `json:"id"`
+	Line           int        `json:"line"`
+	Prompt         string     `json:"prompt"`
+	Status         string     `json:"status"`
+	Files          []string   `json:"files,omitempty"`
+	RevisedPrompts []string   `json:"revised_prompts,omitempty"`
+	Error          string     `json:"error,omitempty"`
+	Completed      *time.Time `json:"completed,omitempty"`
+}
+
+// BatchManifest maps every prompt of a batch file to the images made from
+// it, so an interrupted batch can pick up where it stopped. Files are
+// relative to the directory of the manifest.
+type BatchManifest struct {
+	Source  string          `json:"source"`
+	Model   string          `json:"model"`
+	Updated time.Time       `json:"updated"`
+	Entries []ManifestEntry `json:"entries"`
+}
+
+// addBatchFlags adds the flags for creating images from a file of prompts
+func addBatchFlags(cmd *cobra.Command) {
+	cmd.Flags().String("batch", "", "create an image for every line of a .txt file, or every object of a .jsonl file, of prompts")
+	cmd.Flags().Int("concurrency", 2, "number of prompts of a batch worked on at once")
+	cmd.Flags().Int("rate-limit", 0, "most image requests per minute in a batch, 0 for no limit (default $IMAGE_RATE_LIMIT)")
+	cmd.Flags().String("manifest", "", "where to record the outcome of a batch (default manifest.json in the output directory)")
+}
+
+// ReadBatch reads the prompts of a batch file, as JSON lines when the file
+// ends in .jsonl and as one prompt per line otherwise. Blank lines and text
+// lines starting with # are skipped.
+func ReadBatch(path string) ([]BatchEntry, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, &Error{Kind: KindUsage, Err: err, Hint: "pass a file of prompts to --batch"}
+	}
+	defer file.Close()
+
+	jsonLines := strings.EqualFold(filepath.Ext(path), ".jsonl")
+	var entries []BatchEntry
+	ids := map[string]int{}
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || (!jsonLines && strings.HasPrefix(text, "#")) {
+			continue
+		}
+
+		entry := BatchEntry{Prompt: text}
+		if jsonLines {
+			entry = BatchEntry{}
+			if err := json.Unmarshal([]byte(text), &entry); err != nil {
+				return nil, Errorf(KindUsage, "%s:%d: %s", path, line, err)
+			}
+			entry.Prompt = strings.TrimSpace(entry.Prompt)
+			if entry.Prompt == "" {
+				return nil, Errorf(KindUsage, "%s:%d: no prompt given", path, line)
+			}
+		}
+		entry.Line = line
+		if entry.ID == "" {
+			entry.ID = strconv.Itoa(line)
+		}
+		if first, ok := ids[entry.ID]; ok {
+			return nil, Errorf(KindUsage, "%s:%d: id %q is already used on line %d", path, line, entry.ID, first)
+		}
+		ids[entry.ID] = line
+		entries = append(entries, entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(entries) == 0 {
+		return nil, Errorf(KindUsage, "no prompts in %s", path)
+	}
+	return entries, nil
+}
+
+// LoadManifest reads a batch manifest, returning an empty one when the
+// batch has not been started yet
+func LoadManifest(path string) (*BatchManifest, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return &BatchManifest{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var manifest BatchManifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, fmt.Errorf("reading manifest %s: %w", path, err)
+	}
+	return &manifest, nil
+}
+
+// Save writes the manifest through a temporary file, so an interrupted
+// batch never leaves a half written one behind
+func (m *BatchManifest) Save(path string) error {
+	m.Updated = time.Now()
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".manifest-*.json")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// completed reports whether an entry was done for the same prompt and all
+// its images are still there
+func (e *ManifestEntry) completed(prompt string, dir string) bool {
+	if e.Status != BatchDone || e.Prompt != prompt || len(e.Files) == 0 {
+		return false
+	}
+	for _, file := range e.Files {
+		if _, err := os.Stat(filepath.Join(dir, file)); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// rateLimiter spaces requests evenly so they stay under a number per
+// minute, and holds them all back after the service throttled one
+type rateLimiter struct {
+	mu       sync.Mutex
+	interval time.Duration
+	next     time.Time
+}
+
+func newRateLimiter(perMinute int) *rateLimiter {
+	limiter := &rateLimiter{}
+	if perMinute > 0 {
+		limiter.interval = time.Minute / time.Duration(perMinute)
+	}
+	return limiter
+}
+
+// Wait blocks until the next request may be sent
+func (l *rateLimiter) Wait(ctx context.Context) error {
+	l.mu.Lock()
+	at := time.Now()
+	if l.next.After(at) {
+		at = l.next
+	}
+	l.next = at.Add(l.interval)
+	l.mu.Unlock()
+
+	timer := time.NewTimer(time.Until(at))
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Pause holds back every request for d
+func (l *rateLimiter) Pause(d time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if until := time.Now().Add(d); until.After(l.next) {
+		l.next = until
+	}
+}
+
+// throttleDelay returns how long to hold the batch back after a throttled
+// request: as long as the service asked for, or a growing backoff when it
+// did not say
+func throttleDelay(err error, attempt int) time.Duration {
+	var respErr *azcore.ResponseError
+	if errors.As(err, &respErr) {
+		if delay, ok := retryAfter(respErr.RawResponse); ok {
+			return delay
+		}
+	}
+	return activeRetryPolicy.Delay(attempt, nil)
+}
+
+// getRateLimit returns --rate-limit, falling back to the IMAGE_RATE_LIMIT setting
+func getRateLimit(cmd *cobra.Command) (int, error) {
+	limit, _ := cmd.Flags().GetInt("rate-limit")
+	if !cmd.Flags().Changed("rate-limit") && os.Getenv("IMAGE_RATE_LIMIT") != "" {
+		value, err := strconv.Atoi(os.Getenv("IMAGE_RATE_LIMIT"))
+		if err != nil {
+			return 0, Errorf(KindUsage, "invalid IMAGE_RATE_LIMIT %q: must be a whole number", os.Getenv("IMAGE_RATE_LIMIT"))
+		}
+		limit = value
+	}
+	if limit < 0 {
+		return 0, Errorf(KindUsage, "--rate-limit must be 0 or more, got %d", limit)
+	}
+	return limit, nil
+}
+
+// RunImageBatch creates the images for every prompt of a batch file, a few
+// at a time, skipping the prompts a previous run already completed. The
+// outcome of every prompt is kept in the manifest as the batch goes.
+func RunImageBatch(cmd *cobra.Command, path string, model ImageModel, options azopenai.ImageGenerationOptions, format string) error {
+	entries, err := ReadBatch(path)
+	if err != nil {
+		return err
+	}
+	for i, entry := range entries {
+		for _, option := range []struct {
+			flag    string
+			value   *string
+			allowed []string
+		}{
+			{"size", &entries[i].Size, model.Sizes},
+			{"quality", &entries[i].Quality, model.Qualities},
+			{"style", &entries[i].Style, model.Styles},
+		} {
+			if *option.value, err = checkImageOption(option.flag, *option.value, model, option.allowed); err != nil {
+				return Errorf(KindUsage, "%s:%d: %s", path, entry.Line, err)
+			}
+		}
+	}
+
+	concurrency, _ := cmd.Flags().GetInt("concurrency")
+	if concurrency < 1 || concurrency > maxBatchConcurrency {
+		return Errorf(KindUsage, "--concurrency must be between 1 and %d, got %d", maxBatchConcurrency, concurrency)
+	}
+	rateLimit, err := getRateLimit(cmd)
+	if err != nil {
+		return err
+	}
+
+	saver, err := GetImageSaver(cmd)
+	if err != nil {
+		return err
+	}
+	// Without a directory a batch keeps its images next to the prompts file
+	if !cmd.Flags().Changed("out-dir") && os.Getenv("IMAGE_DIR") == "" {
+		saver.Dir = strings.TrimSuffix(path, filepath.Ext(path)) + "-images"
+	}
+	manifestPath, _ := cmd.Flags().GetString("manifest")
+	if manifestPath == "" {
+		manifestPath = filepath.Join(saver.Dir, "manifest.json")
+	}
+	manifestDir := filepath.Dir(manifestPath)
+
+	previous, err := LoadManifest(manifestPath)
+	if err != nil {
+		return err
+	}
+	done := map[string]ManifestEntry{}
+	for _, entry := range previous.Entries {
+		done[entry.ID] = entry
+	}
+
+	manifest := &BatchManifest{Source: path, Model: model.Name, Entries: make([]ManifestEntry, len(entries))}
+	var pending []int
+	for i, entry := range entries {
+		if previous, ok := done[entry.ID]; ok && previous.completed(entry.Prompt, manifestDir) {
+			manifest.Entries[i] = previous
+			manifest.Entries[i].Line = entry.Line
+			continue
+		}
+		manifest.Entries[i] = ManifestEntry{ID: entry.ID, Line: entry.Line, Prompt: entry.Prompt, Status: BatchPending}
+		pending = append(pending, i)
+	}
+	if err := manifest.Save(manifestPath); err != nil {
+		return fmt.Errorf("writing manifest: %w", err)
+	}
+	skipped := len(entries) - len(pending)
+	fmt.Fprintf(cmd.ErrOrStderr(), "Creating images for %d prompts (%d already done), saving them to %s\n", len(pending), skipped, saver.Dir)
+	if len(pending) == 0 {
+		return nil
+	}
+
+	client, err := NewAzureClient()
+	if err != nil {
+		return err
+	}
+
+	// Ctrl-C stops handing out prompts and abandons the ones in flight,
+	// which stay pending for the next run
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	limiter := newRateLimiter(rateLimit)
+	var mu sync.Mutex
+	finished, failed := 0, 0
+	record := func(i int, entry ManifestEntry, results []Result) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		manifest.Entries[i] = entry
+		if err := manifest.Save(manifestPath); err != nil {
+			log.Printf("Warning: could not write manifest: %s", err)
+		}
+		finished++
+		if entry.Status == BatchFailed {
+			failed++
+			fmt.Fprintf(cmd.ErrOrStderr(), "[%d/%d] failed %q: %s\n", finished, len(pending), summarize(entry.Prompt, 40), entry.Error)
+			return
+		}
+
+		fmt.Fprintf(cmd.ErrOrStderr(), "[%d/%d] done %q\n", finished, len(pending), summarize(entry.Prompt, 40))
+		for _, result := range results {
+			if err := WriteImageResult(cmd, result); err != nil {
+				log.Printf("Warning: %s", err)
+			}
+		}
+		RecordHistory(cmd, HistoryEntry{Provider: "azure", Model: *options.DeploymentName, Prompt: entry.Prompt, Response: strings.Join(entry.Files, "\n")})
+	}
+
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for worker := 0; worker < concurrency; worker++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				entry, results, ok := runBatchEntry(ctx, client, limiter, saver, manifestDir, entries[i], options, format)
+				if ok {
+					record(i, entry, results)
+				}
+			}
+		}()
+	}
+feed:
+	for _, i := range pending {
+		select {
+		case jobs <- i:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	left := len(pending) - finished
+	fmt.Fprintf(cmd.ErrOrStderr(), "Batch finished: %d done, %d skipped, %d failed, %d left; manifest written to %s\n", finished-failed, skipped, failed, left, manifestPath)
+	switch {
+	case ctx.Err() != nil:
+		return &Error{Kind: KindInterrupted, Err: fmt.Errorf("interrupted with %d of %d prompts left", left, len(entries)), Hint: "run the same command again to resume"}
+	case failed > 0:
+		return &Error{Kind: KindUnknown, Err: fmt.Errorf("%d of %d prompts failed, see %s", failed, len(entries), manifestPath), Hint: "run the same command again to retry them"}
+	}
+	return nil
+}
+
+// runBatchEntry creates and saves the images for one prompt, trying again
+// after a pause when the service throttles it. It reports false when the
+// batch was interrupted before the prompt was finished.
+func runBatchEntry(ctx context.Context, client *azopenai.Client, limiter *rateLimiter, saver *ImageSaver, manifestDir string, entry BatchEntry, options azopenai.ImageGenerationOptions, format string) (ManifestEntry, []Result, bool) {
+	options.Prompt = to.Ptr(entry.Prompt)
+	if entry.Size != "" {
+		options.Size = to.Ptr(azopenai.ImageSize(entry.Size))
+	}
+	if entry.Quality != "" {
+		options.Quality = to.Ptr(azopenai.ImageGenerationQuality(entry.Quality))
+	}
+	if entry.Style != "" {
+		options.Style = to.Ptr(azopenai.ImageGenerationStyle(entry.Style))
+	}
+	result := ManifestEntry{ID: entry.ID, Line: entry.Line, Prompt: entry.Prompt}
+
+	for attempt := 1; ; attempt++ {
+		if limiter.Wait(ctx) != nil {
+			return result, nil, false
+		}
+
+		start := time.Now()
+		images, created, err := GenerateImages(ctx, client, options, format, true, io.Discard)
+		if ctx.Err() != nil {
+			return result, nil, false
+		}
+		if err != nil {
+			cliErr := ClassifyError(err)
+			if cliErr.Kind == KindThrottled && attempt < maxThrottledAttempts {
+				limiter.Pause(throttleDelay(err, attempt))
+				continue
+			}
+			result.Status = BatchFailed
+			result.Error = cliErr.Error()
+			return result, nil, true
+		}
+
+		var results []Result
+		for i, generated := range images {
+			meta := NewImageMetadata(options, generated, created)
+			meta.ID = entry.ID
+			path, err := saver.Write(generated.Data, generated.ContentType, meta, i+1)
+			if err != nil {
+				// The whole prompt is tried again on resume, so the images
+				// already saved for it would only be duplicated
+				for _, saved := range results {
+					removeImage(saved.ImagePath)
+				}
+				result.Status = BatchFailed
+				result.Error = err.Error()
+				result.Files, result.RevisedPrompts = nil, nil
+				return result, nil, true
+			}
+			file, err := filepath.Rel(manifestDir, path)
+			if err != nil {
+				file = path
+			}
+			result.Files = append(result.Files, file)
+			result.RevisedPrompts = append(result.RevisedPrompts, generated.RevisedPrompt)
+			results = append(results, Result{Command: "image", Model: *options.DeploymentName, Index: i, Prompt: entry.Prompt, ImageURL: generated.URL, ImagePath: path, RevisedPrompt: generated.RevisedPrompt, LatencyMS: time.Since(start).Milliseconds()})
+		}
+		result.Status = BatchDone
+		result.Completed = to.Ptr(time.Now())
+		return result, results, true
+	}
+}
@@ -0,0 +1,301 @@
+package cmd
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+)
+
+func TestReadBatch(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		content string
+		want    []BatchEntry
+		wantErr string
+	}{
+		{
+			name:    "text",
+			file:    "prompts.txt",
+			content: "# cats\na cat in a hat\n\n  a dog on a log  \n",
+			want:    []BatchEntry{{ID: "2", Prompt: "a cat in a hat", Line: 2}, {ID: "4", Prompt: "a dog on a log", Line: 4}},
+		},
+		{
+			name:    "json lines",
+			file:    "prompts.jsonl",
+			content: `{"id": "cat", "prompt": "a cat", "size": "1792x1024"}` + "\n" + `{"prompt": "# not a comment"}` + "\n",
+			want:    []BatchEntry{{ID: "cat", Prompt: "a cat", Size: "1792x1024", Line: 1}, {ID: "2", Prompt: "# not a comment", Line: 2}},
+		},
+		{name: "bad json", file: "prompts.jsonl", content: "a cat\n", wantErr: "prompts.jsonl:1: invalid character"},
+		{name: "no prompt", file: "prompts.jsonl", content: `{"id": "cat"}`, wantErr: "prompts.jsonl:1: no prompt given"},
+		{name: "duplicate id", file: "prompts.jsonl", content: `{"id": "1", "prompt": "a"}` + "\n" + `{"prompt": "b"}` + "\n" + `{"id": "1", "prompt": "c"}`, wantErr: `prompts.jsonl:3: id "1" is already used on line 1`},
+		{name: "empty", file: "prompts.txt", content: "# nothing yet\n", wantErr: "no prompts in"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), test.file)
+			if err := os.WriteFile(path, []byte(test.content), 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			entries, err := ReadBatch(path)
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Fatalf("got error %v, want %q", err, test.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(entries) != len(test.want) {
+				t.Fatalf("got %+v, want %+v", entries, test.want)
+			}
+			for i := range entries {
+				if entries[i] != test.want[i] {
+					t.Errorf("entry %d is %+v, want %+v", i, entries[i], test.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestImageBatch(t *testing.T) {
+	dir := t.TempDir()
+	prompts := filepath.Join(dir, "prompts.txt")
+	if err := os.WriteFile(prompts, []byte("a cat\na dog\nsomething violent\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	manifestPath := filepath.Join(dir, "prompts-images", "manifest.json")
+
+	// The first run makes two images and fails on the third prompt
+	server := newFakeServer(t)
+	server.Queue(routeAzureImages, server.azureImages("/images/cat.png"))
+	server.Queue(routeAzureImages, server.azureImages("/images/dog.png"))
+	server.Queue(routeAzureImages, fakeResponse{Status: http.StatusBadRequest, Body: azureError("contentFilter", "Your request was rejected as a result of our safety system.")})
+
+	stdout, stderr, code := runCommandWithCode(t, server, "", "image", "--batch", prompts, "--concurrency", "1", "--filename", "{{.ID}}-{{.Slug}}")
+
+	if code != int(KindUnknown) || !strings.Contains(stderr, "1 of 3 prompts failed") || !strings.Contains(stderr, "Batch finished: 2 done, 0 skipped, 1 failed, 0 left") {
+		t.Errorf("exit code %d, stderr %q", code, stderr)
+	}
+	if strings.Count(stdout, "Image URL: ") != 2 {
+		t.Errorf("stdout %q does not list the two images", stdout)
+	}
+
+	manifest, err := LoadManifest(manifestPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []ManifestEntry{
+		{ID: "1", Prompt: "a cat", Status: BatchDone, Files: []string{"1-a-cat.png"}},
+		{ID: "2", Prompt: "a dog", Status: BatchDone, Files: []string{"2-a-dog.png"}},
+		{ID: "3", Prompt: "something violent", Status: BatchFailed},
+	}
+	if len(manifest.Entries) != len(want) {
+		t.Fatalf("manifest has %d entries, want %d", len(manifest.Entries), len(want))
+	}
+	for i, entry := range manifest.Entries {
+		if entry.ID != want[i].ID || entry.Prompt != want[i].Prompt || entry.Status != want[i].Status || strings.Join(entry.Files, ",") != strings.Join(want[i].Files, ",") {
+			t.Errorf("entry %d is %+v, want %+v", i, entry, want[i])
+		}
+	}
+	if !strings.Contains(manifest.Entries[2].Error, "rejected as a result of our safety system") {
+		t.Errorf("failed entry has error %q", manifest.Entries[2].Error)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "prompts-images", "1-a-cat.json")); err != nil {
+		t.Error(err)
+	}
+
+	// Running again only retries the failed prompt
+	server = newFakeServer(t)
+	server.Queue(routeAzureImages, server.azureImages("/images/calm.png"))
+
+	_, stderr, code = runCommandWithCode(t, server, "", "image", "--batch", prompts, "--filename", "{{.ID}}-{{.Slug}}")
+
+	if code != 0 || !strings.Contains(stderr, "Creating images for 1 prompts (2 already done)") {
+		t.Errorf("exit code %d, stderr %q", code, stderr)
+	}
+	requests := server.Requests(routeAzureImages)
+	if len(requests) != 1 || requests[0]["prompt"] != "something violent" {
+		t.Errorf("got requests %v, want one for the failed prompt", requests)
+	}
+	if manifest, err = LoadManifest(manifestPath); err != nil {
+		t.Fatal(err)
+	}
+	if entry := manifest.Entries[2]; entry.Status != BatchDone || entry.Error != "" || len(entry.Files) != 1 {
+		t.Errorf("retried entry is %+v", entry)
+	}
+}
+
+func TestImageBatchInvalid(t *testing.T) {
+	dir := t.TempDir()
+	prompts := filepath.Join(dir, "prompts.jsonl")
+	if err := os.WriteFile(prompts, []byte(`{"prompt": "a cat", "size": "256x256"}`+"\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	text := filepath.Join(dir, "prompts.txt")
+	if err := os.WriteFile(text, []byte("a cat\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		args       []string
+		wantStderr string
+	}{
+		{name: "option not supported", args: []string{"--batch", prompts}, wantStderr: "prompts.jsonl:1: --size 256x256 is not supported by dall-e-3"},
+		{name: "prompt given too", args: []string{"--batch", text, "a dog"}, wantStderr: "--batch takes the prompts from the file"},
+		{name: "preview", args: []string{"--batch", text, "--preview=halfblock"}, wantStderr: "--preview cannot be used with --batch"},
+		{name: "concurrency", args: []string{"--batch", text, "--concurrency", "0"}, wantStderr: "--concurrency must be between 1 and 16"},
+		{name: "missing file", args: []string{"--batch", filepath.Join(dir, "missing.txt")}, wantStderr: "missing.txt: no such file"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := newFakeServer(t)
+			_, stderr, code := runCommandWithCode(t, server, "", append([]string{"image"}, test.args...)...)
+
+			if code != int(KindUsage) || !strings.Contains(stderr, test.wantStderr) {
+				t.Errorf("exit code %d, stderr %q does not contain %q", code, stderr, test.wantStderr)
+			}
+			if requests := server.Requests(routeAzureImages); len(requests) != 0 {
+				t.Errorf("got %d requests, want none", len(requests))
+			}
+		})
+	}
+}
+
+func TestImageBatchConcurrent(t *testing.T) {
+	dir := t.TempDir()
+	prompts := filepath.Join(dir, "prompts.txt")
+	if err := os.WriteFile(prompts, []byte("a cat\na dog\na fox\na owl\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	server := newFakeServer(t)
+	for i := 0; i < 4; i++ {
+		server.Queue(routeAzureImages, azureImagesB64(1))
+	}
+
+	stdout, stderr := runCommand(t, server, "", "image", "--batch", prompts, "--concurrency", "3", "--format", "b64", "--out-dir", filepath.Join(dir, "out"), "-o", "json")
+
+	if lines := strings.Count(stdout, "\n"); lines != 4 {
+		t.Errorf("got %d results, want 4:\n%s%s", lines, stdout, stderr)
+	}
+	manifest, err := LoadManifest(filepath.Join(dir, "out", "manifest.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range manifest.Entries {
+		if entry.Status != BatchDone || len(entry.Files) != 1 || entry.RevisedPrompts[0] != "a tabby cat wearing a top hat" {
+			t.Errorf("entry %+v is not done", entry)
+		}
+	}
+}
+
+func TestThrottleDelay(t *testing.T) {
+	throttled := func(header http.Header) error {
+		return &azcore.ResponseError{StatusCode: http.StatusTooManyRequests, RawResponse: &http.Response{StatusCode: http.StatusTooManyRequests, Header: header}}
+	}
+
+	tests := []struct {
+		name    string
+		err     error
+		min     time.Duration
+		max     time.Duration
+		attempt int
+	}{
+		{name: "retry-after-ms", err: throttled(http.Header{"Retry-After-Ms": {"1500"}}), min: 1500 * time.Millisecond, max: 1500 * time.Millisecond, attempt: 1},
+		{name: "retry-after beyond the retry cap", err: throttled(http.Header{"Retry-After": {"45"}}), min: 45 * time.Second, max: 45 * time.Second, attempt: 1},
+		{name: "backoff without a header", err: throttled(http.Header{}), min: DefaultRetryPolicy.BaseDelay, max: 2 * DefaultRetryPolicy.BaseDelay, attempt: 2},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := throttleDelay(test.err, test.attempt); got < test.min || got > test.max {
+				t.Errorf("delay %s, want between %s and %s", got, test.min, test.max)
+			}
+		})
+	}
+}
+
+func TestImageBatchThrottled(t *testing.T) {
+	dir := t.TempDir()
+	prompts := filepath.Join(dir, "prompts.txt")
+	if err := os.WriteFile(prompts, []byte("a cat\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	server := newFakeServer(t)
+	server.Env["MAX_ATTEMPTS"] = "1"
+	server.Queue(routeAzureImages, fakeResponse{Status: http.StatusTooManyRequests, Header: http.Header{"Retry-After-Ms": {"50"}}, Body: azureError("429", "Rate limit exceeded")})
+	server.Queue(routeAzureImages, server.azureImages("/images/cat.png"))
+
+	_, stderr, code := runCommandWithCode(t, server, "", "image", "--batch", prompts)
+
+	if code != 0 || !strings.Contains(stderr, "1 done, 0 skipped, 0 failed") {
+		t.Errorf("exit code %d, stderr %q", code, stderr)
+	}
+	if requests := server.Requests(routeAzureImages); len(requests) != 2 {
+		t.Errorf("got %d requests, want the throttled one and its retry", len(requests))
+	}
+}
+
+func TestImageBatchInterrupted(t *testing.T) {
+	dir := t.TempDir()
+	prompts := filepath.Join(dir, "prompts.txt")
+	if err := os.WriteFile(prompts, []byte("a cat\na dog\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	server := newFakeServer(t)
+	server.Queue(routeAzureImages, interrupted(fakeResponse{}))
+
+	_, stderr, code := runCommandWithCode(t, server, "", "image", "--batch", prompts, "--concurrency", "1")
+
+	if code != int(KindInterrupted) || !strings.Contains(stderr, "Error: interrupted with 2 of 2 prompts left") || !strings.Contains(stderr, "Hint: run the same command again to resume") {
+		t.Errorf("exit code %d, stderr %q", code, stderr)
+	}
+}
+
+func TestImageBatchPartlySaved(t *testing.T) {
+	dir := t.TempDir()
+	prompts := filepath.Join(dir, "prompts.txt")
+	if err := os.WriteFile(prompts, []byte("a cat\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	out := filepath.Join(dir, "out")
+
+	// The second image of the prompt gets a name that cannot be saved
+	server := newFakeServer(t)
+	server.Queue(routeAzureImages, server.azureImages("/images/cat.png", "/images/cat2.png"))
+
+	_, stderr, code := runCommandWithCode(t, server, "", "image", "--batch", prompts, "--out-dir", out, "--filename", "{{if eq .Index 2}}sub/{{end}}{{.Slug}}")
+
+	if code != int(KindUnknown) || !strings.Contains(stderr, "0 done, 0 skipped, 1 failed") {
+		t.Errorf("exit code %d, stderr %q", code, stderr)
+	}
+	files, err := os.ReadDir(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range files {
+		if file.Name() != "manifest.json" {
+			t.Errorf("%s was left behind by the failed prompt", file.Name())
+		}
+	}
+	manifest, err := LoadManifest(filepath.Join(out, "manifest.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry := manifest.Entries[0]; entry.Status != BatchFailed || len(entry.Files) != 0 || !strings.Contains(entry.Error, "without directories") {
+		t.Errorf("entry is %+v", entry)
+	}
+}
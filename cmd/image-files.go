@@ -0,0 +1,296 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"time"
+	"unicode"
+
+	"github.com/spf13/cobra"
+)
+
+// defaultImageFilename names downloaded images unless --filename or
+// IMAGE_FILENAME says otherwise
+const defaultImageFilename = "{{.Timestamp}}-{{.Slug}}-{{.Index}}"
+
+// maxSlugLength keeps filenames made from long prompts readable
+const maxSlugLength = 50
+
+// imageExtensions maps the image content types to the extension they are saved with
+var imageExtensions = map[string]string{
+	"image/png":  ".png",
+	"image/jpeg": ".jpg",
+	"image/gif":  ".gif",
+	"image/webp": ".webp",
+	"image/bmp":  ".bmp",
+}
+
+// ImageName is the data available to the --filename template
+type ImageName struct {
+	// ID is the entry's id in a --batch file, empty otherwise
+	ID        string
+	Prompt    string
+	Slug      string
+	Model     string
+	Timestamp string
+	Date      string
+	Index     int
+}
+
+// ImageMetadata is written as a JSON sidecar next to every downloaded image
+type ImageMetadata struct {
+	ID            string    `json:"id,omitempty"`
+	Prompt        string    `json:"prompt"`
+	RevisedPrompt string    `json:"revised_prompt,omitempty"`
+	Model         string    `json:"model"`
+	Size          string    `json:"size,omitempty"`
+	Quality       string    `json:"quality,omitempty"`
+	Style         string    `json:"style,omitempty"`
+	URL           string    `json:"url,omitempty"`
+	File          string    `json:"file"`
+	Created       time.Time `json:"created"`
+}
+
+// ImageSaver downloads generated images into a directory, naming them
+// from a template and writing their metadata alongside
+type ImageSaver struct {
+	Dir      string
+	Filename *template.Template
+}
+
+// GetImageSaver builds an ImageSaver from the --out-dir and --filename flags,
+// falling back to the IMAGE_DIR and IMAGE_FILENAME settings
+func GetImageSaver(cmd *cobra.Command) (*ImageSaver, error) {
+	dir, _ := cmd.Flags().GetString("out-dir")
+	if dir == "" {
+		dir = os.Getenv("IMAGE_DIR")
+	}
+	if dir == "" {
+		dir = os.TempDir()
+	}
+
+	name, _ := cmd.Flags().GetString("filename")
+	if name == "" {
+		name = os.Getenv("IMAGE_FILENAME")
+	}
+	if name == "" {
+		name = defaultImageFilename
+	}
+	filename, err := template.New("filename").Option("missingkey=error").Parse(name)
+	if err != nil {
+		return nil, &Error{Kind: KindUsage, Err: fmt.Errorf("invalid --filename template: %w", err), Hint: "use fields like {{.Slug}}, {{.Timestamp}}, {{.Date}}, {{.Model}}, {{.ID}} and {{.Index}}"}
+	}
+	// Render a sample now so a broken template fails before the image is paid for
+	saver := &ImageSaver{Dir: dir, Filename: filename}
+	if _, err := saver.name(ImageName{ID: "1", Prompt: "sample", Slug: "sample", Index: 1}); err != nil {
+		return nil, err
+	}
+	return saver, nil
+}
+
+// wantsDownload reports whether the generated images should be saved locally,
+// which any of --download, --out-dir or --filename asks for
+func wantsDownload(cmd *cobra.Command) bool {
+	for _, name := range []string{"download", "out-dir", "filename"} {
+		if flag := cmd.Flags().Lookup(name); flag != nil && flag.Changed {
+			return true
+		}
+	}
+	return false
+}
+
+// name renders the filename template, without the extension
+func (s *ImageSaver) name(data ImageName) (string, error) {
+	var name strings.Builder
+	if err := s.Filename.Execute(&name, data); err != nil {
+		return "", &Error{Kind: KindUsage, Err: fmt.Errorf("invalid --filename template: %w", err)}
+	}
+	result := strings.TrimSpace(name.String())
+	if result == "" || result == "." || result == ".." || strings.ContainsAny(result, `/\`) {
+		return "", Errorf(KindUsage, "--filename must give a file name without directories, got %q", result)
+	}
+	return result, nil
+}
+
+// fetchImage downloads the image at url, returning its data and content type
+func fetchImage(ctx context.Context, url string) ([]byte, string, error) {
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	response, err := httpClient.Do(request)
+	if err != nil {
+		return nil, "", err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return nil, "", fmt.Errorf("downloading image: %s returned %d", url, response.StatusCode)
+	}
+	data, err := io.ReadAll(response.Body)
+	if err != nil {
+		return nil, "", fmt.Errorf("downloading image: %w", err)
+	}
+	return data, response.Header.Get("Content-Type"), nil
+}
+
+// Write stores image data under a name made from the template and the
+// detected extension, and writes the metadata sidecar next to it
+func (s *ImageSaver) Write(data []byte, contentType string, meta ImageMetadata, index int) (string, error) {
+	base, err := s.name(ImageName{
+		ID:        meta.ID,
+		Prompt:    meta.Prompt,
+		Slug:      slugify(meta.Prompt),
+		Model:     meta.Model,
+		Timestamp: meta.Created.Format("20060102-150405"),
+		Date:      meta.Created.Format(time.DateOnly),
+		Index:     index,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	if err := os.MkdirAll(s.Dir, 0o755); err != nil {
+		return "", fmt.Errorf("creating %s: %w", s.Dir, err)
+	}
+	file, sidecarFile, path, err := createUnique(filepath.Join(s.Dir, base), imageExtension(contentType, data))
+	if err != nil {
+		return "", err
+	}
+	if err := writeImageFiles(file, sidecarFile, path, data, meta); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+// writeImageFiles fills in the image and sidecar files created for path. A
+// truncated image or an empty sidecar is worse than none, so both files are
+// removed when anything fails.
+func writeImageFiles(file *os.File, sidecarFile *os.File, path string, data []byte, meta ImageMetadata) (err error) {
+	defer func() {
+		if err != nil {
+			file.Close()
+			sidecarFile.Close()
+			removeImage(path)
+		}
+	}()
+
+	if _, err := file.Write(data); err != nil {
+		return fmt.Errorf("writing image: %w", err)
+	}
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("writing image: %w", err)
+	}
+
+	if config, _, err := image.DecodeConfig(bytes.NewReader(data)); err == nil {
+		meta.Size = fmt.Sprintf("%dx%d", config.Width, config.Height)
+	}
+	meta.File = filepath.Base(path)
+	sidecar, err := json.MarshalIndent(meta, "", "  ")
+	if err != nil {
+		return err
+	}
+	if _, err := sidecarFile.Write(append(sidecar, '\n')); err != nil {
+		return fmt.Errorf("writing image metadata: %w", err)
+	}
+	if err := sidecarFile.Close(); err != nil {
+		return fmt.Errorf("writing image metadata: %w", err)
+	}
+	return nil
+}
+
+// createUnique creates base+ext and its base+".json" sidecar, adding a
+// counter to the name until both are free so no existing file is ever
+// overwritten. It returns the image file, the sidecar file and the image path.
+func createUnique(base string, ext string) (*os.File, *os.File, string, error) {
+	for i := 1; ; i++ {
+		name := base
+		if i > 1 {
+			name = fmt.Sprintf("%s-%d", base, i)
+		}
+		file, err := os.OpenFile(name+ext, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+		if errors.Is(err, os.ErrExist) {
+			continue
+		}
+		if err != nil {
+			return nil, nil, "", fmt.Errorf("creating file: %w", err)
+		}
+
+		sidecar, err := os.OpenFile(name+".json", os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+		if err != nil {
+			// Give the image name back before trying the next one
+			file.Close()
+			os.Remove(name + ext)
+			if errors.Is(err, os.ErrExist) {
+				continue
+			}
+			return nil, nil, "", fmt.Errorf("creating file: %w", err)
+		}
+		return file, sidecar, name + ext, nil
+	}
+}
+
+// removeImage deletes a saved image and its metadata sidecar
+func removeImage(path string) {
+	os.Remove(path)
+	os.Remove(strings.TrimSuffix(path, filepath.Ext(path)) + ".json")
+}
+
+// imageExtension picks the file extension from the Content-Type header,
+// looking at the data itself when the header is missing or generic
+func imageExtension(contentType string, data []byte) string {
+	if mediaType, _, err := mime.ParseMediaType(contentType); err == nil {
+		if ext, ok := imageExtensions[mediaType]; ok {
+			return ext
+		}
+	}
+	if ext, ok := imageExtensions[http.DetectContentType(data)]; ok {
+		return ext
+	}
+	return ".bin"
+}
+
+// slugify turns a prompt into a short lowercase name made of letters,
+// digits and hyphens
+func slugify(text string) string {
+	var slug strings.Builder
+	hyphen := false
+	for _, r := range strings.ToLower(text) {
+		switch {
+		case r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r)):
+			if hyphen && slug.Len() > 0 {
+				slug.WriteByte('-')
+			}
+			slug.WriteRune(r)
+			hyphen = false
+		default:
+			hyphen = true
+		}
+	}
+
+	result := slug.String()
+	if len(result) > maxSlugLength {
+		result = result[:maxSlugLength]
+		// Cut at the last whole word when there is one
+		if i := strings.LastIndexByte(result, '-'); i > 0 {
+			result = result[:i]
+		}
+	}
+	if result == "" {
+		return "image"
+	}
+	return result
+}
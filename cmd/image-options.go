@@ -0,0 +1,150 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/ai/azopenai"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/spf13/cobra"
+)
+
+// ImageModel describes what a DALL·E model accepts
+type ImageModel struct {
+	Name      string
+	Sizes     []string
+	Qualities []string
+	Styles    []string
+	MaxCount  int32
+}
+
+// imageModels are the DALL·E models Azure OpenAI can deploy
+var imageModels = []ImageModel{
+	{
+		Name:     "dall-e-2",
+		Sizes:    []string{"256x256", "512x512", "1024x1024"},
+		MaxCount: 10,
+	},
+	{
+		Name:      "dall-e-3",
+		Sizes:     []string{"1024x1024", "1792x1024", "1024x1792"},
+		Qualities: []string{"standard", "hd"},
+		Styles:    []string{"vivid", "natural"},
+		MaxCount:  1,
+	},
+}
+
+// LookupImageModel finds the model behind a DALL·E deployment, named by the
+// DALLE_MODEL setting or guessed from the deployment name. Deployments that
+// give no clue are taken to run dall-e-3, the only model Azure still offers.
+func LookupImageModel(deployment string) (ImageModel, error) {
+	name := os.Getenv("DALLE_MODEL")
+	if name == "" {
+		name = deployment
+	}
+	normalized := strings.NewReplacer("-", "", "_", "", ".", "", " ", "").Replace(strings.ToLower(name))
+
+	for _, model := range imageModels {
+		if strings.Contains(normalized, strings.ReplaceAll(model.Name, "-", "")) {
+			return model, nil
+		}
+	}
+	if os.Getenv("DALLE_MODEL") != "" {
+		return ImageModel{}, Errorf(KindUsage, "unknown DALL·E model %q (available: %s)", name, strings.Join(imageModelNames(), ", "))
+	}
+	return imageModels[len(imageModels)-1], nil
+}
+
+// imageModelNames lists the known DALL·E models
+func imageModelNames() []string {
+	names := make([]string, len(imageModels))
+	for i, model := range imageModels {
+		names[i] = model.Name
+	}
+	return names
+}
+
+// addImageFlags adds the image generation options to a command
+func addImageFlags(cmd *cobra.Command) {
+	cmd.Flags().String("size", "", "image size, 256x256, 512x512 or 1024x1024 for dall-e-2 and 1024x1024, 1792x1024 or 1024x1792 for dall-e-3 (default $IMAGE_SIZE or the model's default)")
+	cmd.Flags().String("quality", "", "image quality, standard or hd, dall-e-3 only (default $IMAGE_QUALITY)")
+	cmd.Flags().String("style", "", "image style, vivid or natural, dall-e-3 only (default $IMAGE_STYLE)")
+	cmd.Flags().Int32("count", 0, "number of images to create, up to 10 with dall-e-2 and 1 with dall-e-3 (default $IMAGE_COUNT or 1)")
+}
+
+// GetImageOptions fills in the size, quality, style and count from the flags
+// or their settings, checking each against what the deployment's model supports
+func GetImageOptions(cmd *cobra.Command, model ImageModel, opts *azopenai.ImageGenerationOptions) error {
+	size, err := imageSetting(cmd, "size", "IMAGE_SIZE", model, model.Sizes)
+	if err != nil {
+		return err
+	}
+	if size != "" {
+		opts.Size = to.Ptr(azopenai.ImageSize(size))
+	}
+
+	quality, err := imageSetting(cmd, "quality", "IMAGE_QUALITY", model, model.Qualities)
+	if err != nil {
+		return err
+	}
+	if quality != "" {
+		opts.Quality = to.Ptr(azopenai.ImageGenerationQuality(quality))
+	}
+
+	style, err := imageSetting(cmd, "style", "IMAGE_STYLE", model, model.Styles)
+	if err != nil {
+		return err
+	}
+	if style != "" {
+		opts.Style = to.Ptr(azopenai.ImageGenerationStyle(style))
+	}
+
+	count, _ := cmd.Flags().GetInt32("count")
+	if !cmd.Flags().Changed("count") && os.Getenv("IMAGE_COUNT") != "" {
+		value, err := strconv.ParseInt(os.Getenv("IMAGE_COUNT"), 10, 32)
+		if err != nil {
+			return Errorf(KindUsage, "invalid IMAGE_COUNT %q: must be a whole number", os.Getenv("IMAGE_COUNT"))
+		}
+		count = int32(value)
+	}
+	if cmd.Flags().Changed("count") || count != 0 {
+		if count < 1 || count > model.MaxCount {
+			return &Error{Kind: KindUsage, Err: fmt.Errorf("--count must be between 1 and %d for %s, got %d", model.MaxCount, model.Name, count), Hint: imageModelHint}
+		}
+		opts.N = to.Ptr(count)
+	}
+	return nil
+}
+
+// imageModelHint points at the setting to use when the model was guessed wrong
+const imageModelHint = "set DALLE_MODEL to dall-e-2 or dall-e-3 if the deployment runs a different model"
+
+// imageSetting reads a string flag, falling back to its setting, and checks
+// the value is one the model allows
+func imageSetting(cmd *cobra.Command, flag string, setting string, model ImageModel, allowed []string) (string, error) {
+	value, _ := cmd.Flags().GetString(flag)
+	if value == "" {
+		value = os.Getenv(setting)
+	}
+	return checkImageOption(flag, value, model, allowed)
+}
+
+// checkImageOption normalizes the value of an image option and checks it is
+// one the model allows, an empty value leaving the model's default
+func checkImageOption(flag string, value string, model ImageModel, allowed []string) (string, error) {
+	value = strings.ToLower(strings.TrimSpace(value))
+	if value == "" {
+		return "", nil
+	}
+
+	if len(allowed) == 0 {
+		return "", &Error{Kind: KindUsage, Err: fmt.Errorf("%s does not support --%s", model.Name, flag), Hint: imageModelHint}
+	}
+	if !slices.Contains(allowed, value) {
+		return "", &Error{Kind: KindUsage, Err: fmt.Errorf("--%s %s is not supported by %s (available: %s)", flag, value, model.Name, strings.Join(allowed, ", ")), Hint: imageModelHint}
+	}
+	return value, nil
+}
@@ -0,0 +1,331 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"image"
+	"image/png"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"golang.org/x/term"
+)
+
+// Protocols accepted by the --preview flag to show images in the terminal
+const (
+	PreviewAuto      = "auto"
+	PreviewKitty     = "kitty"
+	PreviewITerm2    = "iterm2"
+	PreviewSixel     = "sixel"
+	PreviewHalfBlock = "halfblock"
+)
+
+// Limits on the size of a preview, in terminal cells
+const (
+	maxPreviewColumns = 80
+	maxPreviewRows    = 40
+)
+
+// Approximate size of a terminal cell in pixels, used to size sixel images
+const (
+	cellWidth  = 10
+	cellHeight = 20
+)
+
+// kittyChunkSize is the largest piece of base64 data the Kitty graphics
+// protocol accepts in a single escape sequence
+const kittyChunkSize = 4096
+
+// addPreviewFlag adds --preview, which takes an optional protocol name
+func addPreviewFlag(cmd *cobra.Command) {
+	cmd.Flags().String("preview", "", "show the image in the terminal, with auto, kitty, iterm2, sixel or halfblock (default $IMAGE_PREVIEW, auto when given without a value)")
+	cmd.Flags().Lookup("preview").NoOptDefVal = PreviewAuto
+}
+
+// GetPreviewProtocol returns how images should be previewed, or "" when they
+// should not be. auto picks a protocol the terminal understands and turns
+// the preview off when stdout is not a terminal.
+func GetPreviewProtocol(cmd *cobra.Command) (string, error) {
+	protocol, _ := cmd.Flags().GetString("preview")
+	if !cmd.Flags().Changed("preview") {
+		protocol = os.Getenv("IMAGE_PREVIEW")
+	}
+
+	switch protocol {
+	case "", "off", "none":
+		return "", nil
+	case PreviewAuto, PreviewKitty, PreviewITerm2, PreviewSixel, PreviewHalfBlock:
+	default:
+		return "", Errorf(KindUsage, "unknown preview protocol %q (available: %s, %s, %s, %s, %s)", protocol, PreviewAuto, PreviewKitty, PreviewITerm2, PreviewSixel, PreviewHalfBlock)
+	}
+
+	if GetOutputFormat(cmd) != OutputText {
+		return "", Errorf(KindUsage, "--preview only works with the text output, not %s", GetOutputFormat(cmd))
+	}
+	if protocol != PreviewAuto {
+		return protocol, nil
+	}
+	if !isTerminal(cmd.OutOrStdout()) {
+		if cmd.Flags().Changed("preview") {
+			log.Println("Warning: not previewing the image, stdout is not a terminal")
+		}
+		return "", nil
+	}
+	return detectPreviewProtocol(), nil
+}
+
+// detectPreviewProtocol guesses the best image protocol of the terminal from
+// the variables terminals set. tmux does not pass graphics through by
+// default, so inside it the half-block fallback is used.
+func detectPreviewProtocol() string {
+	termName := os.Getenv("TERM")
+	program := os.Getenv("TERM_PROGRAM")
+
+	switch {
+	case os.Getenv("TMUX") != "" || strings.HasPrefix(termName, "screen"):
+		return PreviewHalfBlock
+	case os.Getenv("KITTY_WINDOW_ID") != "" || termName == "xterm-kitty" || termName == "xterm-ghostty" || program == "ghostty":
+		return PreviewKitty
+	case program == "iTerm.app" || program == "WezTerm" || os.Getenv("LC_TERMINAL") == "iTerm2":
+		return PreviewITerm2
+	case strings.Contains(termName, "sixel") || termName == "foot" || termName == "mlterm" || program == "mlterm" || termName == "contour":
+		return PreviewSixel
+	}
+	return PreviewHalfBlock
+}
+
+// PreviewImage draws an image in the terminal w writes to, using protocol
+func PreviewImage(w io.Writer, data []byte, protocol string) error {
+	columns, rows := previewSize(w)
+
+	switch protocol {
+	case PreviewKitty:
+		return writeKitty(w, data, columns)
+	case PreviewITerm2:
+		return writeITerm2(w, data, columns)
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("decoding %s: %w", http.DetectContentType(data), err)
+	}
+	if protocol == PreviewSixel {
+		return writeSixel(w, img, columns*cellWidth, rows*cellHeight)
+	}
+	trueColor := os.Getenv("COLORTERM") == "truecolor" || os.Getenv("COLORTERM") == "24bit"
+	return writeHalfBlocks(w, img, columns, rows, trueColor)
+}
+
+// previewSize returns how many columns and rows a preview may take up
+func previewSize(w io.Writer) (int, int) {
+	columns := terminalWidth(w)
+	if columns <= 0 || columns > maxPreviewColumns {
+		columns = maxPreviewColumns
+	}
+
+	rows := maxPreviewRows
+	if file, ok := w.(*os.File); ok && term.IsTerminal(int(file.Fd())) {
+		if _, height, err := term.GetSize(int(file.Fd())); err == nil && height > 2 && height-2 < rows {
+			rows = height - 2
+		}
+	} else if height, err := strconv.Atoi(os.Getenv("LINES")); err == nil && height > 2 && height-2 < rows {
+		rows = height - 2
+	}
+	return columns, rows
+}
+
+// writeKitty sends the image with the Kitty graphics protocol, which only
+// takes PNG, in chunks no larger than the protocol allows
+func writeKitty(w io.Writer, data []byte, columns int) error {
+	if http.DetectContentType(data) != "image/png" {
+		img, _, err := image.Decode(bytes.NewReader(data))
+		if err != nil {
+			return fmt.Errorf("decoding %s: %w", http.DetectContentType(data), err)
+		}
+		var converted bytes.Buffer
+		if err := png.Encode(&converted, img); err != nil {
+			return err
+		}
+		data = converted.Bytes()
+	}
+
+	encoded := base64.StdEncoding.EncodeToString(data)
+	for start := 0; start < len(encoded); start += kittyChunkSize {
+		end := min(start+kittyChunkSize, len(encoded))
+		more := 0
+		if end < len(encoded) {
+			more = 1
+		}
+		control := fmt.Sprintf("m=%d", more)
+		if start == 0 {
+			control = fmt.Sprintf("a=T,f=100,c=%d,%s", columns, control)
+		}
+		if _, err := fmt.Fprintf(w, "\x1b_G%s;%s\x1b\\", control, encoded[start:end]); err != nil {
+			return err
+		}
+	}
+	_, err := fmt.Fprintln(w)
+	return err
+}
+
+// writeITerm2 sends the image with the iTerm2 inline images protocol, which
+// takes the file as it is
+func writeITerm2(w io.Writer, data []byte, columns int) error {
+	_, err := fmt.Fprintf(w, "\x1b]1337;File=inline=1;size=%d;width=%d;preserveAspectRatio=1:%s\a\n", len(data), columns, base64.StdEncoding.EncodeToString(data))
+	return err
+}
+
+// writeSixel draws the image as sixels, scaled to fit within the given
+// pixels and reduced to a palette of 6×6×6 colors
+func writeSixel(w io.Writer, img image.Image, maxWidth int, maxHeight int) error {
+	width, height := fitSize(img.Bounds(), maxWidth, maxHeight)
+	pixels := make([]int, width*height)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			r, g, b := averageColor(img, x, y, width, height)
+			pixels[y*width+x] = colorCubeIndex(r, g, b)
+		}
+	}
+
+	var out strings.Builder
+	fmt.Fprintf(&out, "\x1bPq\"1;1;%d;%d", width, height)
+	for i := 0; i < 216; i++ {
+		fmt.Fprintf(&out, "#%d;2;%d;%d;%d", i, i/36*20, i/6%6*20, i%6*20)
+	}
+
+	// Each band is six pixel rows, drawn once per color it uses
+	for band := 0; band < height; band += 6 {
+		var colors []int
+		seen := map[int]bool{}
+		for y := band; y < min(band+6, height); y++ {
+			for x := 0; x < width; x++ {
+				if color := pixels[y*width+x]; !seen[color] {
+					seen[color] = true
+					colors = append(colors, color)
+				}
+			}
+		}
+
+		for i, color := range colors {
+			if i > 0 {
+				out.WriteByte('$')
+			}
+			fmt.Fprintf(&out, "#%d", color)
+			run, last := 0, byte(0)
+			for x := 0; x <= width; x++ {
+				var sixel byte
+				if x < width {
+					bits := 0
+					for k := 0; k < 6 && band+k < height; k++ {
+						if pixels[(band+k)*width+x] == color {
+							bits |= 1 << k
+						}
+					}
+					sixel = byte(63 + bits)
+				}
+				if x > 0 && (sixel != last || x == width) {
+					writeSixelRun(&out, last, run)
+					run = 0
+				}
+				run++
+				last = sixel
+			}
+		}
+		out.WriteByte('-')
+	}
+	out.WriteString("\x1b\\\n")
+
+	_, err := io.WriteString(w, out.String())
+	return err
+}
+
+// writeSixelRun writes a sixel repeated count times, run length encoded
+// when that is shorter
+func writeSixelRun(out *strings.Builder, sixel byte, count int) {
+	if count > 3 {
+		fmt.Fprintf(out, "!%d%c", count, sixel)
+		return
+	}
+	out.WriteString(strings.Repeat(string(sixel), count))
+}
+
+// writeHalfBlocks draws the image with the upper half block character, one
+// pixel in the foreground and the one below it in the background of each
+// cell, so it works in any terminal with color
+func writeHalfBlocks(w io.Writer, img image.Image, columns int, rows int, trueColor bool) error {
+	width, height := fitSize(img.Bounds(), columns, rows*2)
+
+	var out strings.Builder
+	for y := 0; y < height; y += 2 {
+		for x := 0; x < width; x++ {
+			r, g, b := averageColor(img, x, y, width, height)
+			out.WriteString(ansiColor(38, r, g, b, trueColor))
+			if y+1 < height {
+				r, g, b = averageColor(img, x, y+1, width, height)
+				out.WriteString(ansiColor(48, r, g, b, trueColor))
+			} else {
+				out.WriteString("\x1b[49m")
+			}
+			out.WriteString("▀")
+		}
+		out.WriteString("\x1b[0m\n")
+	}
+
+	_, err := io.WriteString(w, out.String())
+	return err
+}
+
+// ansiColor returns the escape sequence setting the foreground (38) or
+// background (48) color, falling back to the 256 color palette
+func ansiColor(layer int, r uint8, g uint8, b uint8, trueColor bool) string {
+	if trueColor {
+		return fmt.Sprintf("\x1b[%d;2;%d;%d;%dm", layer, r, g, b)
+	}
+	return fmt.Sprintf("\x1b[%d;5;%dm", layer, 16+colorCubeIndex(r, g, b))
+}
+
+// colorCubeIndex maps a color to the nearest of the 6×6×6 color cube
+func colorCubeIndex(r uint8, g uint8, b uint8) int {
+	level := func(v uint8) int { return (int(v)*5 + 127) / 255 }
+	return level(r)*36 + level(g)*6 + level(b)
+}
+
+// fitSize scales bounds down to fit within maxWidth by maxHeight, keeping
+// the aspect ratio and never scaling up
+func fitSize(bounds image.Rectangle, maxWidth int, maxHeight int) (int, int) {
+	width, height := bounds.Dx(), bounds.Dy()
+	if width > maxWidth {
+		height = height * maxWidth / width
+		width = maxWidth
+	}
+	if height > maxHeight {
+		width = width * maxHeight / height
+		height = maxHeight
+	}
+	return max(width, 1), max(height, 1)
+}
+
+// averageColor returns the average color of the area of img that pixel x, y
+// covers when img is scaled to width by height
+func averageColor(img image.Image, x int, y int, width int, height int) (uint8, uint8, uint8) {
+	bounds := img.Bounds()
+	x0 := bounds.Min.X + x*bounds.Dx()/width
+	x1 := max(bounds.Min.X+(x+1)*bounds.Dx()/width, x0+1)
+	y0 := bounds.Min.Y + y*bounds.Dy()/height
+	y1 := max(bounds.Min.Y+(y+1)*bounds.Dy()/height, y0+1)
+
+	var r, g, b, n uint64
+	for py := y0; py < y1; py++ {
+		for px := x0; px < x1; px++ {
+			pr, pg, pb, _ := img.At(px, py).RGBA()
+			r, g, b, n = r+uint64(pr), g+uint64(pg), b+uint64(pb), n+1
+		}
+	}
+	return uint8(r / n >> 8), uint8(g / n >> 8), uint8(b / n >> 8)
+}
@@ -0,0 +1,118 @@
+package cmd
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"strings"
+	"testing"
+)
+
+func TestDetectPreviewProtocol(t *testing.T) {
+	tests := []struct {
+		name string
+		env  map[string]string
+		want string
+	}{
+		{name: "kitty", env: map[string]string{"TERM": "xterm-kitty"}, want: PreviewKitty},
+		{name: "ghostty", env: map[string]string{"TERM_PROGRAM": "ghostty"}, want: PreviewKitty},
+		{name: "iterm2", env: map[string]string{"TERM_PROGRAM": "iTerm.app"}, want: PreviewITerm2},
+		{name: "wezterm", env: map[string]string{"TERM_PROGRAM": "WezTerm"}, want: PreviewITerm2},
+		{name: "foot", env: map[string]string{"TERM": "foot"}, want: PreviewSixel},
+		{name: "tmux", env: map[string]string{"TMUX": "/tmp/tmux-1000/default,1,0", "KITTY_WINDOW_ID": "1"}, want: PreviewHalfBlock},
+		{name: "anything else", env: map[string]string{"TERM": "xterm-256color"}, want: PreviewHalfBlock},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			for _, name := range []string{"TERM", "TERM_PROGRAM", "LC_TERMINAL", "KITTY_WINDOW_ID", "TMUX"} {
+				t.Setenv(name, test.env[name])
+			}
+			if got := detectPreviewProtocol(); got != test.want {
+				t.Errorf("detected %s, want %s", got, test.want)
+			}
+		})
+	}
+}
+
+func TestPreviewImage(t *testing.T) {
+	// A red over blue JPEG, which Kitty needs converted to PNG
+	img := image.NewRGBA(image.Rect(0, 0, 4, 4))
+	for y := 0; y < 4; y++ {
+		for x := 0; x < 4; x++ {
+			img.Set(x, y, color.RGBA{R: 255, A: 255})
+			if y >= 2 {
+				img.Set(x, y, color.RGBA{B: 255, A: 255})
+			}
+		}
+	}
+	var photo bytes.Buffer
+	if err := jpeg.Encode(&photo, img, &jpeg.Options{Quality: 100}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		protocol string
+		data     []byte
+		want     []string
+	}{
+		{protocol: PreviewKitty, data: fakePNG, want: []string{"\x1b_Ga=T,f=100,c=80,m=0;iVBORw0KGgo", "\x1b\\"}},
+		{protocol: PreviewKitty, data: photo.Bytes(), want: []string{"\x1b_Ga=T,f=100,c=80,m=0;iVBORw0KGgo"}},
+		{protocol: PreviewITerm2, data: photo.Bytes(), want: []string{"\x1b]1337;File=inline=1;size=", ";width=80;preserveAspectRatio=1:/9j/", "\a"}},
+		{protocol: PreviewSixel, data: photo.Bytes(), want: []string{"\x1bPq\"1;1;4;4", "#180;2;100;0;0", "#5;2;0;0;100", "#180!4B$#5!4K-", "\x1b\\"}},
+		{protocol: PreviewHalfBlock, data: photo.Bytes(), want: []string{"\x1b[38;5;196m\x1b[48;5;196m▀", "\x1b[38;5;21m\x1b[48;5;21m▀", "\x1b[0m\n"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.protocol, func(t *testing.T) {
+			t.Setenv("COLUMNS", "")
+			t.Setenv("COLORTERM", "")
+			var out bytes.Buffer
+			if err := PreviewImage(&out, test.data, test.protocol); err != nil {
+				t.Fatal(err)
+			}
+			for _, want := range test.want {
+				if !strings.Contains(out.String(), want) {
+					t.Errorf("preview %q does not contain %q", out.String(), want)
+				}
+			}
+		})
+	}
+}
+
+func TestImagePreview(t *testing.T) {
+	tests := []struct {
+		name       string
+		args       []string
+		wantStdout string
+		wantStderr string
+		wantCode   int
+	}{
+		{name: "half blocks", args: []string{"--preview=halfblock"}, wantStdout: "▀"},
+		{name: "auto off a terminal", args: []string{"--preview"}, wantStderr: "not previewing the image, stdout is not a terminal"},
+		{name: "json output", args: []string{"--preview", "-o", "json"}, wantStderr: "--preview only works with the text output", wantCode: int(KindUsage)},
+		{name: "unknown protocol", args: []string{"--preview=ascii"}, wantStderr: `unknown preview protocol "ascii"`, wantCode: int(KindUsage)},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := newFakeServer(t)
+			if test.wantCode == 0 {
+				server.Queue(routeAzureImages, server.azureImages("/images/cat.png"))
+			}
+
+			stdout, stderr, code := runCommandWithCode(t, server, "", append(append([]string{"image"}, test.args...), "a cat")...)
+
+			if code != test.wantCode {
+				t.Errorf("exit code %d, want %d: %s", code, test.wantCode, stderr)
+			}
+			if !strings.Contains(stdout, test.wantStdout) {
+				t.Errorf("stdout %q does not contain %q: %s", stdout, test.wantStdout, stderr)
+			}
+			if !strings.Contains(stderr, test.wantStderr) {
+				t.Errorf("stderr %q does not contain %q", stderr, test.wantStderr)
+			}
+		})
+	}
+}
@@ -0,0 +1,149 @@
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// OllamaModel is a model installed on the local Ollama server
+type OllamaModel struct {
+	Name       string    `json:"name"`
+	Size       int64     `json:"size"`
+	ModifiedAt time.Time `json:"modified_at"`
+}
+
+// OllamaBaseURL returns the address of the Ollama server, taken from
+// OLLAMA_HOST like the ollama CLI does
+func OllamaBaseURL() string {
+	host := os.Getenv("OLLAMA_HOST")
+	if host == "" {
+		return "http://127.0.0.1:11434"
+	}
+	if !strings.Contains(host, "://") {
+		host = "http://" + host
+	}
+	return strings.TrimSuffix(host, "/")
+}
+
+// ListOllamaModels asks the Ollama server which models are installed
+func ListOllamaModels(ctx context.Context) ([]OllamaModel, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, OllamaBaseURL()+"/api/tags", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := retryingHTTPClient().Do(req)
+	if err != nil {
+		return nil, &Error{Kind: KindNetwork, Err: fmt.Errorf("could not reach Ollama at %s: %w", OllamaBaseURL(), err), Hint: "check that Ollama is running, or set OLLAMA_HOST"}
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("listing Ollama models returned %s", resp.Status)
+	}
+
+	var tags struct {
+		Models []OllamaModel `json:"models"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&tags); err != nil {
+		return nil, err
+	}
+	return tags.Models, nil
+}
+
+// PullOllamaModel downloads a model into the local Ollama server, drawing a
+// progress bar on w while the layers download
+func PullOllamaModel(ctx context.Context, w io.Writer, name string) error {
+	body, err := json.Marshal(map[string]any{"name": name, "stream": true})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, OllamaBaseURL()+"/api/pull", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := retryingHTTPClient().Do(req)
+	if err != nil {
+		return &Error{Kind: KindNetwork, Err: fmt.Errorf("could not reach Ollama at %s: %w", OllamaBaseURL(), err), Hint: "check that Ollama is running, or set OLLAMA_HOST"}
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		message, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("pulling %s returned %s: %s", name, resp.Status, strings.TrimSpace(string(message)))
+	}
+
+	// The server streams one JSON object per line while it works
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		var progress struct {
+			Status    string `json:"status"`
+			Total     int64  `json:"total"`
+			Completed int64  `json:"completed"`
+			Error     string `json:"error"`
+		}
+		if err := json.Unmarshal(scanner.Bytes(), &progress); err != nil {
+			continue
+		}
+		if progress.Error != "" {
+			fmt.Fprintln(w)
+			return fmt.Errorf("pulling %s: %s", name, progress.Error)
+		}
+
+		if progress.Total > 0 {
+			fmt.Fprintf(w, "\r%s %s", progressBar(progress.Completed, progress.Total, 30), FormatSize(progress.Total))
+		} else {
+			fmt.Fprintf(w, "\r%-60s", progress.Status)
+		}
+	}
+	fmt.Fprintln(w)
+	return scanner.Err()
+}
+
+// progressBar renders completed out of total as a fixed width bar with a percentage
+func progressBar(completed int64, total int64, width int) string {
+	if completed > total {
+		completed = total
+	}
+	filled := int(int64(width) * completed / total)
+	return fmt.Sprintf("[%s%s] %3d%%", strings.Repeat("=", filled), strings.Repeat(" ", width-filled), 100*completed/total)
+}
+
+// FormatSize formats a byte count in human readable units
+func FormatSize(bytes int64) string {
+	const unit = 1000
+	if bytes < unit {
+		return fmt.Sprintf("%d B", bytes)
+	}
+	div, exp := int64(unit), 0
+	for n := bytes / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(bytes)/float64(div), "kMGTPE"[exp])
+}
+
+// hasOllamaModel reports whether name is installed, treating a name without
+// a tag as the ":latest" tag
+func hasOllamaModel(models []OllamaModel, name string) bool {
+	if !strings.Contains(name, ":") {
+		name += ":latest"
+	}
+	for _, model := range models {
+		if model.Name == name {
+			return true
+		}
+	}
+	return false
+}
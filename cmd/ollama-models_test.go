@@ -0,0 +1,151 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestListOllamaModels(t *testing.T) {
+	server := newFakeServer(t)
+	t.Setenv("OLLAMA_HOST", server.URL)
+
+	models, err := ListOllamaModels(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := OllamaModel{Name: "llama3:latest", Size: 4661224676, ModifiedAt: time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)}
+	if len(models) != 1 || models[0].Name != want.Name || models[0].Size != want.Size || !models[0].ModifiedAt.Equal(want.ModifiedAt) {
+		t.Errorf("got %+v, want %+v", models, want)
+	}
+}
+
+func TestHasOllamaModel(t *testing.T) {
+	models := []OllamaModel{{Name: "llama3:latest"}, {Name: "mistral:7b"}}
+
+	tests := []struct {
+		name  string
+		model string
+		want  bool
+	}{
+		{name: "exact name", model: "llama3:latest", want: true},
+		{name: "latest tag implied", model: "llama3", want: true},
+		{name: "other tag", model: "mistral:7b", want: true},
+		{name: "latest of a model installed with another tag", model: "mistral", want: false},
+		{name: "not installed", model: "phi3", want: false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := hasOllamaModel(models, test.model); got != test.want {
+				t.Errorf("hasOllamaModel(%q) = %v, want %v", test.model, got, test.want)
+			}
+		})
+	}
+}
+
+func TestPullOllamaModel(t *testing.T) {
+	tests := []struct {
+		name      string
+		responses []fakeResponse
+		want      []string
+		wantErr   string
+	}{
+		{
+			name: "progress drawn while the layers download",
+			responses: []fakeResponse{{Events: []any{
+				map[string]any{"status": "pulling manifest"},
+				map[string]any{"status": "pulling 6a0746a1ec1a", "total": 4000, "completed": 1000},
+				map[string]any{"status": "pulling 6a0746a1ec1a", "total": 4000, "completed": 4000},
+				map[string]any{"status": "success"},
+			}}},
+			want: []string{"\rpulling manifest", "\r[=======                       ]  25% 4.0 kB", "\r[==============================] 100% 4.0 kB", "\rsuccess"},
+		},
+		{
+			name: "retried when the server is unavailable",
+			responses: []fakeResponse{
+				{Status: http.StatusServiceUnavailable, Body: map[string]any{"error": "busy"}},
+				{Events: []any{map[string]any{"status": "success"}}},
+			},
+			want: []string{"\rsuccess"},
+		},
+		{
+			name:      "error reported by the server",
+			responses: []fakeResponse{{Events: []any{map[string]any{"status": "pulling manifest"}, map[string]any{"error": "pull model manifest: file does not exist"}}}},
+			want:      []string{"\rpulling manifest"},
+			wantErr:   "pulling phi3: pull model manifest: file does not exist",
+		},
+		{
+			name:      "request refused",
+			responses: []fakeResponse{{Status: http.StatusBadRequest, Body: map[string]any{"error": "invalid model name"}}},
+			wantErr:   "pulling phi3 returned 400 Bad Request",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := newFakeServer(t)
+			server.Queue(routeOllamaPull, test.responses...)
+			t.Setenv("OLLAMA_HOST", server.URL)
+			previous := activeRetryPolicy
+			activeRetryPolicy = RetryPolicy{MaxAttempts: 2, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond}
+			t.Cleanup(func() { activeRetryPolicy = previous })
+
+			var progress bytes.Buffer
+			err := PullOllamaModel(context.Background(), &progress, "phi3")
+
+			if test.wantErr == "" && err != nil {
+				t.Fatal(err)
+			}
+			if test.wantErr != "" && (err == nil || !strings.Contains(err.Error(), test.wantErr)) {
+				t.Fatalf("got error %v, want %q", err, test.wantErr)
+			}
+			for _, want := range test.want {
+				if !strings.Contains(progress.String(), want) {
+					t.Errorf("progress %q does not contain %q", progress.String(), want)
+				}
+			}
+
+			requests := server.Requests(routeOllamaPull)
+			if len(requests) != len(test.responses) || requests[0]["name"] != "phi3" {
+				t.Errorf("got requests %v", requests)
+			}
+		})
+	}
+}
+
+func TestLocalModelSelection(t *testing.T) {
+	tests := []struct {
+		name       string
+		model      string
+		wantStderr []string
+		wantCode   int
+	}{
+		{name: "installed model without a tag", model: "llama3", wantStderr: []string{"Using local model llama3"}},
+		{name: "model not installed", model: "phi3", wantStderr: []string{"Error: model phi3 is not installed", "Hint: run `ollama pull phi3`"}, wantCode: int(KindModelNotFound)},
+		{name: "picker needs a terminal", wantStderr: []string{"Error: no Ollama model given", "Hint: pass --model or set OLLAMA_MODEL"}, wantCode: int(KindUsage)},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := newFakeServer(t)
+			server.Env["OLLAMA_MODEL"] = test.model
+			if test.wantCode == 0 {
+				server.Queue(routeOllamaChat, ollamaChat("A language."))
+			}
+
+			_, stderr, code := runCommandWithCode(t, server, "", "question", "--local", "What is Go?")
+
+			if code != test.wantCode {
+				t.Errorf("exit code %d, want %d", code, test.wantCode)
+			}
+			for _, want := range test.wantStderr {
+				if !strings.Contains(stderr, want) {
+					t.Errorf("stderr %q does not contain %q", stderr, want)
+				}
+			}
+		})
+	}
+}
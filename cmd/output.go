@@ -0,0 +1,161 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// Output formats accepted by the global --output flag
+const (
+	OutputText     = "text"
+	OutputJSON     = "json"
+	OutputMarkdown = "markdown"
+)
+
+// Result is the structured form of a single answer or generated image,
+// written as one JSON object per line in --output json mode
+type Result struct {
+	Command       string                `json:"command"`
+	Model         string                `json:"model,omitempty"`
+	Index         int                   `json:"index"`
+	Content       string                `json:"content,omitempty"`
+	FinishReason  string                `json:"finish_reason,omitempty"`
+	Usage         *Usage                `json:"usage,omitempty"`
+	Cost          *float64              `json:"cost_usd,omitempty"`
+	ContentFilter []ContentFilterResult `json:"content_filter,omitempty"`
+	PromptFilter  []ContentFilterResult `json:"prompt_filter,omitempty"`
+	ToolCalls     []ToolCall            `json:"tool_calls,omitempty"`
+	Prompt        string                `json:"prompt,omitempty"`
+	ImageURL      string                `json:"image_url,omitempty"`
+	RevisedPrompt string                `json:"revised_prompt,omitempty"`
+	ImagePath     string                `json:"image_path,omitempty"`
+	LatencyMS     int64                 `json:"latency_ms"`
+}
+
+// GetOutputFormat returns the value of the --output flag
+func GetOutputFormat(cmd *cobra.Command) string {
+	format, err := cmd.Flags().GetString("output")
+	if err != nil || format == "" {
+		return OutputText
+	}
+	return format
+}
+
+// validateOutputFormat rejects unknown --output values before a command runs
+func validateOutputFormat(cmd *cobra.Command) error {
+	switch GetOutputFormat(cmd) {
+	case OutputText, OutputJSON, OutputMarkdown:
+		return nil
+	}
+	return Errorf(KindUsage, "unknown output format %q (available: %s, %s, %s)", GetOutputFormat(cmd), OutputText, OutputJSON, OutputMarkdown)
+}
+
+// WriteResult writes a result to w as a single line of JSON
+func WriteResult(w io.Writer, result Result) error {
+	return json.NewEncoder(w).Encode(result)
+}
+
+// WriteCompletion prints a completion in the format selected with --output.
+// toolCalls are the tools the model called on the way to its answer and
+// streamed reports whether the content has already been written.
+func WriteCompletion(cmd *cobra.Command, completion *Completion, toolCalls []ToolCall, latency time.Duration, streamed bool) error {
+	switch GetOutputFormat(cmd) {
+	case OutputJSON:
+		for _, choice := range completion.Choices {
+			err := WriteResult(cmd.OutOrStdout(), Result{
+				Command:       cmd.Name(),
+				Model:         completion.Model,
+				Index:         choice.Index,
+				Content:       choice.Content,
+				FinishReason:  choice.FinishReason,
+				Usage:         completion.Usage,
+				Cost:          completionCost(completion),
+				ContentFilter: choice.ContentFilter,
+				PromptFilter:  completion.PromptFilter,
+				ToolCalls:     toolCalls,
+				LatencyMS:     latency.Milliseconds(),
+			})
+			if err != nil {
+				return err
+			}
+		}
+	case OutputMarkdown:
+		printMarkdown(cmd.OutOrStdout(), completion, toolCalls, latency, streamed)
+	default:
+		showFilters, _ := cmd.Flags().GetBool("show-filters")
+		// The answers go to stdout so they can be redirected, several of them
+		// together so they can be compared, and the diagnostics to stderr
+		switch {
+		case streamed:
+		case len(completion.Choices) > 1:
+			WriteChoices(cmd.OutOrStdout(), completion.Choices, GetLayout(cmd))
+		case len(completion.Choices) == 1 && completion.Choices[0].Content != "":
+			fmt.Fprintln(cmd.OutOrStdout(), completion.Choices[0].Content)
+		}
+		PrintCompletion(cmd.ErrOrStderr(), completion, showFilters)
+	}
+	return nil
+}
+
+// printMarkdown writes each choice as a markdown section followed by a short summary line
+func printMarkdown(w io.Writer, completion *Completion, toolCalls []ToolCall, latency time.Duration, streamed bool) {
+	for _, choice := range completion.Choices {
+		if len(completion.Choices) > 1 {
+			fmt.Fprintf(w, "## Answer %d\n\n", choice.Index+1)
+		}
+		if !streamed {
+			fmt.Fprintf(w, "%s\n\n", strings.TrimSpace(choice.Content))
+		}
+
+		for _, call := range toolCalls {
+			fmt.Fprintf(w, "- called `%s(%s)`\n", call.Name, call.Arguments)
+		}
+		if len(toolCalls) > 0 {
+			fmt.Fprintln(w)
+		}
+
+		details := []string{}
+		if completion.Model != "" {
+			details = append(details, "model: "+completion.Model)
+		}
+		if choice.FinishReason != "" {
+			details = append(details, "finish reason: "+choice.FinishReason)
+		}
+		if completion.Usage != nil {
+			details = append(details, fmt.Sprintf("tokens: %d", completion.Usage.TotalTokens))
+		}
+		if cost := completionCost(completion); cost != nil {
+			details = append(details, "cost: "+FormatCost(*cost))
+		}
+		details = append(details, fmt.Sprintf("%dms", latency.Milliseconds()))
+		fmt.Fprintf(w, "_%s_\n", strings.Join(details, " · "))
+
+		if choice.Blocked() || completion.PromptBlocked() {
+			fmt.Fprintf(w, "\n> **Blocked by the content filter:** %s\n", filteredSummary(append(completion.PromptFilter, choice.ContentFilter...)))
+		}
+	}
+}
+
+// completionCost returns the estimated cost of a completion, nil when the
+// usage or the price of the model is unknown
+func completionCost(completion *Completion) *float64 {
+	cost, ok := EstimateCost(completion.Model, completion.Usage)
+	if !ok {
+		return nil
+	}
+	return &cost
+}
+
+// toolCallsIn collects the tool calls made by the assistant in a message history
+func toolCallsIn(messages []Message) []ToolCall {
+	var calls []ToolCall
+	for _, message := range messages {
+		calls = append(calls, message.ToolCalls...)
+	}
+	return calls
+}
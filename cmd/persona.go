@@ -0,0 +1,87 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// addSystemFlags adds the flags that replace the system prompt to a chat command
+func addSystemFlags(cmd *cobra.Command) {
+	cmd.Flags().String("system", "", "System prompt to use instead of the command's own")
+	cmd.Flags().String("system-file", "", "Read the system prompt from a file")
+	cmd.Flags().String("persona", "", "Use the system prompt of a persona from the config file (default is $PERSONA)")
+}
+
+// GetSystemPrompt returns the system prompt selected with --system,
+// --system-file or --persona, in that order, falling back to the PERSONA
+// setting and then to fallback
+func GetSystemPrompt(cmd *cobra.Command, fallback string) (string, error) {
+	system, _ := cmd.Flags().GetString("system")
+	systemFile, _ := cmd.Flags().GetString("system-file")
+	if system != "" && systemFile != "" {
+		return "", Errorf(KindUsage, "--system and --system-file cannot be used together")
+	}
+	if system != "" {
+		return system, nil
+	}
+	if systemFile != "" {
+		data, err := os.ReadFile(systemFile)
+		if err != nil {
+			return "", NewError(KindUsage, fmt.Errorf("reading system prompt: %w", err))
+		}
+		if strings.TrimSpace(string(data)) == "" {
+			return "", Errorf(KindUsage, "system prompt file %s is empty", systemFile)
+		}
+		return strings.TrimSpace(string(data)), nil
+	}
+
+	persona, _ := cmd.Flags().GetString("persona")
+	if persona == "" {
+		persona = os.Getenv("PERSONA")
+	}
+	if persona == "" {
+		return fallback, nil
+	}
+	prompt, ok := activeConfig.Personas[persona]
+	if !ok {
+		hint := "define it under personas: in ~/.config/go-cli-gpt/config.yaml"
+		if names := personaNames(); len(names) > 0 {
+			hint = "available personas: " + strings.Join(names, ", ") + ", or " + hint
+		}
+		return "", &Error{Kind: KindUsage, Err: fmt.Errorf("persona %q not found", persona), Hint: hint}
+	}
+	return strings.TrimSpace(prompt), nil
+}
+
+// ApplySystemPrompt replaces the system message of a request with the one
+// selected for the command, or adds it in front when there is none. The
+// messages are returned unchanged when no system prompt was selected.
+func ApplySystemPrompt(cmd *cobra.Command, messages []Message) ([]Message, error) {
+	system, err := GetSystemPrompt(cmd, "")
+	if err != nil || system == "" {
+		return messages, err
+	}
+
+	applied := make([]Message, 0, len(messages)+1)
+	applied = append(applied, Message{Role: RoleSystem, Content: system})
+	for _, message := range messages {
+		if message.Role != RoleSystem {
+			applied = append(applied, message)
+		}
+	}
+	return applied, nil
+}
+
+// personaNames returns the personas defined in the config file in alphabetical order
+func personaNames() []string {
+	names := make([]string, 0, len(activeConfig.Personas))
+	for name := range activeConfig.Personas {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,141 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSystemPrompt(t *testing.T) {
+	dir := t.TempDir()
+	config := filepath.Join(dir, "config.yaml")
+	personas := "personas:\n  reviewer: You are a strict code reviewer.\n  sql-helper: You write PostgreSQL queries.\n"
+	if err := os.WriteFile(config, []byte(personas), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	systemFile := filepath.Join(dir, "system.txt")
+	if err := os.WriteFile(systemFile, []byte("You answer in haiku.\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		args       []string
+		env        map[string]string
+		route      string
+		response   fakeResponse
+		wantSystem string
+		wantStderr string
+		wantCode   int
+	}{
+		{
+			name:       "default",
+			args:       []string{"question", "What is Go?"},
+			wantSystem: "You are a personal assistant to help with generic user questions.",
+		},
+		{
+			name:       "system flag",
+			args:       []string{"question", "--system", "You are terse.", "What is Go?"},
+			wantSystem: "You are terse.",
+		},
+		{
+			name:       "system file",
+			args:       []string{"translate", "--to", "French", "--system-file", systemFile, "Hello"},
+			wantSystem: "You answer in haiku.",
+		},
+		{
+			name:       "persona",
+			args:       []string{"question", "--config", config, "--persona", "reviewer", "Is this fine?"},
+			wantSystem: "You are a strict code reviewer.",
+		},
+		{
+			name:       "persona from the environment",
+			args:       []string{"question", "--config", config, "Select all users"},
+			env:        map[string]string{"PERSONA": "sql-helper"},
+			wantSystem: "You write PostgreSQL queries.",
+		},
+		{
+			name:       "system flag wins over persona",
+			args:       []string{"question", "--config", config, "--persona", "reviewer", "--system", "You are terse.", "Is this fine?"},
+			wantSystem: "You are terse.",
+		},
+		{
+			name:       "ollama",
+			args:       []string{"question", "--local", "--config", config, "--persona", "reviewer", "Is this fine?"},
+			route:      routeOllamaChat,
+			response:   ollamaChat("Yes."),
+			wantSystem: "You are a strict code reviewer.",
+		},
+		{
+			name:       "get-weather",
+			args:       []string{"get-weather", "--system", "You are a weather presenter.", "Paris"},
+			response:   azureChat("Sunny."),
+			wantSystem: "You are a weather presenter.",
+		},
+		{
+			name:       "unknown persona",
+			args:       []string{"question", "--config", config, "--persona", "poet", "What is Go?"},
+			wantStderr: "Error: persona \"poet\" not found\nHint: available personas: reviewer, sql-helper",
+			wantCode:   int(KindUsage),
+		},
+		{
+			name:       "both system flags",
+			args:       []string{"question", "--system", "You are terse.", "--system-file", systemFile, "What is Go?"},
+			wantStderr: "Error: --system and --system-file cannot be used together",
+			wantCode:   int(KindUsage),
+		},
+		{
+			name:       "missing system file",
+			args:       []string{"question", "--system-file", filepath.Join(dir, "missing.txt"), "What is Go?"},
+			wantStderr: "Error: reading system prompt",
+			wantCode:   int(KindUsage),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := newFakeServer(t)
+			for key, value := range test.env {
+				server.Env[key] = value
+			}
+			route, response := test.route, test.response
+			if route == "" {
+				route = routeAzureChat
+			}
+			if response.Body == nil && response.Events == nil {
+				response = azureChat("Done.")
+			}
+			server.Queue(route, response)
+
+			_, stderr, code := runCommandWithCode(t, server, "", test.args...)
+			if code != test.wantCode {
+				t.Errorf("exit code %d, want %d", code, test.wantCode)
+			}
+			if !strings.Contains(stderr, test.wantStderr) {
+				t.Errorf("stderr %q does not contain %q", stderr, test.wantStderr)
+			}
+
+			requests := server.Requests(route)
+			if test.wantSystem == "" {
+				if len(requests) != 0 {
+					t.Errorf("got %d requests, want none", len(requests))
+				}
+				return
+			}
+			if len(requests) == 0 {
+				t.Fatal("no request sent")
+			}
+			messages := requests[0]["messages"].([]any)
+			first := messages[0].(map[string]any)
+			if first["role"] != "system" || !strings.HasPrefix(first["content"].(string), test.wantSystem) {
+				t.Errorf("first message %v, want system prompt %q", first, test.wantSystem)
+			}
+			for _, message := range messages[1:] {
+				if message.(map[string]any)["role"] == "system" {
+					t.Errorf("more than one system message in %v", messages)
+				}
+			}
+		})
+	}
+}
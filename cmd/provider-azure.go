@@ -0,0 +1,406 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/ai/azopenai"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/spf13/cobra"
+)
+
+// azureProvider talks to a chat model deployed in Azure OpenAI
+type azureProvider struct {
+	client         *azopenai.Client
+	deploymentName string
+}
+
+func init() {
+	RegisterProvider("azure", newAzureProvider)
+}
+
+func newAzureProvider(cmd *cobra.Command, model string) (Provider, error) {
+	modelDeploymentID := os.Getenv("YOUR_MODEL_DEPLOYMENT_NAME")
+	if model != "" {
+		modelDeploymentID = model
+	} else if err := RequireSettings("YOUR_MODEL_DEPLOYMENT_NAME"); err != nil {
+		return nil, err
+	}
+
+	client, err := NewAzureClient()
+	if err != nil {
+		return nil, err
+	}
+
+	return &azureProvider{client: client, deploymentName: modelDeploymentID}, nil
+}
+
+// NewAzureClient creates an Azure OpenAI client from AZURE_OPENAI_ENDPOINT and AZURE_OPENAI_API_KEY
+func NewAzureClient() (*azopenai.Client, error) {
+	// Ex: "https://<your-azure-openai-host>.openai.azure.com"
+	azureOpenAIEndpoint := os.Getenv("AZURE_OPENAI_ENDPOINT")
+	azureOpenAIKey := os.Getenv("AZURE_OPENAI_API_KEY")
+
+	if err := RequireSettings("AZURE_OPENAI_ENDPOINT", "AZURE_OPENAI_API_KEY"); err != nil {
+		return nil, err
+	}
+
+	keyCredential := azcore.NewKeyCredential(azureOpenAIKey)
+
+	return azopenai.NewClientWithKeyCredential(azureOpenAIEndpoint, keyCredential, &azopenai.ClientOptions{
+		// Retries are handled by our own policy, which logs them under --verbose
+		ClientOptions: azcore.ClientOptions{Transport: retryingHTTPClient(), Retry: policy.RetryOptions{MaxRetries: -1}},
+	})
+}
+
+func (p *azureProvider) Model() string {
+	return p.deploymentName
+}
+
+func (p *azureProvider) Complete(ctx context.Context, messages []Message, opts CompletionOptions) (*Completion, error) {
+	options := azopenai.ChatCompletionsOptions{
+		// NOTE: all messages count against token usage for this API.
+		Messages:         toAzureMessages(messages),
+		DeploymentName:   &p.deploymentName,
+		Temperature:      opts.Temperature,
+		TopP:             opts.TopP,
+		PresencePenalty:  opts.PresencePenalty,
+		FrequencyPenalty: opts.FrequencyPenalty,
+		Stop:             opts.Stop,
+		Seed:             opts.Seed,
+	}
+	if opts.MaxTokens > 0 {
+		options.MaxTokens = to.Ptr(opts.MaxTokens)
+	}
+	if opts.N > 0 {
+		options.N = to.Ptr(opts.N)
+	}
+	for _, tool := range opts.Tools {
+		options.Tools = append(options.Tools, &azopenai.ChatCompletionsFunctionToolDefinition{
+			Function: &azopenai.FunctionDefinition{
+				Name:        to.Ptr(tool.Name),
+				Description: to.Ptr(tool.Description),
+				Parameters:  tool.Parameters,
+			},
+		})
+	}
+
+	if opts.StreamFunc != nil {
+		return p.stream(ctx, options, opts.StreamFunc)
+	}
+
+	resp, err := p.client.GetChatCompletions(ctx, options, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	completion := &Completion{Model: p.deploymentName}
+	if resp.Model != nil {
+		completion.Model = *resp.Model
+	}
+	if resp.Usage != nil {
+		completion.Usage = toUsage(resp.Usage)
+	}
+	completion.PromptFilter, completion.PromptFilterError = azurePromptFilter(resp.PromptFilterResults)
+	for _, choice := range resp.Choices {
+		c := Choice{}
+		mergeAzureChoice(&c, choice)
+		completion.Choices = append(completion.Choices, c)
+	}
+
+	return completion, nil
+}
+
+// stream requests a streamed completion, handing every content delta to
+// streamFunc as it arrives and assembling the full completion at the end
+func (p *azureProvider) stream(ctx context.Context, options azopenai.ChatCompletionsOptions, streamFunc func(token string) error) (*Completion, error) {
+	resp, err := p.client.GetChatCompletionsStream(ctx, options, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.ChatCompletionsStream.Close()
+
+	choices := map[int]*Choice{}
+	var order []int
+	model := p.deploymentName
+	var usage *Usage
+	var promptFilter []ContentFilterResult
+	var promptFilterError string
+	collect := func() *Completion {
+		completion := &Completion{Model: model, Usage: usage, PromptFilter: promptFilter, PromptFilterError: promptFilterError}
+		for _, index := range order {
+			completion.Choices = append(completion.Choices, *choices[index])
+		}
+		return completion
+	}
+
+	for {
+		event, err := resp.ChatCompletionsStream.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				err = ctx.Err()
+			}
+			return collect(), err
+		}
+
+		if event.Model != nil && *event.Model != "" {
+			model = *event.Model
+		}
+		if event.Usage != nil {
+			usage = toUsage(event.Usage)
+		}
+		// The prompt verdicts arrive once, in the first event
+		if len(event.PromptFilterResults) > 0 {
+			promptFilter, promptFilterError = azurePromptFilter(event.PromptFilterResults)
+		}
+
+		for _, choice := range event.Choices {
+			index := 0
+			if choice.Index != nil {
+				index = int(*choice.Index)
+			}
+			c, ok := choices[index]
+			if !ok {
+				c = &Choice{Index: index}
+				choices[index] = c
+				order = append(order, index)
+			}
+
+			if choice.Delta != nil && choice.Delta.Content != nil && *choice.Delta.Content != "" {
+				if err := streamFunc(*choice.Delta.Content); err != nil {
+					return collect(), err
+				}
+			}
+			mergeAzureChoice(c, choice)
+		}
+	}
+
+	return collect(), nil
+}
+
+// mergeAzureChoice copies the fields of an Azure OpenAI choice into c. Streamed
+// deltas are appended to any content already collected.
+func mergeAzureChoice(c *Choice, choice azopenai.ChatChoice) {
+	if choice.Index != nil {
+		c.Index = int(*choice.Index)
+	}
+	if choice.Message != nil && choice.Message.Content != nil {
+		c.Content = *choice.Message.Content
+	}
+	if choice.Message != nil {
+		for _, toolCall := range choice.Message.ToolCalls {
+			funcToolCall, ok := toolCall.(*azopenai.ChatCompletionsFunctionToolCall)
+			if !ok || funcToolCall.Function == nil {
+				continue
+			}
+			call := ToolCall{}
+			if funcToolCall.ID != nil {
+				call.ID = *funcToolCall.ID
+			}
+			if funcToolCall.Function.Name != nil {
+				call.Name = *funcToolCall.Function.Name
+			}
+			if funcToolCall.Function.Arguments != nil {
+				call.Arguments = *funcToolCall.Function.Arguments
+			}
+			c.ToolCalls = append(c.ToolCalls, call)
+		}
+	}
+	if choice.Delta != nil && choice.Delta.Content != nil {
+		c.Content += *choice.Delta.Content
+	}
+	if choice.FinishReason != nil {
+		c.FinishReason = string(*choice.FinishReason)
+	}
+	// Streamed answers get verdicts chunk by chunk, a later clean chunk must
+	// not hide an earlier one that was filtered
+	if filter := choice.ContentFilterResults; filter != nil {
+		results, filterErr := azureChoiceFilter(filter)
+		c.ContentFilter = mergeFilterResults(c.ContentFilter, results)
+		if c.FilterError == "" {
+			c.FilterError = filterErr
+		}
+	}
+}
+
+// severityRank orders the severities reported by the content filter
+var severityRank = map[string]int{"safe": 1, "low": 2, "medium": 3, "high": 4}
+
+// mergeFilterResults adds the verdicts in next to those already collected,
+// keeping the most severe verdict for each category
+func mergeFilterResults(results []ContentFilterResult, next []ContentFilterResult) []ContentFilterResult {
+	for _, n := range next {
+		i := slices.IndexFunc(results, func(r ContentFilterResult) bool { return r.Category == n.Category })
+		if i < 0 {
+			results = append(results, n)
+			continue
+		}
+		r := &results[i]
+		r.Filtered = r.Filtered || n.Filtered
+		if severityRank[n.Severity] > severityRank[r.Severity] {
+			r.Severity = n.Severity
+		}
+		if n.Detected != nil && (r.Detected == nil || *n.Detected) {
+			r.Detected = n.Detected
+		}
+		if r.Details == "" {
+			r.Details = n.Details
+		}
+	}
+	return results
+}
+
+func toUsage(usage *azopenai.CompletionsUsage) *Usage {
+	u := &Usage{}
+	if usage.PromptTokens != nil {
+		u.PromptTokens = int(*usage.PromptTokens)
+	}
+	if usage.CompletionTokens != nil {
+		u.CompletionTokens = int(*usage.CompletionTokens)
+	}
+	if usage.TotalTokens != nil {
+		u.TotalTokens = int(*usage.TotalTokens)
+	}
+	return u
+}
+
+// toAzureMessages converts provider agnostic messages into Azure OpenAI request messages
+func toAzureMessages(messages []Message) []azopenai.ChatRequestMessageClassification {
+	azureMessages := make([]azopenai.ChatRequestMessageClassification, 0, len(messages))
+	for _, message := range messages {
+		switch message.Role {
+		case RoleSystem:
+			azureMessages = append(azureMessages, &azopenai.ChatRequestSystemMessage{Content: to.Ptr(message.Content)})
+		case RoleAssistant:
+			assistantMessage := &azopenai.ChatRequestAssistantMessage{}
+			if message.Content != "" || len(message.ToolCalls) == 0 {
+				assistantMessage.Content = to.Ptr(message.Content)
+			}
+			for _, call := range message.ToolCalls {
+				assistantMessage.ToolCalls = append(assistantMessage.ToolCalls, &azopenai.ChatCompletionsFunctionToolCall{
+					ID:       to.Ptr(call.ID),
+					Type:     to.Ptr("function"),
+					Function: &azopenai.FunctionCall{Name: to.Ptr(call.Name), Arguments: to.Ptr(call.Arguments)},
+				})
+			}
+			azureMessages = append(azureMessages, assistantMessage)
+		case RoleTool:
+			azureMessages = append(azureMessages, &azopenai.ChatRequestToolMessage{Content: to.Ptr(message.Content), ToolCallID: to.Ptr(message.ToolCallID)})
+		default:
+			azureMessages = append(azureMessages, &azopenai.ChatRequestUserMessage{Content: azopenai.NewChatRequestUserMessageContent(message.Content)})
+		}
+	}
+	return azureMessages
+}
+
+// azureChoiceFilter converts the content filter verdicts for an answer.
+// Categories the service left out are skipped.
+func azureChoiceFilter(filter *azopenai.ContentFilterResultsForChoice) ([]ContentFilterResult, string) {
+	var results []ContentFilterResult
+	results = appendSeverityResult(results, "Hate", filter.Hate)
+	results = appendSeverityResult(results, "SelfHarm", filter.SelfHarm)
+	results = appendSeverityResult(results, "Sexual", filter.Sexual)
+	results = appendSeverityResult(results, "Violence", filter.Violence)
+	results = appendDetectionResult(results, "Profanity", filter.Profanity)
+	results = appendDetectionResult(results, "ProtectedMaterialText", filter.ProtectedMaterialText)
+	if code := filter.ProtectedMaterialCode; code != nil {
+		r := ContentFilterResult{Category: "ProtectedMaterialCode", Filtered: deref(code.Filtered), Detected: code.Detected}
+		var citation []string
+		if code.License != nil && *code.License != "" {
+			citation = append(citation, "license "+*code.License)
+		}
+		if code.URL != nil && *code.URL != "" {
+			citation = append(citation, *code.URL)
+		}
+		r.Details = strings.Join(citation, " ")
+		results = append(results, r)
+	}
+	results = appendBlocklistResult(results, filter.CustomBlockLists)
+	return results, filterError(filter.Error)
+}
+
+// azurePromptFilter converts the content filter verdicts for the prompt
+func azurePromptFilter(prompts []azopenai.ContentFilterResultsForPrompt) ([]ContentFilterResult, string) {
+	var results []ContentFilterResult
+	var errs []string
+	for _, prompt := range prompts {
+		if prompt.ContentFilterResults == nil {
+			continue
+		}
+		promptResults, err := azurePromptFilterDetails(prompt.ContentFilterResults)
+		results = append(results, promptResults...)
+		if err != "" {
+			errs = append(errs, err)
+		}
+	}
+	return results, strings.Join(errs, "; ")
+}
+
+func azurePromptFilterDetails(filter *azopenai.ContentFilterResultDetailsForPrompt) ([]ContentFilterResult, string) {
+	var results []ContentFilterResult
+	results = appendSeverityResult(results, "Hate", filter.Hate)
+	results = appendSeverityResult(results, "SelfHarm", filter.SelfHarm)
+	results = appendSeverityResult(results, "Sexual", filter.Sexual)
+	results = appendSeverityResult(results, "Violence", filter.Violence)
+	results = appendDetectionResult(results, "Profanity", filter.Profanity)
+	results = appendDetectionResult(results, "Jailbreak", filter.Jailbreak)
+	results = appendDetectionResult(results, "IndirectAttack", filter.IndirectAttack)
+	results = appendBlocklistResult(results, filter.CustomBlockLists)
+	return results, filterError(filter.Error)
+}
+
+func appendSeverityResult(results []ContentFilterResult, category string, result *azopenai.ContentFilterResult) []ContentFilterResult {
+	if result == nil {
+		return results
+	}
+	r := ContentFilterResult{Category: category, Filtered: deref(result.Filtered)}
+	if result.Severity != nil {
+		r.Severity = string(*result.Severity)
+	}
+	return append(results, r)
+}
+
+func appendDetectionResult(results []ContentFilterResult, category string, result *azopenai.ContentFilterDetectionResult) []ContentFilterResult {
+	if result == nil {
+		return results
+	}
+	return append(results, ContentFilterResult{Category: category, Filtered: deref(result.Filtered), Detected: result.Detected})
+}
+
+func appendBlocklistResult(results []ContentFilterResult, result *azopenai.ContentFilterDetailedResults) []ContentFilterResult {
+	if result == nil {
+		return results
+	}
+	var ids []string
+	for _, detail := range result.Details {
+		if deref(detail.Filtered) && detail.ID != nil {
+			ids = append(ids, *detail.ID)
+		}
+	}
+	return append(results, ContentFilterResult{Category: "CustomBlocklists", Filtered: deref(result.Filtered), Details: strings.Join(ids, ", ")})
+}
+
+func filterError(err *azopenai.Error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
+
+// deref returns the value v points to, or the zero value when v is nil
+func deref[T any](v *T) T {
+	var zero T
+	if v == nil {
+		return zero
+	}
+	return *v
+}
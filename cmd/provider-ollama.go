@@ -0,0 +1,184 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/tmc/langchaingo/llms"
+	"github.com/tmc/langchaingo/llms/ollama"
+)
+
+// ollamaProvider talks to a model served by a local Ollama instance
+type ollamaProvider struct {
+	llm   *ollama.LLM
+	model string
+}
+
+func init() {
+	RegisterProvider("ollama", newOllamaProvider)
+}
+
+func newOllamaProvider(cmd *cobra.Command, model string) (Provider, error) {
+	if model == "" {
+		model = os.Getenv("OLLAMA_MODEL")
+	}
+
+	selectedOption, err := GetLocalModel(cmd, model)
+	if err != nil {
+		return nil, err
+	}
+	model = selectedOption
+
+	log.Printf("Using local model %s", model)
+	client := retryingHTTPClient()
+	client.Transport = &ollamaTemperatureTransport{next: client.Transport}
+	llm, err := ollama.New(ollama.WithModel(model), ollama.WithServerURL(OllamaBaseURL()), ollama.WithHTTPClient(client))
+	if err != nil {
+		return nil, err
+	}
+
+	return &ollamaProvider{llm: llm, model: model}, nil
+}
+
+func (p *ollamaProvider) Model() string {
+	return p.model
+}
+
+func (p *ollamaProvider) Complete(ctx context.Context, messages []Message, opts CompletionOptions) (*Completion, error) {
+	var callOptions []llms.CallOption
+	if opts.MaxTokens > 0 {
+		callOptions = append(callOptions, llms.WithMaxTokens(int(opts.MaxTokens)))
+	}
+	if opts.Temperature != nil {
+		callOptions = append(callOptions, llms.WithTemperature(float64(*opts.Temperature)))
+	} else {
+		ctx = context.WithValue(ctx, unsetTemperatureKey{}, true)
+	}
+	if opts.TopP != nil {
+		callOptions = append(callOptions, llms.WithTopP(float64(*opts.TopP)))
+	}
+	if opts.PresencePenalty != nil {
+		callOptions = append(callOptions, llms.WithPresencePenalty(float64(*opts.PresencePenalty)))
+	}
+	if opts.FrequencyPenalty != nil {
+		callOptions = append(callOptions, llms.WithFrequencyPenalty(float64(*opts.FrequencyPenalty)))
+	}
+	if len(opts.Stop) > 0 {
+		callOptions = append(callOptions, llms.WithStopWords(opts.Stop))
+	}
+	if opts.Seed != nil {
+		// langchaingo leaves a zero seed out of the request
+		if *opts.Seed == 0 {
+			warnIgnored("a seed of 0")
+		}
+		callOptions = append(callOptions, llms.WithSeed(int(*opts.Seed)))
+	}
+	// Without tool calling the model would make up an answer with no data behind it
+	if len(opts.Tools) > 0 {
+		err := errors.New("tools are not supported by the ollama provider")
+		return nil, &Error{Kind: KindUsage, Err: err, Hint: "use --provider azure for commands that call tools, or drop --tools"}
+	}
+	if opts.N > 1 {
+		warnIgnored("more than one choice")
+	}
+	if opts.StreamFunc != nil {
+		callOptions = append(callOptions, llms.WithStreamingFunc(func(ctx context.Context, chunk []byte) error {
+			return opts.StreamFunc(string(chunk))
+		}))
+	}
+
+	resp, err := p.llm.GenerateContent(ctx, toOllamaMessages(messages), callOptions...)
+	if err != nil {
+		return nil, ollamaError(err)
+	}
+
+	completion := &Completion{Model: p.model}
+	for i, choice := range resp.Choices {
+		completion.Choices = append(completion.Choices, Choice{
+			Index:        i,
+			Content:      choice.Content,
+			FinishReason: choice.StopReason,
+		})
+
+		// Ollama reports token counts in the generation info of the choice
+		if promptTokens, ok := choice.GenerationInfo["PromptTokens"].(int); ok {
+			completionTokens, _ := choice.GenerationInfo["CompletionTokens"].(int)
+			completion.Usage = &Usage{
+				PromptTokens:     promptTokens,
+				CompletionTokens: completionTokens,
+				TotalTokens:      promptTokens + completionTokens,
+			}
+		}
+	}
+
+	return completion, nil
+}
+
+// unsetTemperatureKey marks the context of a request made without a temperature
+type unsetTemperatureKey struct{}
+
+// ollamaTemperatureTransport removes the temperature from requests made
+// without one. langchaingo always sends it, as 0 when it was not given,
+// which would override the temperature of the model.
+type ollamaTemperatureTransport struct {
+	next http.RoundTripper
+}
+
+func (t *ollamaTemperatureTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Body == nil || req.Context().Value(unsetTemperatureKey{}) == nil {
+		return t.next.RoundTrip(req)
+	}
+	body, err := io.ReadAll(req.Body)
+	req.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	var request map[string]json.RawMessage
+	var options map[string]json.RawMessage
+	if json.Unmarshal(body, &request) == nil && json.Unmarshal(request["options"], &options) == nil {
+		delete(options, "temperature")
+		if encoded, err := json.Marshal(options); err == nil {
+			request["options"] = encoded
+			if encoded, err := json.Marshal(request); err == nil {
+				body = encoded
+			}
+		}
+	}
+
+	req = req.Clone(req.Context())
+	req.Body = io.NopCloser(bytes.NewReader(body))
+	req.ContentLength = int64(len(body))
+	req.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(body)), nil
+	}
+	return t.next.RoundTrip(req)
+}
+
+// warnIgnored tells the user a setting has no effect with Ollama
+func warnIgnored(setting string) {
+	log.Printf("Warning: the ollama provider does not support %s, ignoring it", setting)
+}
+
+// toOllamaMessages converts provider agnostic messages into langchaingo message contents
+func toOllamaMessages(messages []Message) []llms.MessageContent {
+	contents := make([]llms.MessageContent, 0, len(messages))
+	for _, message := range messages {
+		role := llms.ChatMessageTypeHuman
+		switch message.Role {
+		case RoleSystem:
+			role = llms.ChatMessageTypeSystem
+		case RoleAssistant:
+			role = llms.ChatMessageTypeAI
+		}
+		contents = append(contents, llms.TextParts(role, message.Content))
+	}
+	return contents
+}
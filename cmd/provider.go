@@ -0,0 +1,288 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// Role identifies who authored a chat message
+type Role string
+
+const (
+	RoleSystem    Role = "system"
+	RoleUser      Role = "user"
+	RoleAssistant Role = "assistant"
+	RoleTool      Role = "tool"
+)
+
+// Message is a single chat message sent to or received from a provider.
+// Assistant messages may carry the tool calls the model asked for and tool
+// messages carry the result of one of those calls.
+type Message struct {
+	Role       Role       `json:"role"`
+	Content    string     `json:"content"`
+	ToolCalls  []ToolCall `json:"tool_calls,omitempty"`
+	ToolCallID string     `json:"tool_call_id,omitempty"`
+}
+
+// ToolCall is a request from the model to run one of the tools it was offered
+type ToolCall struct {
+	ID        string `json:"id"`
+	Name      string `json:"name"`
+	Arguments string `json:"arguments"`
+}
+
+// ToolDefinition describes a tool the model may call
+type ToolDefinition struct {
+	Name        string
+	Description string
+	Parameters  map[string]any
+}
+
+// CompletionOptions holds the tuning knobs shared by every provider
+type CompletionOptions struct {
+	MaxTokens int32
+	// The sampling parameters are left to the model's default when nil or empty
+	Temperature      *float32
+	TopP             *float32
+	PresencePenalty  *float32
+	FrequencyPenalty *float32
+	Stop             []string
+	Seed             *int64
+	// N is the number of answers to generate, one when zero
+	N     int32
+	Tools []ToolDefinition
+	// StreamFunc, when set, asks the provider to stream the answer and is
+	// called with every chunk of content as it arrives
+	StreamFunc func(token string) error
+}
+
+// ContentFilterResult is the verdict of a single content filter category
+type ContentFilterResult struct {
+	Category string `json:"category"`
+	Severity string `json:"severity,omitempty"`
+	Filtered bool   `json:"filtered"`
+	// Detected is only set by detection categories such as Jailbreak and ProtectedMaterialText
+	Detected *bool `json:"detected,omitempty"`
+	// Details lists matched blocklists or the source of protected code
+	Details string `json:"details,omitempty"`
+}
+
+// Choice is one of the answers returned by a provider
+type Choice struct {
+	Index         int
+	Content       string
+	FinishReason  string
+	ToolCalls     []ToolCall
+	ContentFilter []ContentFilterResult
+	FilterError   string
+}
+
+// Usage is the number of tokens consumed by a request
+type Usage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+	TotalTokens      int `json:"total_tokens"`
+}
+
+// Completion is the result of a chat completion request
+type Completion struct {
+	Model   string
+	Choices []Choice
+	Usage   *Usage
+	// PromptFilter holds the content filter verdicts for the prompt itself
+	PromptFilter      []ContentFilterResult
+	PromptFilterError string
+}
+
+// Provider is implemented by every LLM backend the CLI can talk to
+type Provider interface {
+	Complete(ctx context.Context, messages []Message, opts CompletionOptions) (*Completion, error)
+	// Model returns the model or deployment requests are sent to
+	Model() string
+}
+
+// ProviderFactory creates a ready to use provider for cmd, reading whatever
+// settings it needs. model overrides the configured model or deployment when
+// not empty.
+type ProviderFactory func(cmd *cobra.Command, model string) (Provider, error)
+
+var providers = map[string]ProviderFactory{}
+
+// httpClient is used for every outbound request the CLI makes, so tests can
+// point it at a fake server
+var httpClient = http.DefaultClient
+
+// RegisterProvider makes a provider available to the --provider flag.
+// It is called from the init function of each provider implementation.
+func RegisterProvider(name string, factory ProviderFactory) {
+	providers[name] = factory
+}
+
+// ProviderNames returns the names of all registered providers in alphabetical order
+func ProviderNames() []string {
+	names := make([]string, 0, len(providers))
+	for name := range providers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// NewProvider creates the provider registered under name for cmd
+func NewProvider(cmd *cobra.Command, name string, model string) (Provider, error) {
+	factory, ok := providers[name]
+	if !ok {
+		return nil, Errorf(KindUsage, "unknown provider %q (available: %s)", name, strings.Join(ProviderNames(), ", "))
+	}
+	return factory(cmd, model)
+}
+
+// GetProvider resolves the provider for a command, see ProviderName.
+// The --model flag picks the model or deployment.
+func GetProvider(cmd *cobra.Command) (Provider, error) {
+	model, _ := cmd.Flags().GetString("model")
+	return NewProvider(cmd, ProviderName(cmd), model)
+}
+
+// ProviderName returns the name of the provider selected for a command. The
+// legacy --local flag selects ollama, otherwise the --provider flag or
+// LLM_PROVIDER variable is used.
+func ProviderName(cmd *cobra.Command) string {
+	name := "azure"
+	if env := os.Getenv("LLM_PROVIDER"); env != "" {
+		name = env
+	}
+
+	providerFlag := cmd.Flags().Lookup("provider")
+	if providerFlag != nil && providerFlag.Changed {
+		name = providerFlag.Value.String()
+	}
+
+	localFlag := cmd.Flags().Lookup("local")
+	if localFlag != nil && localFlag.Changed {
+		name = "ollama"
+	}
+	return name
+}
+
+// RunCompletion sends messages to the provider and prints the result. When the
+// command's --stream flag is set the answer is written to stdout as it
+// arrives and Ctrl-C cancels the request. The --tools flag lets the model
+// call the built-in tools before answering. The prompt is checked against
+// the model's context window first. The completion is returned whenever the
+// provider answered, even if the answer was blocked.
+func RunCompletion(cmd *cobra.Command, provider Provider, messages []Message, opts CompletionOptions) (*Completion, error) {
+	if err := checkContextWindow(cmd, provider.Model(), messages, opts.MaxTokens); err != nil {
+		return nil, err
+	}
+
+	streamFlag := cmd.Flags().Lookup("stream")
+	stream := streamFlag != nil && streamFlag.Changed
+	if stream && opts.N > 1 {
+		return nil, Errorf(KindUsage, "--stream cannot be used with --choices, the answers would be interleaved")
+	}
+	if stream && GetOutputFormat(cmd) == OutputJSON {
+		fmt.Fprintf(cmd.ErrOrStderr(), "Warning: --stream is not supported with --output json, ignoring it\n")
+		stream = false
+	}
+
+	toolsFlag := cmd.Flags().Lookup("tools")
+	if toolsFlag != nil && toolsFlag.Changed {
+		if stream {
+			fmt.Fprintf(cmd.ErrOrStderr(), "Warning: --stream is not supported together with --tools, ignoring it\n")
+		}
+
+		registry, err := DefaultTools()
+		if err != nil {
+			return nil, err
+		}
+		registry.Output = cmd.ErrOrStderr()
+		start := time.Now()
+		completion, history, err := CompleteWithTools(context.TODO(), provider, registry, messages, opts, DefaultMaxToolIterations)
+		if err != nil {
+			return nil, err
+		}
+		if err := WriteCompletion(cmd, completion, toolCallsIn(history), time.Since(start), false); err != nil {
+			return completion, err
+		}
+		return completion, BlockedError(completion)
+	}
+
+	if !stream {
+		start := time.Now()
+		completion, err := provider.Complete(context.TODO(), messages, opts)
+		if err != nil {
+			return nil, err
+		}
+		if err := WriteCompletion(cmd, completion, nil, time.Since(start), false); err != nil {
+			return completion, err
+		}
+		return completion, BlockedError(completion)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	opts.StreamFunc = func(token string) error {
+		_, err := fmt.Fprint(cmd.OutOrStdout(), token)
+		return err
+	}
+
+	start := time.Now()
+	completion, err := provider.Complete(ctx, messages, opts)
+	fmt.Fprintln(cmd.OutOrStdout())
+	if errors.Is(err, context.Canceled) {
+		return nil, NewError(KindInterrupted, errors.New("cancelled"))
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := WriteCompletion(cmd, completion, nil, time.Since(start), true); err != nil {
+		return completion, err
+	}
+	return completion, BlockedError(completion)
+}
+
+// PrintCompletion writes the finish reason of every choice and the usage of a
+// completion to w, preceded by the content safety report when showFilters is
+// set. The answers themselves are written to stdout by WriteCompletion.
+func PrintCompletion(w io.Writer, completion *Completion, showFilters bool) {
+	gotReply := false
+
+	if showFilters {
+		WriteSafetyReport(w, completion)
+	}
+
+	for _, choice := range completion.Choices {
+		gotReply = true
+
+		if choice.FinishReason != "" {
+			// this choice's conversation is complete.
+			fmt.Fprintf(w, "Finish reason[%d]: %s\n", choice.Index, choice.FinishReason)
+		}
+	}
+
+	if gotReply {
+		fmt.Fprintf(w, "Received chat completions reply\n")
+	}
+
+	if usage := completion.Usage; usage != nil {
+		fmt.Fprintf(w, "Usage: %d prompt + %d completion = %d tokens", usage.PromptTokens, usage.CompletionTokens, usage.TotalTokens)
+		if cost, ok := EstimateCost(completion.Model, usage); ok {
+			fmt.Fprintf(w, ", estimated cost %s", FormatCost(cost))
+		}
+		fmt.Fprintln(w)
+	}
+}
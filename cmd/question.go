@@ -1,147 +1,116 @@
 package cmd
 
 import (
-	"context"
-	"fmt"
-	"log"
-	"os"
-
-	"github.com/Azure/azure-sdk-for-go/sdk/ai/azopenai"
-	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
-	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
-	"github.com/joho/godotenv"
+	"errors"
+
 	"github.com/spf13/cobra"
-	"github.com/tmc/langchaingo/llms"
-	"github.com/tmc/langchaingo/llms/ollama"
 )
 
-// exampleCmd represents the example command
+// questionCmd represents the question command
 var questionCmd = &cobra.Command{
-	Use:   "question",
+	Use:   "question [question]",
 	Short: "ask the LLM a question",
-	Long:  `use this command to ask a generic question and get an answer in your terminal`,
-	Run: func(cmd *cobra.Command, args []string) {
+	Long: `use this command to ask a generic question and get an answer in your terminal
 
-		// Load the .env file
-		if err := godotenv.Load(); err != nil {
-			fmt.Fprintf(os.Stderr, "Error loading .env file\n")
-			return
-		}
+The question can be given as arguments, piped in on stdin, or both:
+  git diff | go-cli-gpt question "review this"`,
+	RunE: func(cmd *cobra.Command, args []string) error {
 
-		// check for "local" flag - if local flag is set, use offline model
-		localFlag := cmd.Flags().Lookup("local")
-		if localFlag != nil && localFlag.Changed {
+		provider, err := GetProvider(cmd)
+		if err != nil {
+			return err
+		}
 
-			selectedOption, err := GetLocalModel()
-			if err != nil {
-				log.Fatal(err)
-			}
+		tmpl, err := GetTemplate(cmd, "question")
+		if err != nil {
+			return err
+		}
 
-			log.Printf("Using local model %s", selectedOption)
-			llm, err := ollama.New(ollama.WithModel(selectedOption))
+		// Get question from the arguments, stdin or user input, unless the template takes none
+		var question string
+		if tmpl.Uses("input") {
+			question, err = GetInput(cmd, args, "Please enter your question: ")
 			if err != nil {
-				log.Fatal(err)
+				return err
 			}
-
-			question := GetUserInput("Please enter your question: ")
-
-			ctx := context.Background()
-			completion, err := llms.GenerateFromSinglePrompt(ctx, llm, question)
-			if err != nil {
-				log.Fatal(err)
+			if question == "" && tmpl.Requires("input") {
+				return &Error{Kind: KindUsage, Err: errors.New("no question given"), Hint: "pass the question as arguments or pipe it on stdin"}
 			}
+		}
+		vars, err := GetTemplateVars(cmd, map[string]string{"input": question})
+		if err != nil {
+			return err
+		}
 
-			fmt.Println("Response:\n", completion)
-
-		} else {
-
-			// Using online LLM
-			azureOpenAIKey := os.Getenv("AZURE_OPENAI_API_KEY")
-			modelDeploymentID := os.Getenv("YOUR_MODEL_DEPLOYMENT_NAME")
-			maxTokens := int32(400)
-			azureOpenAIEndpoint := os.Getenv("AZURE_OPENAI_ENDPOINT")
-
-			if azureOpenAIKey == "" || modelDeploymentID == "" || azureOpenAIEndpoint == "" {
-				fmt.Fprintf(os.Stderr, "Unable to continue. Environment variables missing\n")
-				return
-			}
-
-			keyCredential := azcore.NewKeyCredential(azureOpenAIKey)
-
-			// Get question from user input
-			question := GetUserInput("Please enter your question: ")
-
-			client, err := azopenai.NewClientWithKeyCredential(azureOpenAIEndpoint, keyCredential, nil)
+		// NOTE: all messages, regardless of role, count against token usage for this API.
+		// The template sets the tone and rules of the conversation with the system
+		// role and renders the question as the user message.
+		messages, err := tmpl.Messages(vars)
+		if err != nil {
+			return err
+		}
+		prompt := messages[len(messages)-1]
+		if question == "" {
+			question = prompt.Content
+		}
 
+		// --continue picks up a recorded exchange, its messages replace the system prompt
+		parentID, _ := cmd.Flags().GetInt("continue")
+		if parentID != 0 {
+			messages, err = ContinueConversation(parentID)
 			if err != nil {
-				// TODO: Update with application specific error handling logic
-				log.Printf("ERROR: %s", err)
-				return
+				return err
 			}
+			messages = append(messages, prompt)
+		}
 
-			// NOTE: all messages, regardless of role, count against token usage for this API.
-			messages := []azopenai.ChatRequestMessageClassification{
-				// You set the tone and rules of the conversation with a prompt as the system role.
-				&azopenai.ChatRequestSystemMessage{Content: to.Ptr("You are a personal assistant to help with generic user questions. You can provide information on a wide range of topics.")},
-
-				// The user asks a question
-				// &azopenai.ChatRequestUserMessage{Content: azopenai.NewChatRequestUserMessageContent("Does Azure OpenAI support customer managed keys?")},
-				&azopenai.ChatRequestUserMessage{Content: azopenai.NewChatRequestUserMessageContent(question)},
-
-				// The reply would come back from the model. You'd add it to the conversation so we can maintain context.
-				// &azopenai.ChatRequestAssistantMessage{Content: to.Ptr("Yes, customer managed keys are supported by Azure OpenAI")},
+		// --system, --system-file and --persona replace the system prompt
+		messages, err = ApplySystemPrompt(cmd, messages)
+		if err != nil {
+			return err
+		}
 
-				// The user answers the question based on the latest reply.
-				// &azopenai.ChatRequestUserMessage{Content: azopenai.NewChatRequestUserMessageContent("What other Azure Services support customer managed keys?")},
+		maxTokens, err := GetMaxTokens(cmd)
+		if err != nil {
+			return err
+		}
 
-				// from here you'd keep iterating, sending responses back from ChatGPT
-			}
+		opts, err := GetSamplingOptions(cmd, CompletionOptions{MaxTokens: maxTokens})
+		if err != nil {
+			return err
+		}
 
-			gotReply := false
+		completion, err := RunCompletion(cmd, provider, messages, opts)
+		if err != nil || completion == nil || len(completion.Choices) < 2 || !canPick(cmd) {
+			RecordCompletion(cmd, question, messages, completion, 0, parentID)
+			return err
+		}
 
-			resp, err := client.GetChatCompletions(context.TODO(), azopenai.ChatCompletionsOptions{
-				// NOTE: all messages count against token usage for this API.
-				Messages:       messages,
-				DeploymentName: &modelDeploymentID,
-				MaxTokens:      &maxTokens,
-			}, nil)
+		// Several answers were asked for, let the user pick one to keep
+		picked, carryOn, err := PickChoice(completion)
+		RecordCompletion(cmd, question, messages, completion, picked, parentID)
+		if err != nil || !carryOn {
+			return err
+		}
 
+		var tools *ToolRegistry
+		if toolsFlag, _ := cmd.Flags().GetBool("tools"); toolsFlag {
+			tools, err = DefaultTools()
 			if err != nil {
-				// TODO: Update with application specific error handling logic
-				log.Printf("ERROR: %s", err)
-				return
+				return err
 			}
-
-			for _, choice := range resp.Choices {
-				gotReply = true
-
-				if choice.ContentFilterResults != nil {
-					fmt.Fprintf(os.Stderr, "Content filter results\n")
-
-					if choice.ContentFilterResults.Error != nil {
-						fmt.Fprintf(os.Stderr, "  Error:%v\n", choice.ContentFilterResults.Error)
-					}
-
-					fmt.Fprintf(os.Stderr, "  Hate: sev: %v, filtered: %v\n", *choice.ContentFilterResults.Hate.Severity, *choice.ContentFilterResults.Hate.Filtered)
-					fmt.Fprintf(os.Stderr, "  SelfHarm: sev: %v, filtered: %v\n", *choice.ContentFilterResults.SelfHarm.Severity, *choice.ContentFilterResults.SelfHarm.Filtered)
-					fmt.Fprintf(os.Stderr, "  Sexual: sev: %v, filtered: %v\n", *choice.ContentFilterResults.Sexual.Severity, *choice.ContentFilterResults.Sexual.Filtered)
-					fmt.Fprintf(os.Stderr, "  Violence: sev: %v, filtered: %v\n", *choice.ContentFilterResults.Violence.Severity, *choice.ContentFilterResults.Violence.Filtered)
-				}
-
-				if choice.Message != nil && choice.Message.Content != nil {
-					fmt.Fprintf(os.Stderr, "Content[%d]: %s\n", *choice.Index, *choice.Message.Content)
-				}
-
-				if choice.FinishReason != nil {
-					// this choice's conversation is complete.
-					fmt.Fprintf(os.Stderr, "Finish reason[%d]: %s\n", *choice.Index, *choice.FinishReason)
-				}
-			}
-
-			if gotReply {
-				fmt.Fprintf(os.Stderr, "Received chat completions reply\n")
+		}
+		conversation := &Conversation{Budget: defaultContextBudget}
+		for _, message := range messages {
+			if message.Role == RoleSystem {
+				conversation.System = message.Content
+			} else {
+				conversation.Messages = append(conversation.Messages, message)
 			}
 		}
+		conversation.Add(RoleAssistant, completion.Choices[picked].Content)
+		opts.N = 0
+		return RunChat(cmd, provider, tools, conversation, opts)
 	},
 }
 
@@ -149,7 +118,14 @@ func init() {
 	rootCmd.AddCommand(questionCmd)
 
 	// Add local flag to question command
-	questionCmd.Flags().BoolP("local", "l", false, "Use local model")
+	questionCmd.Flags().BoolP("local", "l", false, "Use local model (same as --provider ollama)")
+	questionCmd.Flags().BoolP("stream", "s", false, "Stream the answer as it is generated")
+	questionCmd.Flags().Int32("max-tokens", 400, "Maximum number of tokens in the answer, checked against the model's context window")
+	questionCmd.Flags().Bool("tools", false, "Let the model call the built-in tools (weather, time)")
+	questionCmd.Flags().Int("continue", 0, "Continue the conversation from a history entry, see `go-cli-gpt history list`")
+	addTemplateFlags(questionCmd, "question")
+	addSystemFlags(questionCmd)
+	addSamplingFlags(questionCmd)
 
 	// Here you will define your flags and configuration settings.
 
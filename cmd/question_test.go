@@ -0,0 +1,156 @@
+package cmd
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestQuestion(t *testing.T) {
+	tests := []struct {
+		name      string
+		args      []string
+		stdin     string
+		route     string
+		responses []fakeResponse
+		// sent is the user message the server should receive
+		sent       string
+		wantStdout []string
+		wantStderr []string
+		wantCode   int
+	}{
+		{
+			name:       "answer from azure",
+			args:       []string{"question", "What is Go?"},
+			route:      routeAzureChat,
+			responses:  []fakeResponse{azureChat("Go is a programming language.")},
+			sent:       "What is Go?",
+			wantStdout: []string{"Go is a programming language.\n"},
+			wantStderr: []string{"Finish reason[0]: stop"},
+		},
+		{
+			name:       "arguments and piped stdin are combined",
+			args:       []string{"question", "review this"},
+			stdin:      "diff --git a/main.go b/main.go\n",
+			route:      routeAzureChat,
+			responses:  []fakeResponse{azureChat("Looks good.")},
+			sent:       "review this\n\ndiff --git a/main.go b/main.go",
+			wantStdout: []string{"Looks good.\n"},
+		},
+		{
+			name:       "answer from ollama",
+			args:       []string{"question", "--local", "What is Go?"},
+			route:      routeOllamaChat,
+			responses:  []fakeResponse{ollamaChat("A language from Google.")},
+			sent:       "What is Go?",
+			wantStdout: []string{"A language from Google.\n"},
+		},
+		{
+			name:       "tools with ollama",
+			args:       []string{"question", "--local", "--tools", "What is the weather in London?"},
+			wantStderr: []string{"Error: tools are not supported by the ollama provider", "Hint: use --provider azure"},
+			wantCode:   int(KindUsage),
+		},
+		{
+			name:       "streamed answer",
+			args:       []string{"question", "--stream", "Say hello"},
+			route:      routeAzureChat,
+			responses:  []fakeResponse{azureChatStream("Hel", "lo", " world")},
+			sent:       "Say hello",
+			wantStdout: []string{"Hello world\n"},
+			wantStderr: []string{"Finish reason[0]: stop"},
+		},
+		{
+			name:       "streamed answer cancelled with ctrl-c",
+			args:       []string{"question", "--stream", "Say hello"},
+			route:      routeAzureChat,
+			responses:  []fakeResponse{interrupted(azureChatStream("Hel", "lo"))},
+			sent:       "Say hello",
+			wantStderr: []string{"Error: cancelled"},
+			wantCode:   int(KindInterrupted),
+		},
+		{
+			name:       "json output",
+			args:       []string{"question", "--output", "json", "What is Go?"},
+			route:      routeAzureChat,
+			responses:  []fakeResponse{azureChat("Go is a programming language.")},
+			sent:       "What is Go?",
+			wantStdout: []string{`"command":"question"`, `"content":"Go is a programming language."`, `"total_tokens":17`},
+		},
+		{
+			name:       "content filtered",
+			args:       []string{"question", "something hateful"},
+			route:      routeAzureChat,
+			responses:  []fakeResponse{azureFiltered()},
+			sent:       "something hateful",
+			wantStderr: []string{"Finish reason[0]: content_filter", "Error: the answer was blocked by the content filter: Hate (severity high)"},
+			wantCode:   int(KindContentFiltered),
+		},
+		{
+			name:       "api error",
+			args:       []string{"question", "What is Go?"},
+			route:      routeAzureChat,
+			responses:  []fakeResponse{{Status: http.StatusUnauthorized, Body: azureError("401", "Access denied due to invalid subscription key")}},
+			sent:       "What is Go?",
+			wantStderr: []string{"Error: Azure OpenAI returned 401: Access denied due to invalid subscription key", "Hint: check that AZURE_OPENAI_API_KEY"},
+			wantCode:   int(KindAuthFailed),
+		},
+		{
+			name:       "no question",
+			args:       []string{"question"},
+			wantStderr: []string{"Error: no question given", "Hint: pass the question as arguments"},
+			wantCode:   int(KindUsage),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := newFakeServer(t)
+			server.Queue(test.route, test.responses...)
+
+			stdout, stderr, code := runCommandWithCode(t, server, test.stdin, test.args...)
+
+			if code != test.wantCode {
+				t.Errorf("exit code %d, want %d", code, test.wantCode)
+			}
+
+			for _, want := range test.wantStdout {
+				if !strings.Contains(stdout, want) {
+					t.Errorf("stdout %q does not contain %q", stdout, want)
+				}
+			}
+			for _, want := range test.wantStderr {
+				if !strings.Contains(stderr, want) {
+					t.Errorf("stderr %q does not contain %q", stderr, want)
+				}
+			}
+
+			if test.route == "" {
+				return
+			}
+			requests := server.Requests(test.route)
+			if len(requests) != 1 {
+				t.Fatalf("got %d requests, want 1", len(requests))
+			}
+			if got := lastMessage(t, requests[0]); got != test.sent {
+				t.Errorf("sent %q, want %q", got, test.sent)
+			}
+		})
+	}
+}
+
+func TestQuestionJSONIsOneObjectPerLine(t *testing.T) {
+	server := newFakeServer(t)
+	server.Queue(routeAzureChat, azureChat("Forty-two."))
+
+	stdout, _ := runCommand(t, server, "", "question", "-o", "json", "What is the answer?")
+
+	var result Result
+	if err := json.Unmarshal([]byte(stdout), &result); err != nil {
+		t.Fatalf("stdout is not a JSON object: %s\n%s", err, stdout)
+	}
+	if result.Content != "Forty-two." || result.FinishReason != "stop" || result.Model != "gpt-test" {
+		t.Errorf("unexpected result %+v", result)
+	}
+}
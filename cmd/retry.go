@@ -0,0 +1,180 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// RetryPolicy decides how often and how long to wait before a throttled or
+// failed request to an LLM backend is sent again
+type RetryPolicy struct {
+	// MaxAttempts is the total number of tries, including the first one
+	MaxAttempts int
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+	// Log receives a line for every retry when set, e.g. under --verbose
+	Log io.Writer
+}
+
+// DefaultRetryPolicy is used until the flags and configuration have been read
+var DefaultRetryPolicy = RetryPolicy{MaxAttempts: 4, BaseDelay: 500 * time.Millisecond, MaxDelay: 30 * time.Second}
+
+// activeRetryPolicy is the policy selected with --max-attempts, MAX_ATTEMPTS and --verbose
+var activeRetryPolicy = DefaultRetryPolicy
+
+// retryableStatus reports whether a response status is worth retrying
+func retryableStatus(status int) bool {
+	switch status {
+	case http.StatusTooManyRequests, http.StatusRequestTimeout,
+		http.StatusInternalServerError, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// Delay returns how long to wait before the given retry (1 for the first
+// retry). The server's retry-after-ms or Retry-After header wins when present,
+// otherwise the delay doubles with every attempt with random jitter added.
+func (p RetryPolicy) Delay(retry int, resp *http.Response) time.Duration {
+	if delay, ok := retryAfter(resp); ok {
+		return min(delay, p.MaxDelay)
+	}
+
+	delay := p.BaseDelay << (retry - 1)
+	if delay <= 0 || delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+	// Equal jitter keeps at least half the delay so clients still back off
+	half := delay / 2
+	return half + rand.N(half+1)
+}
+
+// retryAfter reads the delay requested by the server. Azure OpenAI sends
+// retry-after-ms, other servers send Retry-After as seconds or an HTTP date.
+func retryAfter(resp *http.Response) (time.Duration, bool) {
+	if resp == nil {
+		return 0, false
+	}
+	if ms, err := strconv.ParseInt(resp.Header.Get("retry-after-ms"), 10, 64); err == nil && ms >= 0 {
+		return time.Duration(ms) * time.Millisecond, true
+	}
+
+	value := resp.Header.Get("Retry-After")
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.ParseInt(value, 10, 64); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		return max(time.Until(date), 0), true
+	}
+	return 0, false
+}
+
+// retryTransport resends requests that failed with a network error or a
+// retryable status, following a RetryPolicy
+type retryTransport struct {
+	next   http.RoundTripper
+	policy RetryPolicy
+}
+
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// The body is read once so it can be sent again with every attempt
+	var body []byte
+	if req.Body != nil {
+		var err error
+		body, err = io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for attempt := 1; ; attempt++ {
+		attemptReq := req.Clone(req.Context())
+		if body != nil {
+			attemptReq.Body = io.NopCloser(bytes.NewReader(body))
+		}
+
+		resp, err := t.next.RoundTrip(attemptReq)
+		if attempt >= t.policy.MaxAttempts || req.Context().Err() != nil {
+			return resp, err
+		}
+
+		var reason string
+		switch {
+		case err != nil:
+			reason = err.Error()
+		case retryableStatus(resp.StatusCode):
+			reason = resp.Status
+		default:
+			return resp, nil
+		}
+
+		delay := t.policy.Delay(attempt, resp)
+		if resp != nil {
+			// Drain the body so the connection can be reused
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		}
+		if t.policy.Log != nil {
+			fmt.Fprintf(t.policy.Log, "Retrying %s %s after %s (attempt %d of %d, waiting %s)\n",
+				req.Method, req.URL.Path, reason, attempt+1, t.policy.MaxAttempts, delay.Round(time.Millisecond))
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-req.Context().Done():
+			timer.Stop()
+			return nil, req.Context().Err()
+		}
+	}
+}
+
+// retryingHTTPClient wraps httpClient with the active retry policy, for
+// requests to the LLM backends
+func retryingHTTPClient() *http.Client {
+	next := httpClient.Transport
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	client := *httpClient
+	client.Transport = &retryTransport{next: next, policy: activeRetryPolicy}
+	return &client
+}
+
+// configureRetries sets the active retry policy from --max-attempts, the
+// MAX_ATTEMPTS setting and --verbose
+func configureRetries(cmd *cobra.Command) error {
+	policy := DefaultRetryPolicy
+
+	if value := activeConfig.Values["MAX_ATTEMPTS"]; value != "" {
+		attempts, err := strconv.Atoi(value)
+		if err != nil {
+			return Errorf(KindUsage, "MAX_ATTEMPTS must be a number, got %q", value)
+		}
+		policy.MaxAttempts = attempts
+	}
+	if flag := cmd.Flags().Lookup("max-attempts"); flag != nil && flag.Changed {
+		policy.MaxAttempts, _ = cmd.Flags().GetInt("max-attempts")
+	}
+	if policy.MaxAttempts < 1 {
+		return Errorf(KindUsage, "max attempts must be at least 1")
+	}
+
+	if verbose, _ := cmd.Flags().GetBool("verbose"); verbose {
+		policy.Log = cmd.ErrOrStderr()
+	}
+
+	activeRetryPolicy = policy
+	return nil
+}
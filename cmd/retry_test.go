@@ -0,0 +1,131 @@
+package cmd
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRetryPolicyDelay(t *testing.T) {
+	policy := RetryPolicy{MaxAttempts: 4, BaseDelay: time.Second, MaxDelay: 10 * time.Second}
+
+	tests := []struct {
+		name     string
+		retry    int
+		header   http.Header
+		min, max time.Duration
+	}{
+		{name: "first retry", retry: 1, min: 500 * time.Millisecond, max: time.Second},
+		{name: "doubles", retry: 3, min: 2 * time.Second, max: 4 * time.Second},
+		{name: "capped", retry: 10, min: 5 * time.Second, max: 10 * time.Second},
+		{name: "retry-after-ms", retry: 1, header: http.Header{"Retry-After-Ms": {"1500"}}, min: 1500 * time.Millisecond, max: 1500 * time.Millisecond},
+		{name: "retry-after seconds", retry: 1, header: http.Header{"Retry-After": {"3"}}, min: 3 * time.Second, max: 3 * time.Second},
+		{name: "retry-after capped", retry: 1, header: http.Header{"Retry-After": {"120"}}, min: 10 * time.Second, max: 10 * time.Second},
+		{name: "retry-after date in the past", retry: 1, header: http.Header{"Retry-After": {"Wed, 21 Oct 2015 07:28:00 GMT"}}, min: 0, max: 0},
+		{name: "invalid retry-after", retry: 1, header: http.Header{"Retry-After": {"soon"}}, min: 500 * time.Millisecond, max: time.Second},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var resp *http.Response
+			if test.header != nil {
+				resp = &http.Response{Header: test.header}
+			}
+			for i := 0; i < 20; i++ {
+				if delay := policy.Delay(test.retry, resp); delay < test.min || delay > test.max {
+					t.Fatalf("delay %s outside [%s, %s]", delay, test.min, test.max)
+				}
+			}
+		})
+	}
+}
+
+func TestRetries(t *testing.T) {
+	throttled := fakeResponse{
+		Status: http.StatusTooManyRequests,
+		Header: http.Header{"Retry-After-Ms": {"1"}},
+		Body:   azureError("429", "Requests to the ChatCompletions_Create Operation have exceeded call rate limit"),
+	}
+	unavailable := fakeResponse{Status: http.StatusServiceUnavailable, Header: http.Header{"Retry-After": {"0"}}, Body: map[string]any{"error": "overloaded"}}
+
+	tests := []struct {
+		name         string
+		args         []string
+		route        string
+		responses    []fakeResponse
+		wantRequests int
+		wantStdout   string
+		wantStderr   []string
+		notStderr    []string
+	}{
+		{
+			name:         "throttled then answered",
+			args:         []string{"question", "What is Go?"},
+			route:        routeAzureChat,
+			responses:    []fakeResponse{throttled, throttled, azureChat("A language.")},
+			wantRequests: 3,
+			wantStdout:   "A language.\n",
+			notStderr:    []string{"Retrying"},
+		},
+		{
+			name:         "retries shown with verbose",
+			args:         []string{"question", "--verbose", "What is Go?"},
+			route:        routeAzureChat,
+			responses:    []fakeResponse{throttled, azureChat("A language.")},
+			wantRequests: 2,
+			wantStderr:   []string{"Retrying POST /openai/deployments/gpt-test/chat/completions after 429 Too Many Requests (attempt 2 of 4, waiting 1ms)"},
+		},
+		{
+			name:         "gives up after max attempts",
+			args:         []string{"translate", "--max-attempts", "2", "--to", "English", "Bonjour"},
+			route:        routeAzureChat,
+			responses:    []fakeResponse{throttled, throttled},
+			wantRequests: 2,
+			wantStderr:   []string{"Error: Azure OpenAI returned 429:", "exceeded call rate limit", "Hint: the service is rate limiting you"},
+		},
+		{
+			name:         "client errors are not retried",
+			args:         []string{"question", "What is Go?"},
+			route:        routeAzureChat,
+			responses:    []fakeResponse{{Status: http.StatusBadRequest, Body: azureError("400", "Invalid request")}},
+			wantRequests: 1,
+			wantStderr:   []string{"Invalid request"},
+		},
+		{
+			name:         "ollama retried",
+			args:         []string{"question", "--local", "-v", "What is Go?"},
+			route:        routeOllamaChat,
+			responses:    []fakeResponse{unavailable, ollamaChat("A language.")},
+			wantRequests: 2,
+			wantStdout:   "A language.\n",
+			wantStderr:   []string{"Retrying POST /api/chat after 503 Service Unavailable"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := newFakeServer(t)
+			server.Queue(test.route, test.responses...)
+
+			stdout, stderr := runCommand(t, server, "", test.args...)
+
+			if test.wantStdout != "" && stdout != test.wantStdout {
+				t.Errorf("stdout %q, want %q", stdout, test.wantStdout)
+			}
+			for _, want := range test.wantStderr {
+				if !strings.Contains(stderr, want) {
+					t.Errorf("stderr %q does not contain %q", stderr, want)
+				}
+			}
+			for _, unwanted := range test.notStderr {
+				if strings.Contains(stderr, unwanted) {
+					t.Errorf("stderr %q contains %q", stderr, unwanted)
+				}
+			}
+			if got := len(server.Requests(test.route)); got != test.wantRequests {
+				t.Errorf("got %d requests, want %d", got, test.wantRequests)
+			}
+		})
+	}
+}
@@ -20,14 +20,35 @@ to the name of your project and you are good to go!`,
 	// Uncomment the following line if your bare application
 	// has an action associated with it:
 	// Run: func(cmd *cobra.Command, args []string) { },
+
+	// Load the layered configuration and check the global flags before any command runs
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		if err := validateOutputFormat(cmd); err != nil {
+			return err
+		}
+		if err := validateLayout(cmd); err != nil {
+			return err
+		}
+		if err := loadConfigForCommand(cmd, args); err != nil {
+			return err
+		}
+		return configureRetries(cmd)
+	},
+
+	// Configuration and API errors are not usage mistakes, and errors are
+	// printed with their hint by Execute
+	SilenceUsage:  true,
+	SilenceErrors: true,
 }
 
 // Execute adds all child commands to the root command and sets flags appropriately.
 // This is called by main.main(). It only needs to happen once to the rootCmd.
+// The exit code tells scripts what kind of error stopped the command, see ErrorKind.
 func Execute() {
 	err := rootCmd.Execute()
 	if err != nil {
-		os.Exit(1)
+		PrintError(rootCmd.ErrOrStderr(), err)
+		os.Exit(ExitCode(err))
 	}
 }
 
@@ -36,9 +57,28 @@ func init() {
 	// Cobra supports persistent flags, which, if defined here,
 	// will be global for your application.
 
-	// rootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "config file (default is $HOME/.go-cli-template.yaml)")
+	rootCmd.PersistentFlags().String("config", "", "config file (default is $HOME/.config/go-cli-gpt/config.yaml)")
+	rootCmd.PersistentFlags().String("profile", "", "named profile from the config file to use (default is $GO_CLI_GPT_PROFILE or the file's profile key)")
+
+	// Select the LLM backend used by the chat based commands.
+	// Falls back to the LLM_PROVIDER environment variable and then "azure".
+	rootCmd.PersistentFlags().String("provider", "azure", "LLM provider to use (azure, ollama)")
+	rootCmd.PersistentFlags().StringP("model", "m", "", "model to use, the Azure deployment name or an Ollama model (skips the local model picker)")
+
+	rootCmd.PersistentFlags().StringP("output", "o", OutputText, "output format (text, json, markdown)")
+	rootCmd.PersistentFlags().String("layout", LayoutAuto, "how several answers are shown in text output (auto, numbered, side-by-side)")
+	rootCmd.PersistentFlags().Bool("show-filters", false, "print the content filter verdicts for the prompt and every answer")
+
+	// Throttled (429) and failed (5xx) requests are retried with backoff
+	rootCmd.PersistentFlags().Int("max-attempts", 0, "attempts per request before giving up on throttling or server errors (default is $MAX_ATTEMPTS or 4)")
+	rootCmd.PersistentFlags().BoolP("verbose", "v", false, "print retries and other diagnostics to stderr")
+	rootCmd.PersistentFlags().Bool("no-history", false, "do not record this exchange in the history")
 
 	// Cobra also supports local flags, which will only run
 	// when this action is called directly.
 	rootCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
+
+	rootCmd.SetFlagErrorFunc(func(cmd *cobra.Command, err error) error {
+		return NewError(KindUsage, err)
+	})
 }
@@ -0,0 +1,108 @@
+package cmd
+
+import (
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// maxStopSequences is the most stop sequences the OpenAI API accepts
+const maxStopSequences = 4
+
+// maxChoices keeps --choices to a number of answers that can still be compared
+const maxChoices = 10
+
+// addSamplingFlags adds the flags that tune how a chat command's answer is
+// sampled. Each one can also be set in the configuration, e.g. TOP_P.
+func addSamplingFlags(cmd *cobra.Command) {
+	cmd.Flags().Float32("temperature", 0, "Sampling temperature between 0 and 2, higher is more random (default is $TEMPERATURE or the model's)")
+	cmd.Flags().Float32("top-p", 0, "Nucleus sampling, only consider tokens in the top p probability mass (default is $TOP_P or the model's)")
+	cmd.Flags().Float32("presence-penalty", 0, "Penalty between -2 and 2 for tokens that already appeared, encouraging new topics (default is $PRESENCE_PENALTY)")
+	cmd.Flags().Float32("frequency-penalty", 0, "Penalty between -2 and 2 for tokens by how often they appeared, discouraging repetition (default is $FREQUENCY_PENALTY)")
+	cmd.Flags().StringArray("stop", nil, "Stop generating at this sequence, can be repeated up to 4 times (default is $STOP, comma separated)")
+	cmd.Flags().Int64("seed", 0, "Seed for best effort deterministic sampling (default is $SEED)")
+	cmd.Flags().Int32("choices", 0, "Number of answers to generate (default is $CHOICES or 1)")
+}
+
+// GetSamplingOptions sets the sampling parameters of opts from the flags,
+// or the configuration when a flag is not given. Parameters set in neither
+// keep the value the command chose, and otherwise the model's default.
+func GetSamplingOptions(cmd *cobra.Command, opts CompletionOptions) (CompletionOptions, error) {
+	var err error
+	if opts.Temperature, err = floatSetting(cmd, "temperature", "TEMPERATURE", 0, 2, opts.Temperature); err != nil {
+		return opts, err
+	}
+	if opts.TopP, err = floatSetting(cmd, "top-p", "TOP_P", 0, 1, opts.TopP); err != nil {
+		return opts, err
+	}
+	if opts.PresencePenalty, err = floatSetting(cmd, "presence-penalty", "PRESENCE_PENALTY", -2, 2, opts.PresencePenalty); err != nil {
+		return opts, err
+	}
+	if opts.FrequencyPenalty, err = floatSetting(cmd, "frequency-penalty", "FREQUENCY_PENALTY", -2, 2, opts.FrequencyPenalty); err != nil {
+		return opts, err
+	}
+
+	switch {
+	case cmd.Flags().Changed("stop"):
+		opts.Stop, _ = cmd.Flags().GetStringArray("stop")
+	case os.Getenv("STOP") != "":
+		opts.Stop = strings.Split(os.Getenv("STOP"), ",")
+	}
+	if len(opts.Stop) > maxStopSequences {
+		return opts, Errorf(KindUsage, "at most %d stop sequences can be given, got %d", maxStopSequences, len(opts.Stop))
+	}
+
+	switch {
+	case cmd.Flags().Changed("seed"):
+		seed, _ := cmd.Flags().GetInt64("seed")
+		opts.Seed = &seed
+	case os.Getenv("SEED") != "":
+		seed, err := strconv.ParseInt(os.Getenv("SEED"), 10, 64)
+		if err != nil {
+			return opts, Errorf(KindUsage, "SEED must be a whole number, got %q", os.Getenv("SEED"))
+		}
+		opts.Seed = &seed
+	}
+
+	switch {
+	case cmd.Flags().Changed("choices"):
+		opts.N, _ = cmd.Flags().GetInt32("choices")
+	case os.Getenv("CHOICES") != "":
+		n, err := strconv.ParseInt(os.Getenv("CHOICES"), 10, 32)
+		if err != nil {
+			return opts, Errorf(KindUsage, "CHOICES must be a whole number, got %q", os.Getenv("CHOICES"))
+		}
+		opts.N = int32(n)
+	}
+	if opts.N != 0 && (opts.N < 1 || opts.N > maxChoices) {
+		return opts, Errorf(KindUsage, "--choices must be between 1 and %d, got %d", maxChoices, opts.N)
+	}
+
+	return opts, nil
+}
+
+// floatSetting reads a float flag, or its setting when the flag is not
+// given, and checks it is within [min, max]. fallback is returned when
+// neither is set.
+func floatSetting(cmd *cobra.Command, flag, setting string, min, max float32, fallback *float32) (*float32, error) {
+	var value float32
+	switch {
+	case cmd.Flags().Changed(flag):
+		value, _ = cmd.Flags().GetFloat32(flag)
+	case os.Getenv(setting) != "":
+		parsed, err := strconv.ParseFloat(os.Getenv(setting), 32)
+		if err != nil {
+			return nil, Errorf(KindUsage, "%s must be a number, got %q", setting, os.Getenv(setting))
+		}
+		value = float32(parsed)
+	default:
+		return fallback, nil
+	}
+
+	if value < min || value > max {
+		return nil, Errorf(KindUsage, "--%s must be between %g and %g, got %g", flag, min, max, value)
+	}
+	return &value, nil
+}
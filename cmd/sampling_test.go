@@ -0,0 +1,159 @@
+package cmd
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestSamplingOptions(t *testing.T) {
+	tests := []struct {
+		name  string
+		args  []string
+		env   map[string]string
+		route string
+		// want holds the fields expected in the request, under "options" for Ollama
+		want       map[string]any
+		absent     []string
+		wantStderr string
+		wantCode   int
+	}{
+		{
+			name:   "model defaults",
+			args:   []string{"question", "What is Go?"},
+			absent: []string{"temperature", "top_p", "presence_penalty", "frequency_penalty", "stop", "seed", "n"},
+		},
+		{
+			name: "azure flags",
+			args: []string{"question", "--temperature", "0.7", "--top-p", "0.9", "--presence-penalty", "0.5", "--frequency-penalty", "-0.5", "--stop", "END", "--stop", "###", "--seed", "42", "--choices", "3", "What is Go?"},
+			want: map[string]any{"temperature": 0.7, "top_p": 0.9, "presence_penalty": 0.5, "frequency_penalty": -0.5, "stop": []any{"END", "###"}, "seed": 42.0, "n": 3.0},
+		},
+		{
+			name: "configuration",
+			args: []string{"translate", "--to", "French", "Hello"},
+			env:  map[string]string{"TEMPERATURE": "0.2", "STOP": "END,STOP", "SEED": "7"},
+			want: map[string]any{"temperature": 0.2, "stop": []any{"END", "STOP"}, "seed": 7.0},
+		},
+		{
+			name: "flag wins over configuration",
+			args: []string{"question", "--temperature", "1.5", "What is Go?"},
+			env:  map[string]string{"TEMPERATURE": "0.2"},
+			want: map[string]any{"temperature": 1.5},
+		},
+		{
+			name: "get-weather keeps temperature 0 by default",
+			args: []string{"get-weather", "--top-p", "0.5", "Paris"},
+			want: map[string]any{"temperature": 0.0, "top_p": 0.5},
+		},
+		{
+			name:       "ollama options",
+			args:       []string{"question", "--local", "--temperature", "0.7", "--top-p", "0.9", "--stop", "END", "--seed", "42", "--choices", "2", "What is Go?"},
+			route:      routeOllamaChat,
+			want:       map[string]any{"temperature": 0.7, "top_p": 0.9, "stop": []any{"END"}, "seed": 42.0},
+			wantStderr: "Warning: the ollama provider does not support more than one choice, ignoring it",
+		},
+		{
+			name:   "ollama leaves the temperature to the model",
+			args:   []string{"question", "--local", "What is Go?"},
+			route:  routeOllamaChat,
+			absent: []string{"temperature", "top_p", "seed"},
+		},
+		{
+			name:  "ollama temperature 0",
+			args:  []string{"question", "--local", "--temperature", "0", "What is Go?"},
+			route: routeOllamaChat,
+			want:  map[string]any{"temperature": 0.0},
+		},
+		{
+			name:  "ollama temperature from configuration",
+			args:  []string{"question", "--local", "What is Go?"},
+			env:   map[string]string{"TEMPERATURE": "0.3"},
+			route: routeOllamaChat,
+			want:  map[string]any{"temperature": 0.3},
+		},
+		{
+			name:       "temperature out of range",
+			args:       []string{"question", "--temperature", "3", "What is Go?"},
+			wantStderr: "Error: --temperature must be between 0 and 2, got 3",
+			wantCode:   int(KindUsage),
+		},
+		{
+			name:       "invalid setting",
+			args:       []string{"question", "What is Go?"},
+			env:        map[string]string{"TOP_P": "high"},
+			wantStderr: `Error: TOP_P must be a number, got "high"`,
+			wantCode:   int(KindUsage),
+		},
+		{
+			name:       "too many stop sequences",
+			args:       []string{"question", "--stop", "a", "--stop", "b", "--stop", "c", "--stop", "d", "--stop", "e", "What is Go?"},
+			wantStderr: "Error: at most 4 stop sequences can be given, got 5",
+			wantCode:   int(KindUsage),
+		},
+		{
+			name:       "too many choices",
+			args:       []string{"question", "--choices", "11", "What is Go?"},
+			wantStderr: "Error: --choices must be between 1 and 10, got 11",
+			wantCode:   int(KindUsage),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := newFakeServer(t)
+			for key, value := range test.env {
+				server.Env[key] = value
+			}
+			route := test.route
+			if route == "" {
+				route = routeAzureChat
+			}
+			if route == routeOllamaChat {
+				server.Queue(route, ollamaChat("Done."))
+			} else {
+				server.Queue(route, azureChat("Done."))
+			}
+
+			_, stderr, code := runCommandWithCode(t, server, "", test.args...)
+			if code != test.wantCode {
+				t.Errorf("exit code %d, want %d", code, test.wantCode)
+			}
+			if !strings.Contains(stderr, test.wantStderr) {
+				t.Errorf("stderr %q does not contain %q", stderr, test.wantStderr)
+			}
+
+			requests := server.Requests(route)
+			if test.wantCode != 0 {
+				if len(requests) != 0 {
+					t.Errorf("got %d requests, want none", len(requests))
+				}
+				return
+			}
+			if len(requests) == 0 {
+				t.Fatal("no request sent")
+			}
+			fields := requests[0]
+			if route == routeOllamaChat {
+				fields = fields["options"].(map[string]any)
+			}
+			for key, want := range test.want {
+				got, _ := json.Marshal(fields[key])
+				wantJSON, _ := json.Marshal(want)
+				// Floats are sent as float32, compare them at that precision
+				if f, ok := want.(float64); ok {
+					if g, ok := fields[key].(float64); ok && float32(g) == float32(f) {
+						continue
+					}
+				}
+				if string(got) != string(wantJSON) {
+					t.Errorf("%s is %s, want %s", key, got, wantJSON)
+				}
+			}
+			for _, key := range test.absent {
+				if _, ok := fields[key]; ok {
+					t.Errorf("%s is set to %v, want it left out", key, fields[key])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,115 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// ValidateArguments checks the JSON arguments of a tool call against the
+// JSON schema the tool was declared with. Only the parts of JSON schema used
+// by our tool definitions are supported: object properties, required fields,
+// primitive types and enums.
+func ValidateArguments(schema map[string]any, arguments string) error {
+	var value any
+	if err := json.Unmarshal([]byte(arguments), &value); err != nil {
+		return fmt.Errorf("arguments are not valid JSON: %w", err)
+	}
+	return validateValue("arguments", schema, value)
+}
+
+func validateValue(path string, schema map[string]any, value any) error {
+	if schemaType, ok := schema["type"].(string); ok {
+		if !matchesType(schemaType, value) {
+			return fmt.Errorf("%s must be of type %s", path, schemaType)
+		}
+	}
+
+	if enum := toStrings(schema["enum"]); len(enum) > 0 {
+		s, _ := value.(string)
+		if !contains(enum, s) {
+			return fmt.Errorf("%s must be one of %s", path, strings.Join(enum, ", "))
+		}
+	}
+
+	object, ok := value.(map[string]any)
+	if !ok {
+		return nil
+	}
+
+	for _, name := range toStrings(schema["required"]) {
+		if _, ok := object[name]; !ok {
+			return fmt.Errorf("%s.%s is required", path, name)
+		}
+	}
+
+	properties, _ := schema["properties"].(map[string]any)
+	names := make([]string, 0, len(object))
+	for name := range object {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		propertySchema, ok := properties[name].(map[string]any)
+		if !ok {
+			continue
+		}
+		if err := validateValue(path+"."+name, propertySchema, object[name]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func matchesType(schemaType string, value any) bool {
+	switch schemaType {
+	case "object":
+		_, ok := value.(map[string]any)
+		return ok
+	case "array":
+		_, ok := value.([]any)
+		return ok
+	case "string":
+		_, ok := value.(string)
+		return ok
+	case "number":
+		_, ok := value.(float64)
+		return ok
+	case "integer":
+		f, ok := value.(float64)
+		return ok && f == float64(int64(f))
+	case "boolean":
+		_, ok := value.(bool)
+		return ok
+	case "null":
+		return value == nil
+	}
+	return true
+}
+
+// toStrings accepts both []string, as written in Go literals, and []any, as decoded from JSON
+func toStrings(v any) []string {
+	switch values := v.(type) {
+	case []string:
+		return values
+	case []any:
+		strs := make([]string, 0, len(values))
+		for _, value := range values {
+			if s, ok := value.(string); ok {
+				strs = append(strs, s)
+			}
+		}
+		return strs
+	}
+	return nil
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
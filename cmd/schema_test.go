@@ -0,0 +1,93 @@
+package cmd
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// forecastParams exercises every kind of field SchemaFor handles
+type forecastParams struct {
+	Location string   `json:"location" description:"City name"`
+	Unit     string   `json:"unit,omitempty" enum:"celsius,fahrenheit"`
+	Days     int      `json:"days"`
+	Detailed bool     `json:"detailed,omitempty"`
+	Hours    []int    `json:"hours,omitempty"`
+	Scale    *float64 `json:"scale,omitempty"`
+	Ignored  string   `json:"-"`
+	internal string
+}
+
+func TestSchemaFor(t *testing.T) {
+	want := map[string]any{
+		"type": "object",
+		"properties": map[string]any{
+			"location": map[string]any{"type": "string", "description": "City name"},
+			"unit":     map[string]any{"type": "string", "enum": []string{"celsius", "fahrenheit"}},
+			"days":     map[string]any{"type": "integer"},
+			"detailed": map[string]any{"type": "boolean"},
+			"hours":    map[string]any{"type": "array", "items": map[string]any{"type": "integer"}},
+			"scale":    map[string]any{"type": "number"},
+		},
+		"required": []string{"location", "days"},
+	}
+
+	if got := SchemaFor[forecastParams](); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v\nwant %v", got, want)
+	}
+}
+
+func TestValidateArguments(t *testing.T) {
+	schema := SchemaFor[forecastParams]()
+
+	tests := []struct {
+		name      string
+		arguments string
+		// wantErr is empty when the arguments are valid
+		wantErr string
+	}{
+		{name: "required fields only", arguments: `{"location":"London","days":3}`},
+		{name: "every field", arguments: `{"location":"London","days":3,"unit":"celsius","detailed":true,"hours":[9,12],"scale":0.5}`},
+		{name: "unknown fields are allowed", arguments: `{"location":"London","days":3,"country":"UK"}`},
+		{name: "not JSON", arguments: `{"location":`, wantErr: "arguments are not valid JSON"},
+		{name: "not an object", arguments: `["London"]`, wantErr: "arguments must be of type object"},
+		{name: "missing required field", arguments: `{"location":"London"}`, wantErr: "arguments.days is required"},
+		{name: "string for an integer", arguments: `{"location":"London","days":"3"}`, wantErr: "arguments.days must be of type integer"},
+		{name: "fraction for an integer", arguments: `{"location":"London","days":1.5}`, wantErr: "arguments.days must be of type integer"},
+		{name: "number for a string", arguments: `{"location":42,"days":3}`, wantErr: "arguments.location must be of type string"},
+		{name: "string for a boolean", arguments: `{"location":"London","days":3,"detailed":"yes"}`, wantErr: "arguments.detailed must be of type boolean"},
+		{name: "object for an array", arguments: `{"location":"London","days":3,"hours":{}}`, wantErr: "arguments.hours must be of type array"},
+		{name: "value outside the enum", arguments: `{"location":"London","days":3,"unit":"kelvin"}`, wantErr: "arguments.unit must be one of celsius, fahrenheit"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := ValidateArguments(schema, test.arguments)
+			if test.wantErr == "" {
+				if err != nil {
+					t.Errorf("got error %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+				t.Errorf("got error %v, want %q", err, test.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateArgumentsDecodedSchema(t *testing.T) {
+	// A schema read from JSON has []any where Go literals have []string
+	schema := map[string]any{
+		"type":       "object",
+		"properties": map[string]any{"unit": map[string]any{"type": "string", "enum": []any{"celsius", "fahrenheit"}}},
+		"required":   []any{"unit"},
+	}
+
+	if err := ValidateArguments(schema, `{}`); err == nil || err.Error() != "arguments.unit is required" {
+		t.Errorf("got error %v, want the missing unit reported", err)
+	}
+	if err := ValidateArguments(schema, `{"unit":"kelvin"}`); err == nil || !strings.Contains(err.Error(), "must be one of") {
+		t.Errorf("got error %v, want the enum enforced", err)
+	}
+}
@@ -0,0 +1,384 @@
+package cmd
+
+import (
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"text/template"
+	"text/template/parse"
+
+	"github.com/spf13/cobra"
+)
+
+// builtinTemplates are the prompts the commands use unless --template says otherwise
+//
+//go:embed templates/*.tmpl
+var builtinTemplates embed.FS
+
+// templateExt is the extension of template files, built-in and user defined
+const templateExt = ".tmpl"
+
+// systemTemplate is the name of the block holding the system prompt
+const systemTemplate = "system"
+
+// PromptTemplate is a text/template file rendering the user message of a
+// request. The system prompt is given in a {{define "system"}} block.
+//
+// Variables are referenced as {{.name}} and come from the command (input,
+// from, to) and --var flags. Variables tested with {{if .name}} or
+// {{with .name}} are optional, every other one is required.
+type PromptTemplate struct {
+	Name string
+	// Source is the file the template was read from, or "built-in"
+	Source string
+	Text   string
+	tmpl   *template.Template
+}
+
+// TemplatesDir returns ~/.config/go-cli-gpt/templates, or TEMPLATES_DIR when set
+func TemplatesDir() string {
+	if dir := os.Getenv("TEMPLATES_DIR"); dir != "" {
+		return dir
+	}
+	return filepath.Join(filepath.Dir(DefaultConfigPath()), "templates")
+}
+
+// LoadTemplate finds a template by name, in the user's templates directory
+// first so built-ins can be overridden
+func LoadTemplate(name string) (*PromptTemplate, error) {
+	if err := checkTemplateName(name); err != nil {
+		return nil, err
+	}
+	path := filepath.Join(TemplatesDir(), name+templateExt)
+	data, err := os.ReadFile(path)
+	source := path
+	if errors.Is(err, fs.ErrNotExist) {
+		data, err = builtinTemplates.ReadFile("templates/" + name + templateExt)
+		source = "built-in"
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, &Error{Kind: KindUsage, Err: fmt.Errorf("template %q not found", name), Hint: "run `go-cli-gpt templates list` to see the available templates"}
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return ParseTemplate(name, source, string(data))
+}
+
+// checkTemplateName makes sure a template name is a plain file name, so it
+// cannot reach outside the templates directory
+func checkTemplateName(name string) error {
+	if name == "" || strings.ContainsAny(name, `/\`) || strings.HasPrefix(name, ".") || strings.Contains(name, "..") {
+		return &Error{Kind: KindUsage, Err: fmt.Errorf("invalid template name %q", name), Hint: "template names cannot contain path separators or start with a dot"}
+	}
+	return nil
+}
+
+// ParseTemplate parses the text of a template
+func ParseTemplate(name, source, text string) (*PromptTemplate, error) {
+	tmpl, err := template.New(name).Option("missingkey=zero").Parse(text)
+	if err != nil {
+		return nil, NewError(KindUsage, fmt.Errorf("parsing template %s: %w", source, err))
+	}
+	return &PromptTemplate{Name: name, Source: source, Text: text, tmpl: tmpl}, nil
+}
+
+// Variables returns the required and optional variables of the template, in
+// alphabetical order
+func (t *PromptTemplate) Variables() (required, optional []string) {
+	referenced := map[string]bool{}
+	tested := map[string]bool{}
+	for _, tmpl := range t.tmpl.Templates() {
+		if tmpl.Tree != nil {
+			collectFields(tmpl.Tree.Root, referenced, tested)
+		}
+	}
+
+	for name := range referenced {
+		if tested[name] {
+			optional = append(optional, name)
+		} else {
+			required = append(required, name)
+		}
+	}
+	sort.Strings(required)
+	sort.Strings(optional)
+	return required, optional
+}
+
+// Uses reports whether the template references a variable
+func (t *PromptTemplate) Uses(name string) bool {
+	required, optional := t.Variables()
+	return slices.Contains(required, name) || slices.Contains(optional, name)
+}
+
+// Requires reports whether a variable must be set to render the template
+func (t *PromptTemplate) Requires(name string) bool {
+	required, _ := t.Variables()
+	return slices.Contains(required, name)
+}
+
+// collectFields records the {{.name}} fields used under node, and the ones
+// used in the condition of an if or with
+func collectFields(node parse.Node, referenced, tested map[string]bool) {
+	switch node := node.(type) {
+	case *parse.ListNode:
+		if node == nil {
+			return
+		}
+		for _, child := range node.Nodes {
+			collectFields(child, referenced, tested)
+		}
+	case *parse.ActionNode:
+		collectFields(node.Pipe, referenced, tested)
+	case *parse.PipeNode:
+		if node == nil {
+			return
+		}
+		for _, command := range node.Cmds {
+			for _, arg := range command.Args {
+				collectFields(arg, referenced, tested)
+			}
+		}
+	case *parse.FieldNode:
+		referenced[node.Ident[0]] = true
+	case *parse.IfNode:
+		collectBranch(&node.BranchNode, referenced, tested)
+	case *parse.WithNode:
+		collectBranch(&node.BranchNode, referenced, tested)
+	case *parse.RangeNode:
+		collectBranch(&node.BranchNode, referenced, tested)
+	}
+}
+
+func collectBranch(node *parse.BranchNode, referenced, tested map[string]bool) {
+	collectFields(node.Pipe, referenced, tested)
+	collectFields(node.Pipe, tested, map[string]bool{})
+	collectFields(node.List, referenced, tested)
+	collectFields(node.ElseList, referenced, tested)
+}
+
+// Render fills in the template, returning the system prompt (empty when the
+// template has none) and the user message. Every required variable must be
+// set and not empty.
+func (t *PromptTemplate) Render(vars map[string]string) (system string, user string, err error) {
+	required, _ := t.Variables()
+	var missing []string
+	for _, name := range required {
+		if vars[name] == "" {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) > 0 {
+		return "", "", &Error{
+			Kind: KindUsage,
+			Err:  fmt.Errorf("template %q needs %s", t.Name, strings.Join(missing, ", ")),
+			Hint: "set the variables with --var name=value, see `go-cli-gpt templates show " + t.Name + "`",
+		}
+	}
+
+	var out strings.Builder
+	if t.tmpl.Lookup(systemTemplate) != nil {
+		if err := t.tmpl.ExecuteTemplate(&out, systemTemplate, vars); err != nil {
+			return "", "", NewError(KindUsage, err)
+		}
+		system = strings.TrimSpace(out.String())
+		out.Reset()
+	}
+	if err := t.tmpl.Execute(&out, vars); err != nil {
+		return "", "", NewError(KindUsage, err)
+	}
+	return system, strings.TrimSpace(out.String()), nil
+}
+
+// Messages renders the template into the system and user messages of a request
+func (t *PromptTemplate) Messages(vars map[string]string) ([]Message, error) {
+	system, user, err := t.Render(vars)
+	if err != nil {
+		return nil, err
+	}
+	var messages []Message
+	if system != "" {
+		messages = append(messages, Message{Role: RoleSystem, Content: system})
+	}
+	return append(messages, Message{Role: RoleUser, Content: user}), nil
+}
+
+// GetTemplate loads the template selected with --template, or fallback when
+// the flag is not set
+func GetTemplate(cmd *cobra.Command, fallback string) (*PromptTemplate, error) {
+	name, _ := cmd.Flags().GetString("template")
+	if name == "" {
+		name = fallback
+	}
+	return LoadTemplate(name)
+}
+
+// GetTemplateVars parses the --var name=value flags on top of the values the
+// command has already worked out
+func GetTemplateVars(cmd *cobra.Command, vars map[string]string) (map[string]string, error) {
+	merged := map[string]string{}
+	for name, value := range vars {
+		merged[name] = value
+	}
+
+	flags, _ := cmd.Flags().GetStringArray("var")
+	for _, flag := range flags {
+		name, value, ok := strings.Cut(flag, "=")
+		if !ok || name == "" {
+			return nil, Errorf(KindUsage, "invalid --var %q, expected name=value", flag)
+		}
+		merged[name] = value
+	}
+	return merged, nil
+}
+
+// addTemplateFlags adds --template and --var to a command that renders its prompt from a template
+func addTemplateFlags(cmd *cobra.Command, fallback string) {
+	cmd.Flags().String("template", "", fmt.Sprintf("Prompt template to use (default %q), see `go-cli-gpt templates list`", fallback))
+	cmd.Flags().StringArray("var", nil, "Template variable as name=value, can be repeated")
+}
+
+// ListTemplates returns every template, user defined ones replacing the
+// built-ins of the same name, sorted by name
+func ListTemplates() ([]*PromptTemplate, error) {
+	names := map[string]bool{}
+	builtins, err := fs.Glob(builtinTemplates, "templates/*"+templateExt)
+	if err != nil {
+		return nil, err
+	}
+	user, err := filepath.Glob(filepath.Join(TemplatesDir(), "*"+templateExt))
+	if err != nil {
+		return nil, err
+	}
+	for _, path := range append(builtins, user...) {
+		names[strings.TrimSuffix(filepath.Base(path), templateExt)] = true
+	}
+
+	var templates []*PromptTemplate
+	for name := range names {
+		tmpl, err := LoadTemplate(name)
+		if err != nil {
+			return nil, err
+		}
+		templates = append(templates, tmpl)
+	}
+	sort.Slice(templates, func(i, j int) bool { return templates[i].Name < templates[j].Name })
+	return templates, nil
+}
+
+// templatesCmd represents the templates command
+var templatesCmd = &cobra.Command{
+	Use:   "templates",
+	Short: "list, show and create prompt templates",
+	Long: `prompts are Go text/template files, the built-in ones can be overridden and new ones added
+in ~/.config/go-cli-gpt/templates (or TEMPLATES_DIR) as <name>.tmpl
+
+The file renders the user message, with the system prompt in a {{define "system"}} block.
+Variables are written {{.name}} and set with --var name=value, the input of the
+command is {{.input}}. Variables only used after {{if .name}} are optional.
+
+  go-cli-gpt question --template summarize --var length="three bullet points" < notes.txt`,
+}
+
+var templatesListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "list the available templates and their variables",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		templates, err := ListTemplates()
+		if err != nil {
+			return err
+		}
+		for _, tmpl := range templates {
+			fmt.Fprintf(cmd.OutOrStdout(), "%-16s %-40s %s\n", tmpl.Name, describeVariables(tmpl), tmpl.Source)
+		}
+		return nil
+	},
+}
+
+var templatesShowCmd = &cobra.Command{
+	Use:   "show <name>",
+	Short: "print a template and its variables",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		tmpl, err := LoadTemplate(args[0])
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(cmd.ErrOrStderr(), "# %s (%s), variables: %s\n", tmpl.Name, tmpl.Source, describeVariables(tmpl))
+		fmt.Fprint(cmd.OutOrStdout(), tmpl.Text)
+		return nil
+	},
+}
+
+var templatesNewCmd = &cobra.Command{
+	Use:   "new <name>",
+	Short: "create a template in the templates directory",
+	Long: `create <name>.tmpl in the templates directory, starting from an existing
+template with --from, and print its path so it can be edited`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		name := args[0]
+		if err := checkTemplateName(name); err != nil {
+			return err
+		}
+		path := filepath.Join(TemplatesDir(), name+templateExt)
+
+		text := "{{define \"system\"}}You are a helpful assistant.{{end -}}\n{{.input}}\n"
+		if from, _ := cmd.Flags().GetString("from"); from != "" {
+			tmpl, err := LoadTemplate(from)
+			if err != nil {
+				return err
+			}
+			text = tmpl.Text
+		}
+
+		if err := os.MkdirAll(TemplatesDir(), 0o755); err != nil {
+			return err
+		}
+		file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+		if errors.Is(err, fs.ErrExist) {
+			return &Error{Kind: KindUsage, Err: fmt.Errorf("template %s already exists", path), Hint: "edit the file, or delete it to start again"}
+		}
+		if err != nil {
+			return err
+		}
+		if _, err := file.WriteString(text); err != nil {
+			file.Close()
+			return err
+		}
+		if err := file.Close(); err != nil {
+			return err
+		}
+
+		fmt.Fprintln(cmd.OutOrStdout(), path)
+		return nil
+	},
+}
+
+// describeVariables lists the variables of a template, optional ones in brackets
+func describeVariables(tmpl *PromptTemplate) string {
+	required, optional := tmpl.Variables()
+	variables := append([]string{}, required...)
+	for _, name := range optional {
+		variables = append(variables, "["+name+"]")
+	}
+	if len(variables) == 0 {
+		return "-"
+	}
+	return strings.Join(variables, " ")
+}
+
+func init() {
+	rootCmd.AddCommand(templatesCmd)
+	templatesCmd.AddCommand(templatesListCmd, templatesShowCmd, templatesNewCmd)
+
+	templatesNewCmd.Flags().String("from", "", "template to copy")
+}
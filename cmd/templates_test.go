@@ -0,0 +1,238 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPromptTemplate(t *testing.T) {
+	tests := []struct {
+		name         string
+		text         string
+		vars         map[string]string
+		wantRequired []string
+		wantOptional []string
+		wantSystem   string
+		wantUser     string
+		wantErr      string
+	}{
+		{
+			name:         "system block and variables",
+			text:         "{{define \"system\"}}You speak {{.to}}.{{end -}}\nTranslate {{.input}} to {{.to}}\n",
+			vars:         map[string]string{"input": "hello", "to": "French"},
+			wantRequired: []string{"input", "to"},
+			wantSystem:   "You speak French.",
+			wantUser:     "Translate hello to French",
+		},
+		{
+			name:         "variables tested with if are optional",
+			text:         "Summarize{{if .length}} in {{.length}}{{end}}: {{.input}}",
+			vars:         map[string]string{"input": "notes"},
+			wantRequired: []string{"input"},
+			wantOptional: []string{"length"},
+			wantUser:     "Summarize: notes",
+		},
+		{
+			name:         "with and else branches",
+			text:         "{{with .tone}}Be {{.}}.{{else}}Be {{.fallback}}.{{end}}",
+			vars:         map[string]string{"fallback": "brief"},
+			wantRequired: []string{"fallback"},
+			wantOptional: []string{"tone"},
+			wantUser:     "Be brief.",
+		},
+		{
+			name:         "missing variables are all reported",
+			text:         "{{.a}} {{.b}} {{.c}}",
+			vars:         map[string]string{"b": "set"},
+			wantRequired: []string{"a", "b", "c"},
+			wantErr:      `template "test" needs a, c`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tmpl, err := ParseTemplate("test", "test", test.text)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			required, optional := tmpl.Variables()
+			if strings.Join(required, ",") != strings.Join(test.wantRequired, ",") || strings.Join(optional, ",") != strings.Join(test.wantOptional, ",") {
+				t.Errorf("got required %v optional %v, want %v and %v", required, optional, test.wantRequired, test.wantOptional)
+			}
+
+			system, user, err := tmpl.Render(test.vars)
+			if test.wantErr != "" {
+				if err == nil || err.Error() != test.wantErr || ExitCode(err) != int(KindUsage) {
+					t.Errorf("got error %v, want %q", err, test.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if system != test.wantSystem || user != test.wantUser {
+				t.Errorf("got system %q user %q, want %q and %q", system, user, test.wantSystem, test.wantUser)
+			}
+		})
+	}
+}
+
+func TestQuestionTemplate(t *testing.T) {
+	dir := t.TempDir()
+	joke := "{{define \"system\"}}You are a comedian.{{end -}}\nTell a joke about {{.topic}}.\n"
+	if err := os.WriteFile(filepath.Join(dir, "joke.tmpl"), []byte(joke), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		args       []string
+		stdin      string
+		wantSystem string
+		sent       string
+		wantStderr string
+		wantCode   int
+	}{
+		{
+			name:       "built-in template with optional variable",
+			args:       []string{"question", "--template", "summarize", "--var", "length=one sentence"},
+			stdin:      "Go is a statically typed language.",
+			wantSystem: "You are an assistant that writes short, accurate summaries.",
+			sent:       "Summarize the following text in one sentence:\n\nGo is a statically typed language.",
+		},
+		{
+			name:       "user template without input",
+			args:       []string{"question", "--template", "joke", "--var", "topic=gophers"},
+			wantSystem: "You are a comedian.",
+			sent:       "Tell a joke about gophers.",
+		},
+		{
+			name:       "missing variable",
+			args:       []string{"question", "--template", "joke"},
+			wantStderr: `Error: template "joke" needs topic`,
+			wantCode:   int(KindUsage),
+		},
+		{
+			name:       "invalid variable",
+			args:       []string{"question", "--var", "topic", "What is Go?"},
+			wantStderr: `Error: invalid --var "topic", expected name=value`,
+			wantCode:   int(KindUsage),
+		},
+		{
+			name:       "unknown template",
+			args:       []string{"question", "--template", "limerick", "What is Go?"},
+			wantStderr: `Error: template "limerick" not found`,
+			wantCode:   int(KindUsage),
+		},
+		{
+			name:       "template outside the templates directory",
+			args:       []string{"question", "--template", "../secrets/joke", "What is Go?"},
+			wantStderr: `Error: invalid template name "../secrets/joke"`,
+			wantCode:   int(KindUsage),
+		},
+		{
+			name:       "template name with a parent directory",
+			args:       []string{"question", "--template", "..", "What is Go?"},
+			wantStderr: `Error: invalid template name ".."`,
+			wantCode:   int(KindUsage),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := newFakeServer(t)
+			server.Env["TEMPLATES_DIR"] = dir
+			server.Queue(routeAzureChat, azureChat("Done."))
+
+			_, stderr, code := runCommandWithCode(t, server, test.stdin, test.args...)
+			if code != test.wantCode {
+				t.Errorf("exit code %d, want %d", code, test.wantCode)
+			}
+			if !strings.Contains(stderr, test.wantStderr) {
+				t.Errorf("stderr %q does not contain %q", stderr, test.wantStderr)
+			}
+
+			requests := server.Requests(routeAzureChat)
+			if test.sent == "" {
+				if len(requests) != 0 {
+					t.Errorf("got %d requests, want none", len(requests))
+				}
+				return
+			}
+			if len(requests) != 1 {
+				t.Fatalf("got %d requests, want 1", len(requests))
+			}
+			messages := requests[0]["messages"].([]any)
+			if system := messages[0].(map[string]any)["content"].(string); !strings.HasPrefix(system, test.wantSystem) {
+				t.Errorf("system prompt %q, want %q", system, test.wantSystem)
+			}
+			if got := lastMessage(t, requests[0]); got != test.sent {
+				t.Errorf("sent %q, want %q", got, test.sent)
+			}
+		})
+	}
+}
+
+func TestTemplatesCommand(t *testing.T) {
+	server := newFakeServer(t)
+	dir := t.TempDir()
+	server.Env["TEMPLATES_DIR"] = dir
+
+	stdout, _ := runCommand(t, server, "", "templates", "new", "--from", "summarize", "digest")
+	if strings.TrimSpace(stdout) != filepath.Join(dir, "digest.tmpl") {
+		t.Errorf("new printed %q", stdout)
+	}
+
+	tests := []struct {
+		name       string
+		args       []string
+		wantStdout []string
+		wantStderr []string
+		wantCode   int
+	}{
+		{
+			name:       "list",
+			args:       []string{"templates", "list"},
+			wantStdout: []string{"digest           input [length]", filepath.Join(dir, "digest.tmpl"), "question         input", "translate        from input to", "built-in"},
+		},
+		{
+			name:       "show",
+			args:       []string{"templates", "show", "translate"},
+			wantStdout: []string{"You must now translate the following sentence from {{.from}} to {{.to}}: {{.input}}"},
+			wantStderr: []string{"# translate (built-in), variables: from input to"},
+		},
+		{
+			name:       "new refuses to overwrite",
+			args:       []string{"templates", "new", "digest"},
+			wantStderr: []string{"already exists"},
+			wantCode:   int(KindUsage),
+		},
+		{
+			name:       "new rejects paths",
+			args:       []string{"templates", "new", "../digest"},
+			wantStderr: []string{`Error: invalid template name "../digest"`},
+			wantCode:   int(KindUsage),
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			stdout, stderr, code := runCommandWithCode(t, server, "", test.args...)
+			if code != test.wantCode {
+				t.Errorf("exit code %d, want %d", code, test.wantCode)
+			}
+			for _, want := range test.wantStdout {
+				if !strings.Contains(stdout, want) {
+					t.Errorf("stdout %q does not contain %q", stdout, want)
+				}
+			}
+			for _, want := range test.wantStderr {
+				if !strings.Contains(stderr, want) {
+					t.Errorf("stderr %q does not contain %q", stderr, want)
+				}
+			}
+		})
+	}
+}
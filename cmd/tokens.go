@@ -0,0 +1,179 @@
+package cmd
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/pkoukk/tiktoken-go"
+	tiktoken_loader "github.com/pkoukk/tiktoken-go-loader"
+	"github.com/spf13/cobra"
+)
+
+// ModelInfo is what the CLI knows about a model: how many tokens it can take
+// and what they cost. Entries under `models:` in the config file replace the
+// built-in ones with the same name.
+type ModelInfo struct {
+	// ContextWindow is the number of tokens shared by the prompt and the answer, 0 when unknown
+	ContextWindow int `yaml:"context_window"`
+	// InputPrice and OutputPrice are in US dollars per million tokens
+	InputPrice  float64 `yaml:"input_price"`
+	OutputPrice float64 `yaml:"output_price"`
+}
+
+// defaultModels holds the list prices of common models, matched by prefix so
+// dated versions such as gpt-4o-2024-05-13 are found too
+var defaultModels = map[string]ModelInfo{
+	"gpt-4o":           {ContextWindow: 128000, InputPrice: 5, OutputPrice: 15},
+	"gpt-4o-mini":      {ContextWindow: 128000, InputPrice: 0.15, OutputPrice: 0.6},
+	"gpt-4-turbo":      {ContextWindow: 128000, InputPrice: 10, OutputPrice: 30},
+	"gpt-4-32k":        {ContextWindow: 32768, InputPrice: 60, OutputPrice: 120},
+	"gpt-4":            {ContextWindow: 8192, InputPrice: 30, OutputPrice: 60},
+	"gpt-35-turbo":     {ContextWindow: 16385, InputPrice: 0.5, OutputPrice: 1.5},
+	"gpt-35-turbo-16k": {ContextWindow: 16385, InputPrice: 3, OutputPrice: 4},
+	"gpt-3.5-turbo":    {ContextWindow: 16385, InputPrice: 0.5, OutputPrice: 1.5},
+	// Local models cost nothing per token
+	"llama3":   {ContextWindow: 8192},
+	"llama3.1": {ContextWindow: 131072},
+	"mistral":  {ContextWindow: 32768},
+	"phi3":     {ContextWindow: 4096},
+}
+
+// LookupModel returns the entry whose name is the longest prefix of model,
+// looking at the config file before the built-in table
+func LookupModel(model string) (ModelInfo, bool) {
+	model = strings.ToLower(model)
+	for _, table := range []map[string]ModelInfo{activeConfig.Models, defaultModels} {
+		best := ""
+		for name := range table {
+			if strings.HasPrefix(model, strings.ToLower(name)) && len(name) > len(best) {
+				best = name
+			}
+		}
+		if best != "" {
+			return table[best], true
+		}
+	}
+	return ModelInfo{}, false
+}
+
+// EstimateCost returns the price of a request in US dollars, false when the
+// model is not in the price table
+func EstimateCost(model string, usage *Usage) (float64, bool) {
+	info, ok := LookupModel(model)
+	if !ok || usage == nil {
+		return 0, false
+	}
+	return (float64(usage.PromptTokens)*info.InputPrice + float64(usage.CompletionTokens)*info.OutputPrice) / 1e6, true
+}
+
+// FormatCost prints a cost in dollars with enough digits for a single request
+func FormatCost(cost float64) string {
+	return fmt.Sprintf("$%.6f", cost)
+}
+
+func init() {
+	// The encodings are embedded in the binary instead of being downloaded on first use
+	tiktoken.SetBpeLoader(tiktoken_loader.NewOfflineLoader())
+}
+
+var (
+	encodingsMu sync.Mutex
+	encodings   = map[string]*tiktoken.Tiktoken{}
+)
+
+// encodingFor returns the tokenizer of model. Models tiktoken does not know,
+// including local ones, are counted with cl100k_base as an estimate.
+func encodingFor(model string) (*tiktoken.Tiktoken, error) {
+	// Azure names GPT-3.5 deployments gpt-35-turbo
+	name := strings.Replace(strings.ToLower(model), "gpt-35", "gpt-3.5", 1)
+	encodingName := tiktoken.MODEL_CL100K_BASE
+	if encoding, ok := tiktoken.MODEL_TO_ENCODING[name]; ok {
+		encodingName = encoding
+	}
+
+	encodingsMu.Lock()
+	defer encodingsMu.Unlock()
+	if encoding, ok := encodings[encodingName]; ok {
+		return encoding, nil
+	}
+	encoding, err := tiktoken.GetEncoding(encodingName)
+	if err != nil {
+		return nil, err
+	}
+	encodings[encodingName] = encoding
+	return encoding, nil
+}
+
+// CountTokens returns the number of prompt tokens messages take, following
+// the chat format overhead documented by OpenAI: every message costs 3
+// tokens on top of its content and the reply is primed with 3 more
+func CountTokens(model string, messages []Message) (int, error) {
+	encoding, err := encodingFor(model)
+	if err != nil {
+		return 0, err
+	}
+	count := func(text string) int {
+		return len(encoding.Encode(text, nil, nil))
+	}
+
+	tokens := 3
+	for _, message := range messages {
+		tokens += 3 + count(string(message.Role)) + count(message.Content)
+		for _, call := range message.ToolCalls {
+			tokens += count(call.Name) + count(call.Arguments)
+		}
+	}
+	return tokens, nil
+}
+
+// GetMaxTokens returns the value of the --max-tokens flag
+func GetMaxTokens(cmd *cobra.Command) (int32, error) {
+	maxTokens, err := cmd.Flags().GetInt32("max-tokens")
+	if err != nil {
+		return 0, err
+	}
+	if maxTokens < 1 {
+		return 0, Errorf(KindUsage, "--max-tokens must be at least 1, got %d", maxTokens)
+	}
+	return maxTokens, nil
+}
+
+// checkContextWindow counts the prompt tokens before a request is sent and
+// fails when the prompt and the answer cannot both fit in the model's
+// context window. Models without a known window are not checked.
+func checkContextWindow(cmd *cobra.Command, model string, messages []Message, maxTokens int32) error {
+	promptTokens, err := CountTokens(model, messages)
+	if err != nil {
+		return err
+	}
+	info, _ := LookupModel(model)
+
+	if verbose, _ := cmd.Flags().GetBool("verbose"); verbose {
+		window := "unknown"
+		if info.ContextWindow > 0 {
+			window = fmt.Sprint(info.ContextWindow)
+		}
+		fmt.Fprintf(cmd.ErrOrStderr(), "Prompt is %d tokens, asking for up to %d more (context window of %s: %s)\n", promptTokens, maxTokens, model, window)
+	}
+
+	if info.ContextWindow > 0 && promptTokens+int(maxTokens) > info.ContextWindow {
+		return &Error{
+			Kind: KindUsage,
+			Err:  fmt.Errorf("the prompt is %d tokens and up to %d more were asked for, over the %d token context window of %s", promptTokens, maxTokens, info.ContextWindow, model),
+			Hint: "shorten the prompt or lower --max-tokens",
+		}
+	}
+	return nil
+}
+
+// modelNames returns the names in a model table in alphabetical order
+func modelNames(models map[string]ModelInfo) []string {
+	names := make([]string, 0, len(models))
+	for name := range models {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
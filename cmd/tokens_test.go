@@ -0,0 +1,149 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCountTokens(t *testing.T) {
+	tests := []struct {
+		name     string
+		model    string
+		messages []Message
+		want     int
+	}{
+		{name: "no messages", model: "gpt-4", want: 3},
+		{name: "one message", model: "gpt-4", messages: []Message{{Role: RoleUser, Content: "Hello world"}}, want: 3 + 3 + 1 + 2},
+		{name: "azure deployment name", model: "gpt-35-turbo", messages: []Message{{Role: RoleUser, Content: "Hello world"}}, want: 9},
+		{name: "unknown model counts as cl100k_base", model: "llama3:latest", messages: []Message{{Role: RoleUser, Content: "Hello world"}}, want: 9},
+		{
+			name:  "conversation",
+			model: "gpt-4o",
+			messages: []Message{
+				{Role: RoleSystem, Content: "You are a helpful assistant."},
+				{Role: RoleUser, Content: "What is Go?"},
+			},
+			want: 3 + (3 + 1 + 6) + (3 + 1 + 4),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := CountTokens(test.model, test.messages)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != test.want {
+				t.Errorf("got %d tokens, want %d", got, test.want)
+			}
+		})
+	}
+}
+
+func TestLookupModel(t *testing.T) {
+	tests := []struct {
+		model      string
+		wantWindow int
+		wantFound  bool
+	}{
+		{model: "gpt-4o-2024-05-13", wantWindow: 128000, wantFound: true},
+		{model: "gpt-4o-mini", wantWindow: 128000, wantFound: true},
+		{model: "gpt-4-0613", wantWindow: 8192, wantFound: true},
+		{model: "gpt-35-turbo-16k", wantWindow: 16385, wantFound: true},
+		{model: "llama3:latest", wantWindow: 8192, wantFound: true},
+		{model: "my-deployment", wantFound: false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.model, func(t *testing.T) {
+			info, found := LookupModel(test.model)
+			if found != test.wantFound || info.ContextWindow != test.wantWindow {
+				t.Errorf("got %+v, %t, want context window %d, %t", info, found, test.wantWindow, test.wantFound)
+			}
+		})
+	}
+}
+
+func TestEstimateCost(t *testing.T) {
+	cost, ok := EstimateCost("gpt-4o", &Usage{PromptTokens: 1000, CompletionTokens: 100, TotalTokens: 1100})
+	if !ok || FormatCost(cost) != "$0.006500" {
+		t.Errorf("got %s, %t, want $0.006500", FormatCost(cost), ok)
+	}
+	if _, ok := EstimateCost("my-deployment", &Usage{}); ok {
+		t.Error("unknown model has a cost")
+	}
+}
+
+func TestMaxTokens(t *testing.T) {
+	config := filepath.Join(t.TempDir(), "config.yaml")
+	models := "models:\n  gpt-test:\n    context_window: 100\n    input_price: 1000\n    output_price: 2000\n"
+	if err := os.WriteFile(config, []byte(models), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name          string
+		args          []string
+		wantRequest   bool
+		wantMaxTokens float64
+		wantStderr    []string
+		wantCode      int
+	}{
+		{
+			name:          "usage and cost are printed",
+			args:          []string{"question", "--config", config, "--max-tokens", "50", "What is Go?"},
+			wantRequest:   true,
+			wantMaxTokens: 50,
+			wantStderr:    []string{"Usage: 12 prompt + 5 completion = 17 tokens, estimated cost $0.022000"},
+		},
+		{
+			name:       "over the context window",
+			args:       []string{"question", "--config", config, "--max-tokens", "90", "What is Go?"},
+			wantStderr: []string{"Error: the prompt is 38 tokens and up to 90 more were asked for, over the 100 token context window of gpt-test", "Hint: shorten the prompt or lower --max-tokens"},
+			wantCode:   int(KindUsage),
+		},
+		{
+			name:       "invalid max tokens",
+			args:       []string{"translate", "--to", "French", "--max-tokens", "0", "Hello"},
+			wantStderr: []string{"Error: --max-tokens must be at least 1, got 0"},
+			wantCode:   int(KindUsage),
+		},
+		{
+			name:          "unknown model is not checked",
+			args:          []string{"question", "--max-tokens", "100000", "What is Go?"},
+			wantRequest:   true,
+			wantMaxTokens: 100000,
+			wantStderr:    []string{"Usage: 12 prompt + 5 completion = 17 tokens\n"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := newFakeServer(t)
+			server.Queue(routeAzureChat, azureChat("Go is a programming language."))
+
+			_, stderr, code := runCommandWithCode(t, server, "", test.args...)
+			if code != test.wantCode {
+				t.Errorf("exit code %d, want %d", code, test.wantCode)
+			}
+			for _, want := range test.wantStderr {
+				if !strings.Contains(stderr, want) {
+					t.Errorf("stderr %q does not contain %q", stderr, want)
+				}
+			}
+
+			requests := server.Requests(routeAzureChat)
+			if !test.wantRequest {
+				if len(requests) != 0 {
+					t.Errorf("got %d requests, want none", len(requests))
+				}
+				return
+			}
+			if len(requests) != 1 || requests[0]["max_tokens"] != test.wantMaxTokens {
+				t.Errorf("got requests %v, want max_tokens %v", requests, test.wantMaxTokens)
+			}
+		})
+	}
+}
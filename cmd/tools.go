@@ -0,0 +1,243 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultMaxToolIterations stops a model that keeps requesting tools from looping forever
+const DefaultMaxToolIterations = 5
+
+// Tool is a Go function that can be offered to the model
+type Tool struct {
+	Definition ToolDefinition
+	// Call runs the tool with the raw JSON arguments sent by the model
+	Call func(ctx context.Context, arguments string) (any, error)
+}
+
+// ToolRegistry holds the tools a command exposes to the model
+type ToolRegistry struct {
+	tools map[string]Tool
+	names []string
+
+	// Output is where tool calls are reported as they run, stderr by default
+	Output io.Writer
+}
+
+// NewToolRegistry creates an empty registry
+func NewToolRegistry() *ToolRegistry {
+	return &ToolRegistry{tools: map[string]Tool{}, Output: os.Stderr}
+}
+
+// Register adds a tool to the registry, replacing any tool with the same name
+func (r *ToolRegistry) Register(tool Tool) {
+	if _, ok := r.tools[tool.Definition.Name]; !ok {
+		r.names = append(r.names, tool.Definition.Name)
+	}
+	r.tools[tool.Definition.Name] = tool
+}
+
+// RegisterFunc registers fn as a tool whose JSON schema is derived from the
+// fields of T. Fields are named after their json tag, are required unless the
+// tag has omitempty, and can be documented with `description:"..."` and
+// restricted with `enum:"a,b,c"` tags.
+func RegisterFunc[T any](r *ToolRegistry, name string, description string, fn func(ctx context.Context, args T) (any, error)) {
+	r.Register(Tool{
+		Definition: ToolDefinition{
+			Name:        name,
+			Description: description,
+			Parameters:  SchemaFor[T](),
+		},
+		Call: func(ctx context.Context, arguments string) (any, error) {
+			var args T
+			if err := json.Unmarshal([]byte(arguments), &args); err != nil {
+				return nil, fmt.Errorf("invalid arguments: %w", err)
+			}
+			return fn(ctx, args)
+		},
+	})
+}
+
+// Definitions returns the definitions of every registered tool in registration order
+func (r *ToolRegistry) Definitions() []ToolDefinition {
+	definitions := make([]ToolDefinition, 0, len(r.names))
+	for _, name := range r.names {
+		definitions = append(definitions, r.tools[name].Definition)
+	}
+	return definitions
+}
+
+// Dispatch runs a single tool call and returns the JSON to send back to the
+// model. Failures are reported to the model as an error object rather than
+// aborting the conversation, so it can correct itself or explain the problem.
+func (r *ToolRegistry) Dispatch(ctx context.Context, call ToolCall) string {
+	tool, ok := r.tools[call.Name]
+	if !ok {
+		return toolError(fmt.Errorf("unknown tool %q", call.Name))
+	}
+
+	arguments := call.Arguments
+	if strings.TrimSpace(arguments) == "" {
+		arguments = "{}"
+	}
+
+	// Don't trust the model to respect the schema we declared
+	if err := ValidateArguments(tool.Definition.Parameters, arguments); err != nil {
+		return toolError(err)
+	}
+
+	result, err := tool.Call(ctx, arguments)
+	if err != nil {
+		return toolError(err)
+	}
+
+	data, err := json.Marshal(result)
+	if err != nil {
+		return toolError(err)
+	}
+	return string(data)
+}
+
+// CompleteWithTools sends messages to the provider, running every tool call
+// the model makes and feeding the results back until it produces an answer.
+// Tool calls requested together are run in parallel. The returned messages
+// are the full history including the tool round trips.
+func CompleteWithTools(ctx context.Context, provider Provider, registry *ToolRegistry, messages []Message, opts CompletionOptions, maxIterations int) (*Completion, []Message, error) {
+	opts.Tools = registry.Definitions()
+
+	for i := 0; i < maxIterations; i++ {
+		completion, err := provider.Complete(ctx, messages, opts)
+		if err != nil {
+			return nil, messages, err
+		}
+
+		if len(completion.Choices) == 0 || len(completion.Choices[0].ToolCalls) == 0 {
+			return completion, messages, nil
+		}
+
+		// The assistant's tool request has to be part of the history the tool results answer
+		choice := completion.Choices[0]
+		messages = append(messages, Message{Role: RoleAssistant, Content: choice.Content, ToolCalls: choice.ToolCalls})
+
+		results := make([]string, len(choice.ToolCalls))
+		var wg sync.WaitGroup
+		for i, call := range choice.ToolCalls {
+			fmt.Fprintf(registry.Output, "Calling tool %s(%s)\n", call.Name, call.Arguments)
+			wg.Add(1)
+			go func(i int, call ToolCall) {
+				defer wg.Done()
+				results[i] = registry.Dispatch(ctx, call)
+			}(i, call)
+		}
+		wg.Wait()
+
+		for i, call := range choice.ToolCalls {
+			messages = append(messages, Message{Role: RoleTool, Content: results[i], ToolCallID: call.ID})
+		}
+	}
+
+	return nil, messages, fmt.Errorf("gave up after %d rounds of tool calls", maxIterations)
+}
+
+// DefaultTools returns the registry of built-in tools chat commands can expose with --tools
+func DefaultTools() (*ToolRegistry, error) {
+	sourceName := os.Getenv("WEATHER_SOURCE")
+	if sourceName == "" {
+		sourceName = "open-meteo"
+	}
+	weatherSource, err := NewWeatherSource(sourceName)
+	if err != nil {
+		return nil, err
+	}
+
+	registry := NewToolRegistry()
+	RegisterWeatherTool(registry, weatherSource)
+	RegisterFunc(registry, "get_current_time", "Get the current date and time, optionally in a given IANA time zone",
+		func(ctx context.Context, args currentTimeParams) (any, error) {
+			location := time.Local
+			if args.TimeZone != "" {
+				loc, err := time.LoadLocation(args.TimeZone)
+				if err != nil {
+					return nil, err
+				}
+				location = loc
+			}
+			return map[string]string{"time": time.Now().In(location).Format(time.RFC1123)}, nil
+		})
+	return registry, nil
+}
+
+// currentTimeParams are the arguments the model passes to get_current_time
+type currentTimeParams struct {
+	TimeZone string `json:"time_zone,omitempty" description:"IANA time zone, e.g. Europe/London"`
+}
+
+// SchemaFor derives a JSON schema from the fields of the struct type T
+func SchemaFor[T any]() map[string]any {
+	return schemaForType(reflect.TypeOf((*T)(nil)).Elem())
+}
+
+func schemaForType(t reflect.Type) map[string]any {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		properties := map[string]any{}
+		required := []string{}
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			if !field.IsExported() {
+				continue
+			}
+
+			name, options, _ := strings.Cut(field.Tag.Get("json"), ",")
+			if name == "-" {
+				continue
+			}
+			if name == "" {
+				name = field.Name
+			}
+
+			property := schemaForType(field.Type)
+			if description := field.Tag.Get("description"); description != "" {
+				property["description"] = description
+			}
+			if enum := field.Tag.Get("enum"); enum != "" {
+				property["enum"] = strings.Split(enum, ",")
+			}
+			properties[name] = property
+
+			if !strings.Contains(options, "omitempty") {
+				required = append(required, name)
+			}
+		}
+		return map[string]any{"type": "object", "properties": properties, "required": required}
+	case reflect.Slice, reflect.Array:
+		return map[string]any{"type": "array", "items": schemaForType(t.Elem())}
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	}
+	return map[string]any{}
+}
+
+// toolError formats an error as a JSON tool result so the model can explain it to the user
+func toolError(err error) string {
+	result, _ := json.Marshal(map[string]string{"error": err.Error()})
+	return string(result)
+}
@@ -0,0 +1,193 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// scriptedProvider replies with its completions in order, recording the
+// conversation it is sent each time
+type scriptedProvider struct {
+	replies []*Completion
+	sent    [][]Message
+	opts    []CompletionOptions
+}
+
+func (p *scriptedProvider) Complete(ctx context.Context, messages []Message, opts CompletionOptions) (*Completion, error) {
+	p.sent = append(p.sent, append([]Message(nil), messages...))
+	p.opts = append(p.opts, opts)
+	if len(p.replies) == 0 {
+		return nil, errors.New("no reply scripted")
+	}
+	reply := p.replies[0]
+	p.replies = p.replies[1:]
+	return reply, nil
+}
+
+func (p *scriptedProvider) Model() string { return "scripted" }
+
+func answer(content string) *Completion {
+	return &Completion{Choices: []Choice{{Content: content, FinishReason: "stop"}}}
+}
+
+func toolCalls(calls ...ToolCall) *Completion {
+	return &Completion{Choices: []Choice{{FinishReason: "tool_calls", ToolCalls: calls}}}
+}
+
+type echoParams struct {
+	Text string `json:"text"`
+	Case string `json:"case,omitempty" enum:"upper,lower"`
+}
+
+func TestCompleteWithTools(t *testing.T) {
+	call := func(id, arguments string) ToolCall {
+		return ToolCall{ID: id, Name: "echo", Arguments: arguments}
+	}
+
+	tests := []struct {
+		name    string
+		replies []*Completion
+		// wantResults are the tool messages added to the history, in order
+		wantResults []string
+		wantAnswer  string
+		wantErr     string
+	}{
+		{
+			name:       "answer without tools",
+			replies:    []*Completion{answer("Hello.")},
+			wantAnswer: "Hello.",
+		},
+		{
+			name:        "tool result fed back",
+			replies:     []*Completion{toolCalls(call("1", `{"text":"hi","case":"upper"}`)), answer("It said HI.")},
+			wantResults: []string{`"HI"`},
+			wantAnswer:  "It said HI.",
+		},
+		{
+			name:        "parallel calls answered in order",
+			replies:     []*Completion{toolCalls(call("1", `{"text":"a"}`), call("2", `{"text":"b"}`)), answer("Done.")},
+			wantResults: []string{`"a"`, `"b"`},
+			wantAnswer:  "Done.",
+		},
+		{
+			name:        "missing required argument reported to the model",
+			replies:     []*Completion{toolCalls(call("1", `{}`)), answer("Sorry.")},
+			wantResults: []string{`{"error":"arguments.text is required"}`},
+			wantAnswer:  "Sorry.",
+		},
+		{
+			name:        "wrong argument type reported to the model",
+			replies:     []*Completion{toolCalls(call("1", `{"text":7}`)), answer("Sorry.")},
+			wantResults: []string{`{"error":"arguments.text must be of type string"}`},
+			wantAnswer:  "Sorry.",
+		},
+		{
+			name:        "enum violation reported to the model",
+			replies:     []*Completion{toolCalls(call("1", `{"text":"hi","case":"title"}`)), answer("Sorry.")},
+			wantResults: []string{`{"error":"arguments.case must be one of upper, lower"}`},
+			wantAnswer:  "Sorry.",
+		},
+		{
+			name:        "unknown tool reported to the model",
+			replies:     []*Completion{toolCalls(ToolCall{ID: "1", Name: "shout", Arguments: `{}`}), answer("Sorry.")},
+			wantResults: []string{`{"error":"unknown tool \"shout\""}`},
+			wantAnswer:  "Sorry.",
+		},
+		{
+			name:        "empty arguments treated as an empty object",
+			replies:     []*Completion{toolCalls(call("1", ``)), answer("Sorry.")},
+			wantResults: []string{`{"error":"arguments.text is required"}`},
+			wantAnswer:  "Sorry.",
+		},
+		{
+			name: "gives up after the iteration limit",
+			replies: []*Completion{
+				toolCalls(call("1", `{"text":"1"}`)), toolCalls(call("2", `{"text":"2"}`)), toolCalls(call("3", `{"text":"3"}`)),
+				toolCalls(call("4", `{"text":"4"}`)), toolCalls(call("5", `{"text":"5"}`)), answer("Too late."),
+			},
+			wantResults: []string{`"1"`, `"2"`, `"3"`, `"4"`, `"5"`},
+			wantErr:     "gave up after 5 rounds of tool calls",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var output bytes.Buffer
+			registry := NewToolRegistry()
+			registry.Output = &output
+			RegisterFunc(registry, "echo", "Repeat text", func(ctx context.Context, args echoParams) (any, error) {
+				switch args.Case {
+				case "upper":
+					return strings.ToUpper(args.Text), nil
+				case "lower":
+					return strings.ToLower(args.Text), nil
+				}
+				return args.Text, nil
+			})
+			provider := &scriptedProvider{replies: test.replies}
+			messages := []Message{{Role: RoleUser, Content: "Echo something."}}
+
+			completion, history, err := CompleteWithTools(context.Background(), provider, registry, messages, CompletionOptions{}, DefaultMaxToolIterations)
+
+			if test.wantErr != "" {
+				if err == nil || err.Error() != test.wantErr {
+					t.Fatalf("got error %v, want %q", err, test.wantErr)
+				}
+				if len(provider.sent) != DefaultMaxToolIterations {
+					t.Errorf("sent %d requests, want %d", len(provider.sent), DefaultMaxToolIterations)
+				}
+			} else {
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got := completion.Choices[0].Content; got != test.wantAnswer {
+					t.Errorf("answer %q, want %q", got, test.wantAnswer)
+				}
+			}
+
+			var results []string
+			for _, message := range history {
+				if message.Role == RoleTool {
+					results = append(results, message.Content)
+				}
+			}
+			if strings.Join(results, "\n") != strings.Join(test.wantResults, "\n") {
+				t.Errorf("tool results %q, want %q", results, test.wantResults)
+			}
+			// Every tool result follows the assistant message that asked for it
+			for i, message := range history {
+				if message.Role != RoleTool {
+					continue
+				}
+				j := i - 1
+				for j >= 0 && history[j].Role == RoleTool {
+					j--
+				}
+				if j < 0 || history[j].Role != RoleAssistant || !hasToolCall(history[j], message.ToolCallID) {
+					t.Errorf("tool result %q does not answer a preceding tool call", message.Content)
+				}
+			}
+
+			for _, opts := range provider.opts {
+				if len(opts.Tools) != 1 || opts.Tools[0].Name != "echo" {
+					t.Errorf("offered tools %v, want echo", opts.Tools)
+				}
+			}
+			if calls := strings.Count(output.String(), "Calling tool "); calls != len(test.wantResults) {
+				t.Errorf("reported %d tool calls, want %d: %q", calls, len(test.wantResults), output.String())
+			}
+		})
+	}
+}
+
+func hasToolCall(message Message, id string) bool {
+	for _, call := range message.ToolCalls {
+		if call.ID == id {
+			return true
+		}
+	}
+	return false
+}
@@ -1,158 +1,80 @@
 package cmd
 
 import (
-	"context"
-	"fmt"
-	"log"
-	"os"
-
-	"github.com/Azure/azure-sdk-for-go/sdk/ai/azopenai"
-	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
-	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
-	"github.com/joho/godotenv"
+	"errors"
+
 	"github.com/spf13/cobra"
-	"github.com/tmc/langchaingo/llms"
-	"github.com/tmc/langchaingo/llms/ollama"
 )
 
-// exampleCmd represents the example command
+// translateCmd represents the translate command
 var translateCmd = &cobra.Command{
-	Use:   "translate",
+	Use:   "translate [sentence]",
 	Short: "ask the LLM to translate a sentence",
-	Long:  `use this command to ask the LLM to translate a sentence from one language to another`,
-	Run: func(cmd *cobra.Command, args []string) {
-
-		// Load the .env file
-		if err := godotenv.Load(); err != nil {
-			fmt.Fprintf(os.Stderr, "Error loading .env file\n")
-			return
-		}
-
-		// check for "local" flag - if local flag is set, use offline model
-		localFlag := cmd.Flags().Lookup("local")
-		if localFlag != nil && localFlag.Changed {
-
-			selectedOption, err := GetLocalModel()
-			if err != nil {
-				log.Fatal(err)
-			}
-
-			log.Printf("Using local model %s", selectedOption)
-			llm, err := ollama.New(ollama.WithModel(selectedOption))
-			if err != nil {
-				log.Fatal(err)
-			}
-
-			languageA := GetUserInput("Please enter the language you want to translate from: ")
-
-			languageB := GetUserInput("Please enter the language you want to translate to: ")
-
-			sentence := GetUserInput("Please enter the sentence or word you want to translate: ")
-
-			prompt := "You are a professional translator and multi-linguist. You are to strictly only answer language translation questions from the user. You must now translate the following sentence from " + languageA + " to " + languageB + ": " + sentence
-
-			ctx := context.Background()
-			completion, err := llms.GenerateFromSinglePrompt(ctx, llm, prompt)
-			if err != nil {
-				log.Fatal(err)
-			}
-
-			fmt.Println("Response:\n", completion)
-
-		} else {
-
-			// Using online LLM
-			azureOpenAIKey := os.Getenv("AZURE_OPENAI_API_KEY")
-			modelDeploymentID := os.Getenv("YOUR_MODEL_DEPLOYMENT_NAME")
-			maxTokens := int32(400)
-			azureOpenAIEndpoint := os.Getenv("AZURE_OPENAI_ENDPOINT")
-
-			if azureOpenAIKey == "" || modelDeploymentID == "" || azureOpenAIEndpoint == "" {
-				fmt.Fprintf(os.Stderr, "Unable to continue. Environment variables missing\n")
-				return
-			}
-
-			keyCredential := azcore.NewKeyCredential(azureOpenAIKey)
-
-			languageA := GetUserInput("Please enter the language you want to translate from: ")
-
-			languageB := GetUserInput("Please enter the language you want to translate to: ")
-
-			sentence := GetUserInput("Please enter the sentence or word you want to translate: ")
-
-			prompt := "You must now translate the following sentence from " + languageA + " to " + languageB + ": " + sentence
+	Long: `use this command to ask the LLM to translate a sentence from one language to another
 
-			client, err := azopenai.NewClientWithKeyCredential(azureOpenAIEndpoint, keyCredential, nil)
+The sentence can be given as arguments or piped in on stdin, and the languages with --from and --to:
+  echo "Bonjour tout le monde" | go-cli-gpt translate --to English`,
+	RunE: func(cmd *cobra.Command, args []string) error {
 
-			if err != nil {
-				// TODO: Update with application specific error handling logic
-				log.Printf("ERROR: %s", err)
-				return
-			}
-
-			// NOTE: all messages, regardless of role, count against token usage for this API.
-			messages := []azopenai.ChatRequestMessageClassification{
-				// You set the tone and rules of the conversation with a prompt as the system role.
-				&azopenai.ChatRequestSystemMessage{Content: to.Ptr("You are a professional translator and multi-linguist. You are to strictly only answer language translation questions from the user.")},
-
-				// The user asks a question
-				// &azopenai.ChatRequestUserMessage{Content: azopenai.NewChatRequestUserMessageContent("Does Azure OpenAI support customer managed keys?")},
-				&azopenai.ChatRequestUserMessage{Content: azopenai.NewChatRequestUserMessageContent(prompt)},
-
-				// The reply would come back from the model. You'd add it to the conversation so we can maintain context.
-				// &azopenai.ChatRequestAssistantMessage{Content: to.Ptr("Yes, customer managed keys are supported by Azure OpenAI")},
-
-				// The user answers the question based on the latest reply.
-				// &azopenai.ChatRequestUserMessage{Content: azopenai.NewChatRequestUserMessageContent("What other Azure Services support customer managed keys?")},
-
-				// from here you'd keep iterating, sending responses back from ChatGPT
-			}
-
-			gotReply := false
-
-			resp, err := client.GetChatCompletions(context.TODO(), azopenai.ChatCompletionsOptions{
-				// NOTE: all messages count against token usage for this API.
-				Messages:       messages,
-				DeploymentName: &modelDeploymentID,
-				MaxTokens:      &maxTokens,
-			}, nil)
-
-			if err != nil {
-				// TODO: Update with application specific error handling logic
-				log.Printf("ERROR: %s", err)
-				return
-			}
+		provider, err := GetProvider(cmd)
+		if err != nil {
+			return err
+		}
 
-			for _, choice := range resp.Choices {
-				gotReply = true
+		from, _ := cmd.Flags().GetString("from")
+		languageA := GetFlagOrInput(cmd, from, "Please enter the language you want to translate from: ")
+		if languageA == "" {
+			languageA = "whatever language it is written in"
+		}
 
-				if choice.ContentFilterResults != nil {
-					fmt.Fprintf(os.Stderr, "Content filter results\n")
+		to, _ := cmd.Flags().GetString("to")
+		languageB := GetFlagOrInput(cmd, to, "Please enter the language you want to translate to: ")
+		if languageB == "" {
+			return &Error{Kind: KindUsage, Err: errors.New("no target language given"), Hint: "pass the language to translate to with --to"}
+		}
 
-					if choice.ContentFilterResults.Error != nil {
-						fmt.Fprintf(os.Stderr, "  Error:%v\n", choice.ContentFilterResults.Error)
-					}
+		sentence, err := GetInput(cmd, args, "Please enter the sentence or word you want to translate: ")
+		if err != nil {
+			return err
+		}
+		if sentence == "" {
+			return &Error{Kind: KindUsage, Err: errors.New("nothing to translate"), Hint: "pass the sentence as arguments or pipe it on stdin"}
+		}
 
-					fmt.Fprintf(os.Stderr, "  Hate: sev: %v, filtered: %v\n", *choice.ContentFilterResults.Hate.Severity, *choice.ContentFilterResults.Hate.Filtered)
-					fmt.Fprintf(os.Stderr, "  SelfHarm: sev: %v, filtered: %v\n", *choice.ContentFilterResults.SelfHarm.Severity, *choice.ContentFilterResults.SelfHarm.Filtered)
-					fmt.Fprintf(os.Stderr, "  Sexual: sev: %v, filtered: %v\n", *choice.ContentFilterResults.Sexual.Severity, *choice.ContentFilterResults.Sexual.Filtered)
-					fmt.Fprintf(os.Stderr, "  Violence: sev: %v, filtered: %v\n", *choice.ContentFilterResults.Violence.Severity, *choice.ContentFilterResults.Violence.Filtered)
-				}
+		tmpl, err := GetTemplate(cmd, "translate")
+		if err != nil {
+			return err
+		}
+		vars, err := GetTemplateVars(cmd, map[string]string{"from": languageA, "to": languageB, "input": sentence})
+		if err != nil {
+			return err
+		}
 
-				if choice.Message != nil && choice.Message.Content != nil {
-					fmt.Fprintf(os.Stderr, "Content[%d]: %s\n", *choice.Index, *choice.Message.Content)
-				}
+		// NOTE: all messages, regardless of role, count against token usage for this API.
+		// The template sets the tone and rules of the conversation with the system
+		// role and renders the request for the translation as the user message.
+		messages, err := tmpl.Messages(vars)
+		if err != nil {
+			return err
+		}
+		messages, err = ApplySystemPrompt(cmd, messages)
+		if err != nil {
+			return err
+		}
 
-				if choice.FinishReason != nil {
-					// this choice's conversation is complete.
-					fmt.Fprintf(os.Stderr, "Finish reason[%d]: %s\n", *choice.Index, *choice.FinishReason)
-				}
-			}
+		maxTokens, err := GetMaxTokens(cmd)
+		if err != nil {
+			return err
+		}
 
-			if gotReply {
-				fmt.Fprintf(os.Stderr, "Received chat completions reply\n")
-			}
+		opts, err := GetSamplingOptions(cmd, CompletionOptions{MaxTokens: maxTokens})
+		if err != nil {
+			return err
 		}
+
+		completion, err := RunCompletion(cmd, provider, messages, opts)
+		RecordCompletion(cmd, sentence, messages, completion, 0, 0)
+		return err
 	},
 }
 
@@ -160,7 +82,14 @@ func init() {
 	rootCmd.AddCommand(translateCmd)
 
 	// Add local flag to translate command
-	translateCmd.Flags().BoolP("local", "l", false, "Use local model")
+	translateCmd.Flags().BoolP("local", "l", false, "Use local model (same as --provider ollama)")
+	translateCmd.Flags().BoolP("stream", "s", false, "Stream the answer as it is generated")
+	translateCmd.Flags().Int32("max-tokens", 400, "Maximum number of tokens in the answer, checked against the model's context window")
+	translateCmd.Flags().StringP("from", "f", "", "language to translate from (detected when omitted in scripts)")
+	translateCmd.Flags().StringP("to", "t", "", "language to translate to")
+	addTemplateFlags(translateCmd, "translate")
+	addSystemFlags(translateCmd)
+	addSamplingFlags(translateCmd)
 
 	// Here you will define your flags and configuration settings.
 
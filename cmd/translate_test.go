@@ -0,0 +1,96 @@
+package cmd
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestTranslate(t *testing.T) {
+	tests := []struct {
+		name      string
+		args      []string
+		stdin     string
+		route     string
+		responses []fakeResponse
+		// sent is part of the prompt the server should receive
+		sent       string
+		wantStdout []string
+		wantStderr []string
+		wantCode   int
+	}{
+		{
+			name:       "from and to flags",
+			args:       []string{"translate", "--from", "French", "--to", "English", "Bonjour tout le monde"},
+			route:      routeAzureChat,
+			responses:  []fakeResponse{azureChat("Hello everyone")},
+			sent:       "from French to English: Bonjour tout le monde",
+			wantStdout: []string{"Hello everyone\n"},
+		},
+		{
+			name:       "source language detected when omitted",
+			args:       []string{"translate", "-t", "German"},
+			stdin:      "Good morning\n",
+			route:      routeAzureChat,
+			responses:  []fakeResponse{azureChat("Guten Morgen")},
+			sent:       "from whatever language it is written in to German: Good morning",
+			wantStdout: []string{"Guten Morgen\n"},
+		},
+		{
+			name:       "local model",
+			args:       []string{"translate", "--local", "--to", "Spanish", "Thank you"},
+			route:      routeOllamaChat,
+			responses:  []fakeResponse{ollamaChat("Gracias")},
+			sent:       "to Spanish: Thank you",
+			wantStdout: []string{"Gracias\n"},
+		},
+		{
+			name:       "markdown output",
+			args:       []string{"translate", "-o", "markdown", "--to", "Italian", "Good night"},
+			route:      routeAzureChat,
+			responses:  []fakeResponse{azureChat("Buona notte")},
+			sent:       "to Italian: Good night",
+			wantStdout: []string{"Buona notte\n", "model: gpt-test", "finish reason: stop"},
+		},
+		{
+			name:       "target language required in scripts",
+			args:       []string{"translate", "Bonjour"},
+			wantStderr: []string{"Error: no target language given", "Hint: pass the language to translate to with --to"},
+			wantCode:   int(KindUsage),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := newFakeServer(t)
+			server.Queue(test.route, test.responses...)
+
+			stdout, stderr, code := runCommandWithCode(t, server, test.stdin, test.args...)
+
+			if code != test.wantCode {
+				t.Errorf("exit code %d, want %d", code, test.wantCode)
+			}
+
+			for _, want := range test.wantStdout {
+				if !strings.Contains(stdout, want) {
+					t.Errorf("stdout %q does not contain %q", stdout, want)
+				}
+			}
+			for _, want := range test.wantStderr {
+				if !strings.Contains(stderr, want) {
+					t.Errorf("stderr %q does not contain %q", stderr, want)
+				}
+			}
+
+			if test.route == "" {
+				return
+			}
+			requests := server.Requests(test.route)
+			if len(requests) != 1 {
+				t.Fatalf("got %d requests, want 1", len(requests))
+			}
+			if got := lastMessage(t, requests[0]); !strings.Contains(got, test.sent) {
+				t.Errorf("sent %q, want it to contain %q", got, test.sent)
+			}
+		})
+	}
+}
@@ -2,33 +2,136 @@ package cmd
 
 import (
 	"bufio"
+	"context"
+	"errors"
 	"fmt"
+	"io"
 	"os"
+	"strings"
 
 	"github.com/AlecAivazis/survey/v2"
+	"github.com/spf13/cobra"
+	"golang.org/x/term"
 )
 
-func GetUserInput(userPrint string) string {
-	reader := bufio.NewReader(os.Stdin)
-	fmt.Print(userPrint)
+// GetUserInput prints a prompt on the command's output and reads one line of
+// input from its input
+func GetUserInput(cmd *cobra.Command, userPrint string) string {
+	reader := bufio.NewReader(cmd.InOrStdin())
+	fmt.Fprint(cmd.OutOrStdout(), userPrint)
 	userInput, _ := reader.ReadString('\n')
-	return userInput
+	return strings.TrimSpace(userInput)
 }
 
-func GetLocalModel() (string, error) {
-	options := []string{"llama3.1", "phi3", "mistral"}
+// StdinIsTerminal reports whether the command's input is an interactive terminal rather than a pipe or file
+func StdinIsTerminal(cmd *cobra.Command) bool {
+	return isTerminal(cmd.InOrStdin())
+}
+
+// isTerminal reports whether stream, a reader or writer, is an interactive
+// terminal. Streams that are not files, such as the buffers used in tests,
+// and /dev/null count as piped.
+func isTerminal(stream any) bool {
+	file, ok := stream.(*os.File)
+	return ok && term.IsTerminal(int(file.Fd()))
+}
+
+// GetInput collects a command's main input without prompting when possible.
+// Positional arguments and piped stdin are combined, so that
+// `git diff | go-cli-gpt question "review this"` sends both. The user is only
+// prompted when neither was provided and stdin is a terminal.
+func GetInput(cmd *cobra.Command, args []string, userPrint string) (string, error) {
+	input := strings.TrimSpace(strings.Join(args, " "))
+
+	if !StdinIsTerminal(cmd) {
+		data, err := io.ReadAll(cmd.InOrStdin())
+		if err != nil {
+			return "", err
+		}
+		if piped := strings.TrimSpace(string(data)); piped != "" {
+			if input != "" {
+				input += "\n\n"
+			}
+			input += piped
+		}
+		return input, nil
+	}
+
+	if input == "" {
+		input = GetUserInput(cmd, userPrint)
+	}
+	return input, nil
+}
+
+// GetFlagOrInput returns the value of a string flag, prompting for it when it
+// was not given and stdin is a terminal
+func GetFlagOrInput(cmd *cobra.Command, value string, userPrint string) string {
+	if value != "" || !StdinIsTerminal(cmd) {
+		return value
+	}
+	return GetUserInput(cmd, userPrint)
+}
+
+// GetLocalModel picks the Ollama model to use. A requested model is used
+// directly, offering to pull it first if it is not installed. Otherwise the
+// installed models are listed for the user to choose from.
+func GetLocalModel(cmd *cobra.Command, requested string) (string, error) {
+	ctx := context.Background()
+	models, err := ListOllamaModels(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	if requested != "" {
+		if hasOllamaModel(models, requested) {
+			return requested, nil
+		}
+		if !StdinIsTerminal(cmd) {
+			return "", &Error{Kind: KindModelNotFound, Err: fmt.Errorf("model %s is not installed", requested), Hint: fmt.Sprintf("run `ollama pull %s`", requested)}
+		}
+
+		pull := false
+		confirm := &survey.Confirm{
+			Message: fmt.Sprintf("Model %s is not installed. Pull it now?", requested),
+			Default: true,
+		}
+		if err := survey.AskOne(confirm, &pull); err != nil {
+			return "", err
+		}
+		if !pull {
+			return "", Errorf(KindModelNotFound, "model %s is not installed", requested)
+		}
+		if err := PullOllamaModel(ctx, cmd.ErrOrStderr(), requested); err != nil {
+			return "", err
+		}
+		return requested, nil
+	}
+
+	if len(models) == 0 {
+		return "", &Error{Kind: KindModelNotFound, Err: errors.New("no local models installed"), Hint: "pull one with `ollama pull <model>` or use --model"}
+	}
+	if !StdinIsTerminal(cmd) {
+		return "", &Error{Kind: KindUsage, Err: errors.New("no Ollama model given"), Hint: "pass --model or set OLLAMA_MODEL, the model picker needs a terminal"}
+	}
+
+	options := make([]string, 0, len(models))
+	names := map[string]string{}
+	for _, model := range models {
+		option := fmt.Sprintf("%s (%s, modified %s)", model.Name, FormatSize(model.Size), model.ModifiedAt.Format("2006-01-02"))
+		options = append(options, option)
+		names[option] = model.Name
+	}
 
 	var selectedOption string
 	prompt := &survey.Select{
-		Message: "Choose a local model to use (you must have it installed!):",
+		Message: "Choose a local model to use:",
 		Options: options,
 	}
-	err := survey.AskOne(prompt, &selectedOption)
+	err = survey.AskOne(prompt, &selectedOption)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error selecting local model: %v\n", err)
-		return "", err
+		return "", fmt.Errorf("selecting local model: %w", err)
 	}
 
-	fmt.Printf("You selected: %s\n", selectedOption)
-	return selectedOption, nil
+	fmt.Fprintf(cmd.ErrOrStderr(), "You selected: %s\n", names[selectedOption])
+	return names[selectedOption], nil
 }
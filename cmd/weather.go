@@ -0,0 +1,180 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Weather is the current weather reported for a location
+type Weather struct {
+	Location    string  `json:"location"`
+	Temperature float64 `json:"temperature"`
+	Unit        string  `json:"unit"`
+	Description string  `json:"description"`
+	WindSpeed   float64 `json:"wind_speed_kmh"`
+}
+
+// WeatherSource looks up the current weather for a location
+type WeatherSource interface {
+	CurrentWeather(ctx context.Context, location string, unit string) (*Weather, error)
+}
+
+var weatherSources = map[string]func() (WeatherSource, error){
+	"fixture":    newFixtureWeatherSource,
+	"open-meteo": func() (WeatherSource, error) { return &openMeteoWeatherSource{client: httpClient}, nil },
+}
+
+// NewWeatherSource creates the weather source registered under name
+func NewWeatherSource(name string) (WeatherSource, error) {
+	factory, ok := weatherSources[name]
+	if !ok {
+		names := make([]string, 0, len(weatherSources))
+		for n := range weatherSources {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		err := fmt.Errorf("unknown weather source %q (available: %s)", name, strings.Join(names, ", "))
+		return nil, &Error{Kind: KindUsage, Err: err, Hint: "set WEATHER_SOURCE or --weather-source to one of the available sources"}
+	}
+	return factory()
+}
+
+// fixtureWeatherSource serves canned weather so the command can be used offline.
+// The built-in data can be replaced with a JSON file named by WEATHER_FIXTURE_FILE
+// containing an object keyed by lower case city name.
+type fixtureWeatherSource struct {
+	weather map[string]Weather
+}
+
+func newFixtureWeatherSource() (WeatherSource, error) {
+	source := &fixtureWeatherSource{weather: map[string]Weather{
+		"london":   {Location: "London, UK", Temperature: 14, Unit: "celsius", Description: "Light rain", WindSpeed: 18},
+		"paris":    {Location: "Paris, France", Temperature: 17, Unit: "celsius", Description: "Partly cloudy", WindSpeed: 11},
+		"new york": {Location: "New York, USA", Temperature: 21, Unit: "celsius", Description: "Clear sky", WindSpeed: 9},
+		"tokyo":    {Location: "Tokyo, Japan", Temperature: 24, Unit: "celsius", Description: "Overcast", WindSpeed: 7},
+	}}
+
+	if path := os.Getenv("WEATHER_FIXTURE_FILE"); path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		source.weather = map[string]Weather{}
+		if err := json.Unmarshal(data, &source.weather); err != nil {
+			return nil, fmt.Errorf("parsing %s: %w", path, err)
+		}
+	}
+
+	return source, nil
+}
+
+func (s *fixtureWeatherSource) CurrentWeather(ctx context.Context, location string, unit string) (*Weather, error) {
+	city, _, _ := strings.Cut(location, ",")
+	weather, ok := s.weather[strings.ToLower(strings.TrimSpace(city))]
+	if !ok {
+		return nil, fmt.Errorf("no fixture weather for %q", location)
+	}
+
+	if unit == "fahrenheit" && weather.Unit != "fahrenheit" {
+		weather.Temperature = weather.Temperature*9/5 + 32
+		weather.Unit = "fahrenheit"
+	}
+	return &weather, nil
+}
+
+// openMeteoWeatherSource uses the free Open-Meteo geocoding and forecast APIs
+type openMeteoWeatherSource struct {
+	client *http.Client
+}
+
+func (s *openMeteoWeatherSource) CurrentWeather(ctx context.Context, location string, unit string) (*Weather, error) {
+	city, _, _ := strings.Cut(location, ",")
+
+	var places struct {
+		Results []struct {
+			Name      string  `json:"name"`
+			Country   string  `json:"country"`
+			Latitude  float64 `json:"latitude"`
+			Longitude float64 `json:"longitude"`
+		} `json:"results"`
+	}
+	geocodeURL := "https://geocoding-api.open-meteo.com/v1/search?count=1&name=" + url.QueryEscape(strings.TrimSpace(city))
+	if err := s.getJSON(ctx, geocodeURL, &places); err != nil {
+		return nil, err
+	}
+	if len(places.Results) == 0 {
+		return nil, fmt.Errorf("location %q not found", location)
+	}
+	place := places.Results[0]
+
+	if unit == "" {
+		unit = "celsius"
+	}
+	var forecast struct {
+		Current struct {
+			Temperature float64 `json:"temperature_2m"`
+			WeatherCode int     `json:"weather_code"`
+			WindSpeed   float64 `json:"wind_speed_10m"`
+		} `json:"current"`
+	}
+	forecastURL := fmt.Sprintf("https://api.open-meteo.com/v1/forecast?latitude=%f&longitude=%f&current=temperature_2m,weather_code,wind_speed_10m&temperature_unit=%s",
+		place.Latitude, place.Longitude, unit)
+	if err := s.getJSON(ctx, forecastURL, &forecast); err != nil {
+		return nil, err
+	}
+
+	return &Weather{
+		Location:    place.Name + ", " + place.Country,
+		Temperature: forecast.Current.Temperature,
+		Unit:        unit,
+		Description: weatherCodeDescription(forecast.Current.WeatherCode),
+		WindSpeed:   forecast.Current.WindSpeed,
+	}, nil
+}
+
+func (s *openMeteoWeatherSource) getJSON(ctx context.Context, url string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("weather service returned %s", resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// weatherCodeDescription translates a WMO weather interpretation code into words
+func weatherCodeDescription(code int) string {
+	switch {
+	case code == 0:
+		return "Clear sky"
+	case code <= 3:
+		return "Partly cloudy"
+	case code <= 48:
+		return "Fog"
+	case code <= 57:
+		return "Drizzle"
+	case code <= 67:
+		return "Rain"
+	case code <= 77:
+		return "Snow"
+	case code <= 82:
+		return "Rain showers"
+	case code <= 86:
+		return "Snow showers"
+	default:
+		return "Thunderstorm"
+	}
+}